@@ -4,14 +4,48 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/time/rate"
 )
 
 // backend wraps the backend framework and adds a map for storing key value pairs
 type backend struct {
 	*framework.Backend
+
+	// poolLock guards the pre-provisioned token pools in storage. It is
+	// never held across grafana cloud api calls.
+	poolLock sync.Mutex
+	// poolRefillLocks serialize refills of the pool of each access policy
+	// so concurrent refills do not overfill it
+	poolRefillLocks []*locksutil.LockEntry
+	// rotateLock serializes manual and periodic root token rotations and
+	// writes of config/token
+	rotateLock sync.Mutex
+
+	// issueLocks serialize issuance per role so max_tokens is not exceeded by
+	// concurrent requests
+	issueLocks []*locksutil.LockEntry
+	// staticRoleLocks serialize writes and rotations of each static role
+	staticRoleLocks []*locksutil.LockEntry
+	// policyLocks serialize changes to each access policy
+	policyLocks []*locksutil.LockEntry
+
+	rateLimitersLock sync.Mutex
+	rateLimiters     map[string]*rate.Limiter
+	// credsLimiters enforce the rate_limit of each role, keyed by the storage
+	// key of the role or access policy setting it
+	credsLimiters map[string]*rate.Limiter
+
+	// clients caches the client of each configuration by name until the
+	// configuration changes
+	clientsLock sync.RWMutex
+	clients     map[string]GrafanaClient
+	// newClient creates the client of a configuration, replaced by tests
+	newClient func(name string, conf *accessTokenConfig) (GrafanaClient, error)
 }
 
 var _ logical.Factory = Factory
@@ -37,7 +71,17 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 }
 
 func newBackend() (*backend, error) {
-	b := &backend{}
+	b := &backend{
+		rateLimiters:  make(map[string]*rate.Limiter),
+		credsLimiters: make(map[string]*rate.Limiter),
+		clients:       make(map[string]GrafanaClient),
+		issueLocks:    locksutil.CreateLocks(),
+
+		staticRoleLocks: locksutil.CreateLocks(),
+		poolRefillLocks: locksutil.CreateLocks(),
+		policyLocks:     locksutil.CreateLocks(),
+	}
+	b.newClient = b.newConfigClient
 
 	b.Backend = &framework.Backend{
 		Help:        strings.TrimSpace(mockHelp),
@@ -47,7 +91,15 @@ func newBackend() (*backend, error) {
 		),
 		Secrets: []*framework.Secret{
 			secretToken(b),
+			secretStackAPIKey(b),
+			secretStackServiceAccount(b),
+			secretOrgAPIKey(b),
+			secretSyntheticMonitoring(b),
+			secretK6Token(b),
 		},
+		PeriodicFunc: b.periodicFunc,
+		WALRollback:  b.walRollback,
+		Invalidate:   b.invalidate,
 	}
 
 	return b, nil
@@ -56,14 +108,136 @@ func newBackend() (*backend, error) {
 func (b *backend) paths() []*framework.Path {
 	return []*framework.Path{
 		pathConfigToken(b),
+		pathListConfigTokens(b),
+		pathConfigTokens(b),
+		pathConfigTokenVerify(b),
 		pathCredCreate(b),
+		pathCredsStackAPIKey(b),
+		pathCredsStackServiceAccount(b),
+		pathCredsOrgAPIKey(b),
+		pathCredsSyntheticMonitoring(b),
+		pathCredsK6(b),
+		pathListRoles(b),
+		pathRoles(b),
+		pathListStaticRoles(b),
+		pathStaticRoles(b),
+		pathStaticRoleRotate(b),
+		pathStaticCreds(b),
 		pathConfigRotateRoot(b),
 		pathConfigLease(b),
+		pathConfigFeatures(b),
+		pathConfigK6(b),
+		pathListRemoteAccessPolicies(b),
+		pathRemoteAccessPolicies(b),
+		pathListStacks(b),
+		pathStacks(b),
+		pathListTokens(b),
 		pathListAccessPolicies(b),
 		pathAccessPolicies(b),
+		pathAccessPolicySync(b),
+		pathAccessPolicyRename(b),
+		pathAccessPoliciesSync(b),
 	}
 }
 
+// allowCreds reports whether another credential may be issued by the role or
+// access policy stored under key without exceeding perMinute issuances per
+// minute. Limits are tracked per node.
+func (b *backend) allowCreds(key string, perMinute int) bool {
+	b.rateLimitersLock.Lock()
+	defer b.rateLimitersLock.Unlock()
+
+	if perMinute <= 0 {
+		delete(b.credsLimiters, key)
+		return true
+	}
+
+	limit := rate.Limit(float64(perMinute) / 60)
+	limiter, ok := b.credsLimiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(limit, perMinute)
+		b.credsLimiters[key] = limiter
+	} else if limiter.Burst() != perMinute {
+		limiter.SetLimit(limit)
+		limiter.SetBurst(perMinute)
+	}
+
+	return limiter.Allow()
+}
+
+// evictCredsLimiter drops the rate_limit state of the role or access policy
+// stored under key once it is changed or deleted
+func (b *backend) evictCredsLimiter(key string) {
+	b.rateLimitersLock.Lock()
+	defer b.rateLimitersLock.Unlock()
+
+	delete(b.credsLimiters, key)
+}
+
+// apiLimiter returns the limiter shared by the clients of the named
+// configuration, or nil when perSecond is not positive
+func (b *backend) apiLimiter(configName string, perSecond int) *rate.Limiter {
+	b.rateLimitersLock.Lock()
+	defer b.rateLimitersLock.Unlock()
+
+	key := configTokenStorageKey(configName)
+	if perSecond <= 0 {
+		delete(b.rateLimiters, key)
+		return nil
+	}
+
+	limiter, ok := b.rateLimiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(perSecond), perSecond)
+		b.rateLimiters[key] = limiter
+	} else if limiter.Burst() != perSecond {
+		limiter.SetLimit(rate.Limit(perSecond))
+		limiter.SetBurst(perSecond)
+	}
+
+	return limiter
+}
+
+// invalidate drops the cached client of a configuration changed by another
+// node, like a performance standby or replication secondary
+func (b *backend) invalidate(ctx context.Context, key string) {
+	switch {
+	case key == configTokenKey:
+		b.invalidateClient("")
+	case strings.HasPrefix(key, configTokensPrefix):
+		b.invalidateClient(strings.TrimPrefix(key, configTokensPrefix))
+	}
+}
+
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if b.Logger().IsDebug() {
+		features, err := b.FeaturesConfig(ctx, req.Storage)
+		if err != nil {
+			b.Logger().Error("failed to read features", "error", err)
+		} else {
+			b.Logger().Debug("running periodic function", "features", features.toMap())
+		}
+	}
+
+	// The steps are independent, so one failing does not hold up the others
+	steps := []struct {
+		name string
+		run  func(context.Context, logical.Storage) error
+	}{
+		{"rotate root tokens", b.rotateRootTokens},
+		{"delete retired root tokens", b.deleteRetiredRootTokens},
+		{"rotate static roles", b.rotateStaticRoles},
+		{"refill token pools", b.refillTokenPools},
+	}
+	for _, step := range steps {
+		if err := step.run(ctx, req.Storage); err != nil {
+			b.Logger().Error("failed to "+step.name, "error", err)
+		}
+	}
+
+	return nil
+}
+
 const mockHelp = `
 	Generates grafana cloud access tokens using access policies.
 `
@@ -7,19 +7,20 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
 )
 
-func (c *Client) testCreateToken(t *testing.T, body CreateTokenRequest) (*TokenResponse, func()) {
+func testCreateToken(t *testing.T, c *gcom.Client, body gcom.CreateTokenRequest) (*gcom.TokenResponse, func()) {
 	t.Helper()
 
-	token, err := c.CreateToken(body)
+	token, err := c.CreateToken(context.Background(), body)
 	if err != nil {
 		t.Fatal(err)
 	}
 	cleanup := func() {
-		c.DeleteToken(token.ID)
+		c.DeleteToken(context.Background(), token.ID)
 		if err != nil {
 			t.Errorf("failed to delete token '%s'. please ensure it is deleted in grafana cloud. err: %s", token.Name, err.Error())
 		}
@@ -28,19 +29,19 @@ func (c *Client) testCreateToken(t *testing.T, body CreateTokenRequest) (*TokenR
 	return token, cleanup
 }
 
-func testCreateClient(t *testing.T, token string) (*Client, string) {
+func testCreateClient(t *testing.T, token string) (*gcom.Client, string) {
 	t.Helper()
 
-	client, err := createClient(token)
+	client, err := gcom.NewClient(token, gcom.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	decodedToken, err := DecodeToken(token)
+	decodedToken, err := gcom.DecodeToken(token)
 	if err != nil {
 		t.Fatal(err)
 	}
-	tokenResp, err := client.GetTokenByName(decodedToken.TokenName)
+	tokenResp, err := client.GetTokenByName(context.Background(), decodedToken.TokenName)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -65,7 +66,7 @@ func TestBackend_config_token(t *testing.T) {
 	client, ACCESS_POLICY_ID := testCreateClient(t, GRAFANA_TOKEN)
 
 	localTokenName := fmt.Sprintf("integration-test-%d", time.Now().UnixNano())
-	viewerToken, tokenCleanup := client.testCreateToken(t, CreateTokenRequest{
+	viewerToken, tokenCleanup := testCreateToken(t, client, gcom.CreateTokenRequest{
 		AccessPolicyID: ACCESS_POLICY_ID,
 		Name:           localTokenName,
 		DisplayName:    localTokenName,
@@ -75,6 +76,10 @@ func TestBackend_config_token(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer tokenCleanup()
+	decodedViewerToken, err := gcom.DecodeToken(viewerToken.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	testCases := []struct {
 		name                  string
@@ -91,7 +96,7 @@ func TestBackend_config_token(t *testing.T) {
 		{
 			"errorsWithInvalidCredentials",
 			accessTokenConfig{Token: "eyJrIjoiZTcxYjAyZTU0YjliNmExYjYxNDhmODM5MDFlNTE4YWU2N2NjNWQ5MyIsIm4iOiJ0ZXN0LXZhdWx0LWxvY2FsIiwiaWQiOjQ1NjgxOX0="},
-			map[string]interface{}{"error": "failed to get token: error returned from grafana at url 'https://grafana.com/api/v1/tokens?name=test-vault-local&region=' code: InvalidCredentials, err: Token invalid"},
+			map[string]interface{}{"error": "failed to get token: error returned from grafana for GET 'https://grafana.com/api/v1/tokens?name=test-vault-local&region=' status: 401, code: InvalidCredentials, err: Token invalid"},
 			map[string]interface{}{"error": "configuration does not exist. did you configure 'config/token'?"},
 		},
 		{
@@ -99,9 +104,25 @@ func TestBackend_config_token(t *testing.T) {
 			accessTokenConfig{Token: viewerToken.Token},
 			nil,
 			map[string]interface{}{
-				"accessPolicyID": viewerToken.AccessPolicyID,
-				"id":             viewerToken.ID,
-				"token":          viewerToken.Token,
+				"accessPolicyID":          viewerToken.AccessPolicyID,
+				"id":                      viewerToken.ID,
+				"name":                    viewerToken.Name,
+				"expires_at":              viewerToken.ExpiresAt,
+				"decoded_organization":    decodedViewerToken.Organization,
+				"decoded_region":          decodedViewerToken.Metadata.Region,
+				"api_url":                 "",
+				"region":                  "",
+				"region_hosts":            map[string]string(nil),
+				"org_slug":                "",
+				"proxy_url":               "",
+				"ca_cert":                 "",
+				"client_cert":             "",
+				"tls_skip_verify":         false,
+				"api_rate_limit":          0,
+				"root_token_ttl":          int64(0),
+				"rotation_period":         int64(0),
+				"root_token_grace_period": int64(0),
+				"disable_token_read":      true,
 			},
 		},
 	}
@@ -170,7 +191,7 @@ func TestBackend_rotate_root(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
 			localTokenName := fmt.Sprintf("integration-test-%d", time.Now().UnixNano())
-			originalToken, tokenCleanup := client.testCreateToken(t, CreateTokenRequest{
+			originalToken, tokenCleanup := testCreateToken(t, client, gcom.CreateTokenRequest{
 				AccessPolicyID: ACCESS_POLICY_ID,
 				Name:           localTokenName,
 				DisplayName:    localTokenName,
@@ -208,19 +229,19 @@ func TestBackend_rotate_root(t *testing.T) {
 			}
 			newTokenID := resp.Data["id"].(string)
 			defer func() {
-				err := client.DeleteToken(newTokenID)
+				err := client.DeleteToken(context.Background(), newTokenID)
 				if err != nil {
 					t.Fatalf("failed to delete token '%s'. please ensure it is deleted in grafana cloud. err: %s", originalToken.Name, err.Error())
 				}
 			}()
 
 			// Ensure the new token exists and has admin permissions
-			foundToken, err := client.GetToken(newTokenID)
+			foundToken, err := client.GetToken(context.Background(), newTokenID)
 			assert.Nil(t, err)
 			assert.Equal(t, foundToken.AccessPolicyID, ACCESS_POLICY_ID)
 
 			// Ensure that the old token was deleted
-			foundToken, err = client.GetToken(originalToken.ID)
+			foundToken, err = client.GetToken(context.Background(), originalToken.ID)
 			assert.Nil(t, foundToken)
 			assert.Nil(t, err)
 		})
@@ -241,12 +262,12 @@ func TestBackend_token_create(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	decodedToken, err := DecodeToken(GRAFANA_TOKEN)
+	decodedToken, err := gcom.DecodeToken(GRAFANA_TOKEN)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	client, err := createClient(GRAFANA_TOKEN)
+	client, err := gcom.NewClient(GRAFANA_TOKEN, gcom.Options{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -295,7 +316,7 @@ func TestBackend_token_create(t *testing.T) {
 				"policy": testCase.policy,
 			}
 			accessPolicyRequest := &logical.Request{
-				Operation: logical.UpdateOperation,
+				Operation: logical.CreateOperation,
 				Path:      "access_policies/" + localName,
 				Storage:   config.StorageView,
 				Data:      accessPolicyData,
@@ -318,7 +339,7 @@ func TestBackend_token_create(t *testing.T) {
 			}
 
 			createdTokenID, ok := resp.Data["id"].(string)
-			newToken, err := client.GetToken(createdTokenID)
+			newToken, err := client.GetToken(context.Background(), createdTokenID)
 			// Ensures that in the case were we expect an error, but the token is
 			// created successfully that the token is always deleted
 			if ok {
@@ -326,7 +347,7 @@ func TestBackend_token_create(t *testing.T) {
 					t.Fatalf("failed to find token returned by endpoint: newToken:%#v err:%s", newToken, err)
 				}
 				defer func() {
-					err := client.DeleteToken(newToken.Name)
+					err := client.DeleteToken(context.Background(), newToken.Name)
 					if err != nil {
 						t.Fatalf("failed to delete token '%s'. please ensure it is deleted in grafana cloud. err: %s", newToken.Name, err.Error())
 					}
@@ -1,131 +1,67 @@
 package grafanacloud
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
-type Metadata struct {
-	Region string `json:"r"`
-}
-
-type GrafanaToken struct {
-	Organization string   `json:"o"`
-	TokenName    string   `json:"n"`
-	K            string   `json:"k"`
-	Metadata     Metadata `json:"m"`
-}
-
-type CreateTokenRequest struct {
-	AccessPolicyID string    `json:"accessPolicyId"`
-	Name           string    `json:"name"`
-	DisplayName    string    `json:"displayName"`
-	ExpiresAt      time.Time `json:"expiresAt"`
-}
-
-type TokenResponse struct {
-	ID             string    `json:"id"`
-	AccessPolicyID string    `json:"accessPolicyId"`
-	Name           string    `json:"name"`
-	DisplayName    string    `json:"displayName"`
-	ExpiresAt      time.Time `json:"expiresAt"`
-	FirstUsedAt    time.Time `json:"firstUsedAt"`
-	LastUsedAt     time.Time `json:"lastUsedAt"`
-	CreatedAt      time.Time `json:"createdAt"`
-	UpdatedAt      time.Time `json:"updatedAt"`
-	Token          string    `json:"token"`
-}
-
-func DecodeToken(token string) (GrafanaToken, error) {
-	token = strings.TrimPrefix(token, "glc_")
-	decodedToken, err := base64.StdEncoding.DecodeString(token)
-	if err != nil {
-		return GrafanaToken{}, err
-	}
-
-	var grafanaToken GrafanaToken
-	if err := json.Unmarshal(decodedToken, &grafanaToken); err != nil {
-		return GrafanaToken{}, err
-	}
-
-	return grafanaToken, nil
-}
-
-type GrafanaAPIError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
-}
-
-func (e GrafanaAPIError) Error() string {
-	return fmt.Sprintf("failed to perform operation on grafana api code: %s, err: %s", e.Code, e.Message)
-}
-
-type withHeader struct {
-	http.Header
-	rt http.RoundTripper
-}
-
-type Link struct {
-	Rel string `json:"rel"`
-
-	Href string `json:"href"`
-}
-
-type GetTokenResponse struct {
-	Items []TokenResponse `json:"items"`
-}
-
-type AccessPolicy struct {
-	ID          string   `json:"id,omitempty"`
-	OrgID       string   `json:"orgId,omitempty"`
-	Name        string   `json:"name"`
-	DisplayName string   `json:"displayName"`
-	Scopes      []string `json:"scopes"`
-	Realms      []struct {
-		Type          string `json:"type,omitempty"`
-		Identifier    string `json:"identifier,omitempty"`
-		LabelPolicies []struct {
-			Selector string `json:"selector,omitempty"`
-		} `json:"labelPolicies,omitempty"`
-	} `json:"realms,omitempty"`
-	Conditions struct {
-		AllowedSubnets []string `json:"allowedSubnets,omitempty"`
-	} `json:"conditions,omitempty"`
-	CreatedAt time.Time `json:"createdAt,omitempty"`
-	UpdatedAt time.Time `json:"updatedAt,omitempty"`
-}
-
-func WithHeader(rt http.RoundTripper) withHeader {
-	if rt == nil {
-		rt = http.DefaultTransport
-	}
-
-	return withHeader{Header: make(http.Header), rt: rt}
-}
-
-func (h withHeader) RoundTrip(req *http.Request) (*http.Response, error) {
-	for k, v := range h.Header {
-		req.Header[k] = v
-	}
-
-	return h.rt.RoundTrip(req)
-}
-
-type Client struct {
-	BaseURL   string
-	UserAgent string
-
-	httpClient *http.Client
-	region     string
-}
+// GrafanaClient is the part of the grafana cloud api used by the backend.
+// It is implemented by gcom.Client and lets tests substitute an in-memory fake.
+type GrafanaClient interface {
+	ListTokens(ctx context.Context, accessPolicyID string, pageSize int, pageCursor string) (*gcom.GetTokenResponse, error)
+	GetTokenByName(ctx context.Context, name string) (*gcom.TokenResponse, error)
+	GetToken(ctx context.Context, id string) (*gcom.TokenResponse, error)
+	CreateToken(ctx context.Context, body gcom.CreateTokenRequest) (*gcom.TokenResponse, error)
+	UpdateToken(ctx context.Context, id string, expirationDate time.Time) error
+	DeleteToken(ctx context.Context, id string) error
+
+	CreateAccessPolicy(ctx context.Context, body map[string]interface{}) (*gcom.AccessPolicy, error)
+	UpdateAccessPolicy(ctx context.Context, id string, body map[string]interface{}) (*gcom.AccessPolicy, error)
+	GetAccessPolicy(ctx context.Context, id string) (*gcom.AccessPolicy, error)
+	ListAccessPolicies(ctx context.Context, pageSize int, pageCursor string) (*gcom.ListAccessPoliciesResponse, error)
+	GetAccessPolicyByName(ctx context.Context, name string) (*gcom.AccessPolicy, error)
+	DeleteAccessPolicy(ctx context.Context, id string) (bool, error)
+
+	GetOrg(ctx context.Context, slug string) (*gcom.Org, error)
+	GetStack(ctx context.Context, slug string) (*gcom.Stack, error)
+	CachedStack(ctx context.Context, slug string) (*gcom.Stack, error)
+	StackID(ctx context.Context, slug string) (int, error)
+	ListStacks(ctx context.Context, orgSlug string) ([]gcom.Stack, error)
+	CreateStack(ctx context.Context, reqBody gcom.CreateStackRequest) (*gcom.Stack, error)
+	DeleteStack(ctx context.Context, slug string) (bool, error)
+	CreateOrgAPIKey(ctx context.Context, orgSlug string, body gcom.CreateOrgAPIKeyRequest) (*gcom.OrgAPIKey, error)
+	DeleteOrgAPIKey(ctx context.Context, orgSlug string, name string) error
+	CreateStackAPIKey(ctx context.Context, stackSlug string, body gcom.CreateStackAPIKeyRequest) (*gcom.StackAPIKey, error)
+	GetStackAPIKeyByName(ctx context.Context, stackSlug string, name string) (*gcom.StackAPIKey, error)
+	DeleteStackAPIKey(ctx context.Context, stackSlug string, id int) error
+	CreateStackServiceAccount(ctx context.Context, stackSlug string, body gcom.CreateStackServiceAccountRequest) (*gcom.StackServiceAccount, error)
+	CreateStackServiceAccountToken(ctx context.Context, stackSlug string, serviceAccountID int, body gcom.CreateStackServiceAccountTokenRequest) (*gcom.StackServiceAccountToken, error)
+	AssignStackRBACRole(ctx context.Context, stackSlug string, userID int, roleUID string) error
+	GetStackServiceAccountByName(ctx context.Context, stackSlug string, name string) (*gcom.StackServiceAccount, error)
+	DeleteStackServiceAccount(ctx context.Context, stackSlug string, id int) error
+	DeleteStackServiceAccountToken(ctx context.Context, stackSlug string, serviceAccountID int, id int) error
+	InstallSyntheticMonitoring(ctx context.Context, smURL string, reqBody gcom.InstallSyntheticMonitoringRequest) (*gcom.SyntheticMonitoringInstallation, error)
+	CreateSyntheticMonitoringToken(ctx context.Context, smURL string, accessToken string) (string, error)
+	DeleteSyntheticMonitoringToken(ctx context.Context, smURL string, accessToken string, token string) error
+	CreateK6Token(ctx context.Context, k6URL string, adminToken string, orgID int, reqBody gcom.CreateK6TokenRequest) (*gcom.K6Token, error)
+	DeleteK6Token(ctx context.Context, k6URL string, adminToken string, orgID int, id int) error
+
+	// Region is the region sent with every request
+	Region() string
+	// OrgSlug is the slug of the organization of the token
+	OrgSlug() string
+}
+
+var _ GrafanaClient = &gcom.Client{}
 
 func createTokenName(role string) string {
 	lowerRole := strings.ToLower(role)
@@ -133,215 +69,183 @@ func createTokenName(role string) string {
 	return fmt.Sprintf("vault-%s-%d", lowerRole, time.Now().UnixNano())
 }
 
-func (c *Client) performGrafanaAPIOperation(req *http.Request) (*http.Response, error) {
-	newParams := req.URL.Query()
-	newParams.Add("region", c.region)
-	req.URL.RawQuery = newParams.Encode()
+func (b *backend) client(ctx context.Context, s logical.Storage) (GrafanaClient, error) {
+	return b.configClient(ctx, s, "")
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error attempting request: %w", err)
+// configClient returns a client for the named configuration, using the
+// default configuration when name is empty. Clients are cached until
+// invalidateClient is called for the configuration.
+func (b *backend) configClient(ctx context.Context, s logical.Storage, name string) (GrafanaClient, error) {
+	b.clientsLock.RLock()
+	c, ok := b.clients[name]
+	b.clientsLock.RUnlock()
+	if ok {
+		return c, nil
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
-		defer resp.Body.Close()
-		var grafanaError GrafanaAPIError
-		err = json.NewDecoder(resp.Body).Decode(&grafanaError)
-		if err != nil {
-			return nil, fmt.Errorf("error decoding error response from grafana cloud: %w", err)
-		}
-
-		return nil, fmt.Errorf("error returned from grafana at url '%s' code: %s, err: %s", req.URL.String(), grafanaError.Code, grafanaError.Message)
+	b.clientsLock.Lock()
+	defer b.clientsLock.Unlock()
+	if c, ok := b.clients[name]; ok {
+		return c, nil
 	}
 
-	return resp, nil
-}
-
-func (c *Client) GetTokenByName(name string) (*TokenResponse, error) {
-	req, err := http.NewRequest("GET", c.BaseURL+"/tokens", nil)
+	conf, err := b.readConfigToken(ctx, s, name)
 	if err != nil {
 		return nil, err
 	}
-	queryParams := req.URL.Query()
-	queryParams.Add("name", name)
-	req.URL.RawQuery = queryParams.Encode()
-
-	resp, err := c.performGrafanaAPIOperation(req)
-	if err != nil {
-		return nil, err
+	if conf == nil {
+		return nil, fmt.Errorf("configuration does not exist. did you configure '%s'?", configTokenStorageKey(name))
 	}
-	defer resp.Body.Close()
-
-	var jsonResponse GetTokenResponse
-	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	c, err = b.newClient(name, conf)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding get token response: %w", err)
-	}
-
-	if len(jsonResponse.Items) != 1 {
-		return nil, fmt.Errorf("found an unexpected number of tokens with name '%s': %v", name, jsonResponse.Items)
+		return nil, err
 	}
+	b.clients[name] = c
 
-	return &jsonResponse.Items[0], nil
-
+	return c, nil
 }
 
-func (c *Client) GetToken(id string) (*TokenResponse, error) {
-	req, err := http.NewRequest("GET", c.BaseURL+"/tokens/"+id, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := c.performGrafanaAPIOperation(req)
-	if err != nil {
-		return nil, err
+// regionClient returns a client for the named configuration that talks to
+// the api of region instead of the region of the configured token. It is the
+// client of the configuration when region is empty.
+func (b *backend) regionClient(ctx context.Context, s logical.Storage, name string, region string) (GrafanaClient, error) {
+	if region == "" {
+		return b.configClient(ctx, s, name)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, nil
+	key := regionClientKey(name, region)
+	b.clientsLock.RLock()
+	c, ok := b.clients[key]
+	b.clientsLock.RUnlock()
+	if ok {
+		return c, nil
 	}
 
-	var jsonResponse TokenResponse
-	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding get token response: %w", err)
+	b.clientsLock.Lock()
+	defer b.clientsLock.Unlock()
+	if c, ok := b.clients[key]; ok {
+		return c, nil
 	}
 
-	return &jsonResponse, nil
-}
-
-func (c *Client) CreateToken(reqBody CreateTokenRequest) (*TokenResponse, error) {
-	postBody, err := json.Marshal(reqBody)
+	conf, err := b.readConfigToken(ctx, s, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+		return nil, err
 	}
-
-	req, err := http.NewRequest("POST", c.BaseURL+"/tokens", bytes.NewBuffer(postBody))
-	if err != nil {
-		return nil, fmt.Errorf("error creating 'create token' request: %w", err)
+	if conf == nil {
+		return nil, fmt.Errorf("configuration does not exist. did you configure '%s'?", configTokenStorageKey(name))
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.performGrafanaAPIOperation(req)
+	regionConf := *conf
+	regionConf.Region = region
+	c, err = b.newClient(name, &regionConf)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var jsonResponse TokenResponse
-	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding create token response: %w", err)
-	}
+	b.clients[key] = c
 
-	return &jsonResponse, nil
+	return c, nil
 }
 
-func (c *Client) UpdateToken(id string, expirationDate time.Time) error {
-	data, err := json.Marshal(map[string]interface{}{
-		"expiresAt": expirationDate,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
-	req, err := http.NewRequest("POST", c.BaseURL+"/tokens/"+id, bytes.NewBuffer(data))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+// regionClientKey is the key the client of the configuration for region is
+// cached under. Configuration names can not contain '@'.
+func regionClientKey(name string, region string) string {
+	return name + "@" + region
+}
 
-	resp, err := c.performGrafanaAPIOperation(req)
+// newConfigClient creates the client of the named configuration, rate limited
+// and logging through the backend
+func (b *backend) newConfigClient(name string, conf *accessTokenConfig) (GrafanaClient, error) {
+	opts, err := conf.clientOptions()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	opts.Limiter = b.apiLimiter(name, conf.APIRateLimit)
+	opts.Logger = b.Logger().Named("client")
 
-	return nil
+	return gcom.NewClient(conf.Token, opts)
 }
 
-func (c *Client) DeleteToken(id string) error {
-	req, err := http.NewRequest("DELETE", c.BaseURL+"/tokens/"+id, nil)
-	if err != nil {
-		return err
-	}
+// invalidateClient drops the cached client of the named configuration so the
+// next request builds one from the stored configuration
+func (b *backend) invalidateClient(name string) {
+	b.clientsLock.Lock()
+	defer b.clientsLock.Unlock()
 
-	resp, err := c.performGrafanaAPIOperation(req)
-	if err != nil {
-		return err
+	delete(b.clients, name)
+	for key := range b.clients {
+		if strings.HasPrefix(key, name+"@") {
+			delete(b.clients, key)
+		}
 	}
-	defer resp.Body.Close()
-
-	return nil
 }
 
-func (c *Client) CreateAccessPolicy(policy map[string]interface{}) (*AccessPolicy, error) {
-	postBody, err := json.Marshal(policy)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
-	}
-	req, err := http.NewRequest("POST", c.BaseURL+"/accesspolicies", bytes.NewBuffer(postBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.performGrafanaAPIOperation(req)
+// client creates a client authenticated with the configured token against the
+// configured api url, or the host of its region when none is configured. The
+// configured region and organization take precedence over the ones decoded
+// from the token.
+func (conf *accessTokenConfig) client() (*gcom.Client, error) {
+	opts, err := conf.clientOptions()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var jsonResponse AccessPolicy
-	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding create access policy response: %w", err)
-	}
-
-	return &jsonResponse, nil
+	return gcom.NewClient(conf.Token, opts)
 }
 
-func (c *Client) DeleteAccessPolicy(id string) (bool, error) {
-	req, err := http.NewRequest("DELETE", c.BaseURL+"/accesspolicies/"+id, nil)
-	if err != nil {
-		return false, err
-	}
-
-	resp, err := c.performGrafanaAPIOperation(req)
+// clientOptions returns the options of clients of the configuration
+func (conf *accessTokenConfig) clientOptions() (gcom.Options, error) {
+	rt, err := conf.transport()
 	if err != nil {
-		return false, err
+		return gcom.Options{}, err
 	}
-	defer resp.Body.Close()
 
-	return true, nil
+	return gcom.Options{
+		BaseURL:     conf.APIURL,
+		RegionHosts: conf.RegionHosts,
+		Transport:   rt,
+		Region:      conf.Region,
+		OrgSlug:     conf.OrgSlug,
+		OnRequest:   recordAPIRequest,
+	}, nil
 }
 
-func createClient(token string) (*Client, error) {
-	client := &http.Client{
-		Timeout: time.Second * 10,
+// transport returns the transport configured by the proxy and TLS options,
+// or nil for the transport shared by all clients when none are set. Both honor the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables unless a proxy
+// url is configured.
+func (conf *accessTokenConfig) transport() (http.RoundTripper, error) {
+	if conf.ProxyURL == "" && conf.CACert == "" && conf.ClientCert == "" && !conf.TLSSkipVerify {
+		return nil, nil
 	}
 
-	rt := WithHeader(client.Transport)
-	rt.Set("Authorization", "Bearer "+token)
-	client.Transport = rt
-
-	decodedToken, err := DecodeToken(token)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode tokens: %w", err)
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: conf.TLSSkipVerify,
+	}
+	if conf.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(conf.CACert)) {
+			return nil, fmt.Errorf("failed to parse ca_cert: no certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if conf.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(conf.ClientCert), []byte(conf.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client_cert and client_key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
-	return &Client{
-		BaseURL:    "https://grafana.com/api/v1",
-		httpClient: client,
-		region:     decodedToken.Metadata.Region,
-	}, nil
-
-}
-
-func (b *backend) client(ctx context.Context, s logical.Storage) (*Client, error) {
-	conf, err := b.readConfigToken(ctx, s)
-	if err != nil {
-		return nil, err
+	transport := gcom.NewTransport()
+	transport.TLSClientConfig = tlsConfig
+	if conf.ProxyURL != "" {
+		proxyURL, err := url.Parse(conf.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy_url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
 	}
-	return createClient(conf.Token)
+
+	return transport, nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+)
+
+const decodeTokenUsage = `Usage: grafana-cloud decode-token [token]
+
+Prints the organization, token name, and region embedded in a Grafana Cloud
+glc_ token without calling the Grafana Cloud API. The token is read from
+stdin when it is not passed as an argument. The secret part of the token is
+never printed.
+`
+
+// decodeToken implements the decode-token subcommand
+func decodeToken(args []string, stdin io.Reader, stdout io.Writer) error {
+	var token string
+	switch len(args) {
+	case 0:
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read token from stdin: %w", err)
+		}
+		token = line
+	case 1:
+		token = args[0]
+	default:
+		return fmt.Errorf("expected at most one token\n\n%s", decodeTokenUsage)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" || token == "-h" || token == "-help" || token == "--help" {
+		return fmt.Errorf("missing token\n\n%s", decodeTokenUsage)
+	}
+
+	decoded, err := gcom.DecodeToken(token)
+	if err != nil {
+		return fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]string{
+		"organization": decoded.Organization,
+		"name":         decoded.TokenName,
+		"region":       decoded.Metadata.Region,
+	})
+}
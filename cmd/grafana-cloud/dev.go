@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	backend "github.com/bloominlabs/vault-plugin-secrets-grafana-cloud"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const devUsage = `Usage: grafana-cloud -dev [-listen address] [-mount path]
+
+Runs the backend in memory against a bundled fake Grafana Cloud API for local
+experimentation. The backend is configured with a token of the fake API and
+served under /v1/<mount>/ on the listen address with the same requests and
+responses as Vault, so the vault CLI can be pointed at it:
+
+    VAULT_ADDR=http://127.0.0.1:8200 VAULT_TOKEN=dev vault read grafana-cloud/config/token
+
+Leases are kept in memory and can be renewed and revoked through
+sys/leases/renew and sys/leases/revoke. Nothing is persisted and nothing is
+sent to Grafana Cloud.
+`
+
+// devRollbackInterval is how often the periodic function of the backend runs
+// in dev mode, like the rollback manager of vault
+const devRollbackInterval = time.Minute
+
+// runDev implements the -dev mode
+func runDev(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("grafana-cloud -dev", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	listen := flags.String("listen", "127.0.0.1:8200", "address to serve the backend on")
+	mount := flags.String("mount", "grafana-cloud", "path the backend is mounted at")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w\n\n%s", err, devUsage)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s\n\n%s", strings.Join(flags.Args(), " "), devUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fake := newFakeGrafanaCloud()
+	grafanaListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to listen for the fake Grafana Cloud API: %w", err)
+	}
+	grafanaServer := &http.Server{Handler: fake.handler()}
+	go grafanaServer.Serve(grafanaListener)
+	defer grafanaServer.Close()
+	grafanaURL := "http://" + grafanaListener.Addr().String() + "/api/v1"
+
+	dev, err := newDevBackend(ctx, strings.Trim(*mount, "/"))
+	if err != nil {
+		return err
+	}
+	rootToken, err := fake.rootToken()
+	if err != nil {
+		return fmt.Errorf("failed to create the root token of the fake Grafana Cloud API: %w", err)
+	}
+	resp, err := dev.backend.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/token",
+		Storage:   dev.storage,
+		Data: map[string]interface{}{
+			"token":   rootToken,
+			"api_url": grafanaURL,
+		},
+	})
+	if err == nil && resp != nil && resp.IsError() {
+		err = resp.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to configure the backend: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+	}
+	server := &http.Server{Handler: dev}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go dev.rollback(ctx, devRollbackInterval)
+
+	fmt.Fprintf(stdout, "fake Grafana Cloud API listening on %s\n", grafanaURL)
+	fmt.Fprintf(stdout, "backend mounted at %s/ listening on http://%s\n\n", dev.mount, listener.Addr())
+	fmt.Fprintf(stdout, "    export VAULT_ADDR=http://%s VAULT_TOKEN=dev\n", listener.Addr())
+	fmt.Fprintf(stdout, "    vault write %s/access_policies/readers scopes=metrics:read realms=org:{{org_id}}\n", dev.mount)
+	fmt.Fprintf(stdout, "    vault read %s/creds/readers\n", dev.mount)
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// devBackend serves a backend over http the way vault serves a mount of it
+type devBackend struct {
+	backend logical.Backend
+	storage logical.Storage
+	mount   string
+
+	mu     sync.Mutex
+	leases map[string]*logical.Request
+}
+
+func newDevBackend(ctx context.Context, mount string) (*devBackend, error) {
+	config := logical.TestBackendConfig()
+	config.Logger = hclog.New(&hclog.LoggerOptions{Name: mount, Level: hclog.Info})
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := backend.Factory(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backend: %w", err)
+	}
+
+	return &devBackend{
+		backend: b,
+		storage: config.StorageView,
+		mount:   mount,
+		leases:  make(map[string]*logical.Request),
+	}, nil
+}
+
+// rollback runs the periodic function of the backend every interval until
+// ctx is done
+func (d *devBackend) rollback(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			req := logical.RollbackRequest("")
+			req.Storage = d.storage
+			if _, err := d.backend.HandleRequest(ctx, req); err != nil {
+				d.backend.Logger().Error("periodic function failed", "error", err)
+			}
+		}
+	}
+}
+
+func (d *devBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if path == r.URL.Path {
+		logical.RespondError(w, http.StatusNotFound, nil)
+		return
+	}
+
+	data := map[string]interface{}{}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil && err != io.EOF {
+			logical.RespondError(w, http.StatusBadRequest, fmt.Errorf("failed to parse request body: %w", err))
+			return
+		}
+	}
+
+	switch path {
+	case "sys/leases/renew", "sys/renew":
+		d.serveLease(w, r, logical.RenewOperation, data)
+		return
+	case "sys/leases/revoke", "sys/revoke":
+		d.serveLease(w, r, logical.RevokeOperation, data)
+		return
+	}
+	if !strings.HasPrefix(path, d.mount+"/") {
+		logical.RespondError(w, http.StatusNotFound, fmt.Errorf("no handler for route '%s', the backend is mounted at '%s/'", path, d.mount))
+		return
+	}
+
+	req := &logical.Request{
+		Path:    strings.TrimPrefix(path, d.mount+"/"),
+		Data:    data,
+		Storage: d.storage,
+	}
+	switch r.Method {
+	case http.MethodGet:
+		req.Operation = logical.ReadOperation
+		if r.URL.Query().Get("list") == "true" {
+			req.Operation = logical.ListOperation
+		}
+		for key, values := range r.URL.Query() {
+			if key != "list" && len(values) > 0 {
+				req.Data[key] = values[0]
+			}
+		}
+	case "LIST":
+		req.Operation = logical.ListOperation
+	case http.MethodPost, http.MethodPut:
+		// Like vault, writes to paths that do not exist yet are creates
+		req.Operation = logical.UpdateOperation
+		checkFound, exists, err := d.backend.HandleExistenceCheck(r.Context(), req)
+		if err != nil {
+			logical.RespondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if checkFound && !exists {
+			req.Operation = logical.CreateOperation
+		}
+	case http.MethodPatch:
+		req.Operation = logical.PatchOperation
+	case http.MethodDelete:
+		req.Operation = logical.DeleteOperation
+	default:
+		logical.RespondError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if req.Operation == logical.ListOperation && !strings.HasSuffix(req.Path, "/") {
+		req.Path += "/"
+	}
+
+	resp, err := d.backend.HandleRequest(r.Context(), req)
+	d.respond(w, req, resp, err)
+}
+
+// serveLease renews or revokes a lease handed out by the backend
+func (d *devBackend) serveLease(w http.ResponseWriter, r *http.Request, op logical.Operation, data map[string]interface{}) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		logical.RespondError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	leaseID, _ := data["lease_id"].(string)
+	d.mu.Lock()
+	lease, ok := d.leases[leaseID]
+	d.mu.Unlock()
+	if !ok {
+		logical.RespondError(w, http.StatusBadRequest, fmt.Errorf("lease '%s' not found", leaseID))
+		return
+	}
+
+	req := logical.RevokeRequest(lease.Path, lease.Secret, lease.Data)
+	if op == logical.RenewOperation {
+		req = logical.RenewRequest(lease.Path, lease.Secret, lease.Data)
+		if increment, ok := data["increment"].(float64); ok {
+			req.Secret.Increment = time.Duration(increment) * time.Second
+		}
+	}
+	req.Storage = d.storage
+
+	resp, err := d.backend.HandleRequest(r.Context(), req)
+	if err == nil && (resp == nil || !resp.IsError()) {
+		d.mu.Lock()
+		if op == logical.RevokeOperation {
+			delete(d.leases, leaseID)
+		} else if resp != nil && resp.Secret != nil {
+			lease.Secret.TTL = resp.Secret.TTL
+		}
+		d.mu.Unlock()
+	}
+	if resp != nil && resp.Secret != nil {
+		resp.Secret.LeaseID = leaseID
+	}
+	d.respond(w, req, resp, err)
+}
+
+// respond writes the response of the backend like vault's http api
+func (d *devBackend) respond(w http.ResponseWriter, req *logical.Request, resp *logical.Response, err error) {
+	if status, err := logical.RespondErrorCommon(req, resp, err); status != 0 {
+		logical.RespondError(w, status, err)
+		return
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body := map[string]interface{}{
+		"data":     resp.Data,
+		"warnings": resp.Warnings,
+	}
+	if resp.Secret != nil {
+		if resp.Secret.LeaseID == "" {
+			resp.Secret.LeaseID = d.storeLease(req, resp.Secret)
+		}
+		body["lease_id"] = resp.Secret.LeaseID
+		body["lease_duration"] = int(resp.Secret.TTL.Seconds())
+		body["renewable"] = resp.Secret.Renewable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// storeLease remembers the secret of a response so it can be renewed and
+// revoked, and returns its lease id
+func (d *devBackend) storeLease(req *logical.Request, secret *logical.Secret) string {
+	id := make([]byte, 12)
+	rand.Read(id)
+	leaseID := d.mount + "/" + strings.TrimSuffix(req.Path, "/") + "/" + hex.EncodeToString(id)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.leases[leaseID] = &logical.Request{
+		Path:   req.Path,
+		Data:   req.Data,
+		Secret: secret,
+	}
+
+	return leaseID
+}
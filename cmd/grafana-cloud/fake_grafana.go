@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	fakeOrgID   = 1
+	fakeOrgSlug = "dev"
+	fakeRegion  = "dev"
+	fakeStack   = "dev"
+)
+
+// fakeGrafanaCloud is an in-memory Grafana Cloud API serving the endpoints
+// used to issue access policy tokens, so the backend can be tried without a
+// Grafana Cloud organization. Every request is accepted regardless of its
+// token.
+type fakeGrafanaCloud struct {
+	mu       sync.Mutex
+	nextID   int
+	tokens   map[string]*fakeToken
+	policies map[string]fakeAccessPolicy
+}
+
+// fakeToken is a token in the shape returned by the Grafana Cloud API
+type fakeToken struct {
+	ID             string    `json:"id"`
+	AccessPolicyID string    `json:"accessPolicyId"`
+	Name           string    `json:"name"`
+	DisplayName    string    `json:"displayName"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	Token          string    `json:"token,omitempty"`
+}
+
+// fakeAccessPolicy is an access policy as sent by the backend, along with the
+// fields set by the Grafana Cloud API
+type fakeAccessPolicy map[string]interface{}
+
+// fakeTokenSecret is what the secret of a Grafana Cloud token encodes
+type fakeTokenSecret struct {
+	Organization string `json:"o"`
+	Name         string `json:"n"`
+	Key          string `json:"k"`
+	Metadata     struct {
+		Region string `json:"r"`
+	} `json:"m"`
+}
+
+func newFakeGrafanaCloud() *fakeGrafanaCloud {
+	return &fakeGrafanaCloud{
+		tokens:   make(map[string]*fakeToken),
+		policies: make(map[string]fakeAccessPolicy),
+	}
+}
+
+// handler returns the routes of the fake api, rooted like grafana.com/api
+func (f *fakeGrafanaCloud) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/tokens", f.listTokens)
+	mux.HandleFunc("POST /api/v1/tokens", f.createToken)
+	mux.HandleFunc("GET /api/v1/tokens/{id}", f.getToken)
+	mux.HandleFunc("POST /api/v1/tokens/{id}", f.updateToken)
+	mux.HandleFunc("DELETE /api/v1/tokens/{id}", f.deleteToken)
+	mux.HandleFunc("GET /api/v1/accesspolicies", f.listAccessPolicies)
+	mux.HandleFunc("POST /api/v1/accesspolicies", f.createAccessPolicy)
+	mux.HandleFunc("GET /api/v1/accesspolicies/{id}", f.getAccessPolicy)
+	mux.HandleFunc("POST /api/v1/accesspolicies/{id}", f.updateAccessPolicy)
+	mux.HandleFunc("DELETE /api/v1/accesspolicies/{id}", f.deleteAccessPolicy)
+	mux.HandleFunc("GET /api/orgs/{slug}", f.getOrg)
+	mux.HandleFunc("GET /api/orgs/{slug}/instances", f.listStacks)
+	mux.HandleFunc("GET /api/instances/{slug}", f.getStack)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeFakeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %s is not served by the fake Grafana Cloud API", r.Method, r.URL.Path))
+	})
+
+	return mux
+}
+
+// rootToken creates the token the backend is configured with, along with an
+// access policy allowed to manage access policies and tokens
+func (f *fakeGrafanaCloud) rootToken() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policy := f.newAccessPolicy(fakeAccessPolicy{
+		"name":        "vault-dev-root",
+		"displayName": "Vault dev root",
+		"scopes":      []string{"accesspolicies:read", "accesspolicies:write", "accesspolicies:delete"},
+		"realms":      []map[string]interface{}{{"type": "org", "identifier": strconv.Itoa(fakeOrgID)}},
+	})
+	token, err := f.newToken(fakeToken{
+		AccessPolicyID: policy["id"].(string),
+		Name:           "vault-dev-root",
+		DisplayName:    "Vault dev root",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token.Token, nil
+}
+
+// id returns the next id, must be called with mu held
+func (f *fakeGrafanaCloud) id() string {
+	f.nextID++
+	return strconv.Itoa(f.nextID)
+}
+
+// newToken stores a token and returns it with its secret, which is encoded
+// like the tokens of Grafana Cloud so it can be decoded. Must be called with
+// mu held.
+func (f *fakeGrafanaCloud) newToken(token fakeToken) (*fakeToken, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := fakeTokenSecret{
+		Organization: fakeOrgSlug,
+		Name:         token.Name,
+		Key:          hex.EncodeToString(key),
+	}
+	secret.Metadata.Region = fakeRegion
+	encoded, err := json.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ID = f.id()
+	token.CreatedAt = time.Now().UTC()
+	token.UpdatedAt = token.CreatedAt
+	token.Token = ""
+	f.tokens[token.ID] = &token
+
+	created := token
+	created.Token = "glc_" + base64.StdEncoding.EncodeToString(encoded)
+	return &created, nil
+}
+
+// newAccessPolicy stores an access policy with the fields set by Grafana
+// Cloud. Must be called with mu held.
+func (f *fakeGrafanaCloud) newAccessPolicy(policy fakeAccessPolicy) fakeAccessPolicy {
+	now := time.Now().UTC()
+	policy["id"] = f.id()
+	policy["orgId"] = strconv.Itoa(fakeOrgID)
+	if _, ok := policy["status"]; !ok {
+		policy["status"] = "active"
+	}
+	policy["createdAt"] = now
+	policy["updatedAt"] = now
+	f.policies[policy["id"].(string)] = policy
+
+	return policy
+}
+
+func (f *fakeGrafanaCloud) listTokens(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := r.URL.Query().Get("name")
+	accessPolicyID := r.URL.Query().Get("accessPolicyId")
+	items := []*fakeToken{}
+	for _, token := range f.tokens {
+		if (name == "" || token.Name == name) && (accessPolicyID == "" || token.AccessPolicyID == accessPolicyID) {
+			items = append(items, token)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return fakeIDLess(items[i].ID, items[j].ID) })
+
+	writeFakeJSON(w, map[string]interface{}{"items": items})
+}
+
+func (f *fakeGrafanaCloud) createToken(w http.ResponseWriter, r *http.Request) {
+	var body fakeToken
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.policies[body.AccessPolicyID]; !ok {
+		writeFakeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("access policy '%s' not found", body.AccessPolicyID))
+		return
+	}
+	for _, token := range f.tokens {
+		if token.Name == body.Name {
+			writeFakeError(w, http.StatusConflict, "Conflict", fmt.Sprintf("token '%s' already exists", body.Name))
+			return
+		}
+	}
+
+	token, err := f.newToken(body)
+	if err != nil {
+		writeFakeError(w, http.StatusInternalServerError, "Internal", err.Error())
+		return
+	}
+
+	writeFakeJSON(w, token)
+}
+
+func (f *fakeGrafanaCloud) getToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token, ok := f.tokens[r.PathValue("id")]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "token not found")
+		return
+	}
+
+	writeFakeJSON(w, token)
+}
+
+func (f *fakeGrafanaCloud) updateToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token, ok := f.tokens[r.PathValue("id")]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "token not found")
+		return
+	}
+	token.ExpiresAt = body.ExpiresAt
+	token.UpdatedAt = time.Now().UTC()
+
+	writeFakeJSON(w, token)
+}
+
+func (f *fakeGrafanaCloud) deleteToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.tokens[r.PathValue("id")]; !ok {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "token not found")
+		return
+	}
+	delete(f.tokens, r.PathValue("id"))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeGrafanaCloud) listAccessPolicies(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := r.URL.Query().Get("name")
+	items := []fakeAccessPolicy{}
+	for _, policy := range f.policies {
+		if name == "" || policy["name"] == name {
+			items = append(items, policy)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return fakeIDLess(items[i]["id"].(string), items[j]["id"].(string)) })
+
+	writeFakeJSON(w, map[string]interface{}{"items": items})
+}
+
+func (f *fakeGrafanaCloud) createAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy fakeAccessPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, existing := range f.policies {
+		if existing["name"] == policy["name"] {
+			writeFakeError(w, http.StatusConflict, "Conflict", fmt.Sprintf("access policy '%v' already exists", policy["name"]))
+			return
+		}
+	}
+
+	writeFakeJSON(w, f.newAccessPolicy(policy))
+}
+
+func (f *fakeGrafanaCloud) getAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policy, ok := f.policies[r.PathValue("id")]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "access policy not found")
+		return
+	}
+
+	writeFakeJSON(w, policy)
+}
+
+func (f *fakeGrafanaCloud) updateAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	var body fakeAccessPolicy
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policy, ok := f.policies[r.PathValue("id")]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "access policy not found")
+		return
+	}
+	// Fields missing from the body are left as they are
+	for key, value := range body {
+		switch key {
+		case "id", "orgId", "createdAt", "updatedAt":
+		default:
+			policy[key] = value
+		}
+	}
+	policy["updatedAt"] = time.Now().UTC()
+
+	writeFakeJSON(w, policy)
+}
+
+func (f *fakeGrafanaCloud) deleteAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := f.policies[id]; !ok {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "access policy not found")
+		return
+	}
+	delete(f.policies, id)
+	for tokenID, token := range f.tokens {
+		if token.AccessPolicyID == id {
+			delete(f.tokens, tokenID)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeGrafanaCloud) getOrg(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("slug") != fakeOrgSlug {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "organization not found")
+		return
+	}
+
+	writeFakeJSON(w, map[string]interface{}{"id": fakeOrgID, "slug": fakeOrgSlug, "name": "Dev"})
+}
+
+func (f *fakeGrafanaCloud) listStacks(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("slug") != fakeOrgSlug {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "organization not found")
+		return
+	}
+
+	writeFakeJSON(w, map[string]interface{}{
+		"items": []map[string]interface{}{fakeStackInfo()},
+	})
+}
+
+func (f *fakeGrafanaCloud) getStack(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("slug") != fakeStack {
+		writeFakeError(w, http.StatusNotFound, "NotFound", "stack not found")
+		return
+	}
+
+	writeFakeJSON(w, fakeStackInfo())
+}
+
+// fakeStackInfo is the only stack of the fake organization
+func fakeStackInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         1,
+		"slug":       fakeStack,
+		"name":       "Dev",
+		"orgId":      fakeOrgID,
+		"regionSlug": fakeRegion,
+		"status":     "active",
+		"url":        "https://dev.grafana.net",
+	}
+}
+
+// fakeIDLess orders the numeric ids of the fake api
+func fakeIDLess(a string, b string) bool {
+	i, _ := strconv.Atoi(a)
+	j, _ := strconv.Atoi(b)
+	return i < j
+}
+
+func writeFakeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": message})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	backend "github.com/bloominlabs/vault-plugin-secrets-grafana-cloud"
@@ -10,6 +11,21 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "decode-token" {
+		if err := decodeToken(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && (os.Args[1] == "-dev" || os.Args[1] == "--dev") {
+		if err := runDev(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
 	flags.Parse(os.Args[1:])
@@ -0,0 +1,91 @@
+package grafanacloud
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+)
+
+const (
+	// bundleTraces adds the Tempo endpoint of the stack to issued tokens
+	bundleTraces = "traces"
+	// bundleOTLP adds the OTLP gateway of the stack, along with a basic auth
+	// header of the token, to issued tokens
+	bundleOTLP = "otlp"
+	// bundleProfiles adds the Pyroscope endpoint of the stack to issued
+	// tokens
+	bundleProfiles = "profiles"
+	// bundleAlerting adds the ruler and alertmanager endpoints of the stack,
+	// along with their tenants, to issued tokens
+	bundleAlerting = "alerting"
+)
+
+// supportedBundles are the connection details roles can add to issued tokens
+var supportedBundles = []string{
+	bundleTraces,
+	bundleOTLP,
+	bundleProfiles,
+	bundleAlerting,
+}
+
+// stackGrafanaURL returns the URL of the Grafana instance of the stack, which
+// is returned with credentials of roles bound to it
+func stackGrafanaURL(slug string) string {
+	return "https://" + slug + ".grafana.net"
+}
+
+// credentialBundle returns the connection details of the stack for bundle,
+// which are added to the data of a token so clients can be configured from a
+// single read. The instance ID is the username to authenticate with along
+// with the token.
+func credentialBundle(bundle string, stack *gcom.Stack, token string) map[string]interface{} {
+	switch bundle {
+	case bundleTraces:
+		return map[string]interface{}{
+			"stack_slug":         stack.Slug,
+			"traces_instance_id": stack.HtInstanceID,
+			"traces_url":         stack.HtInstanceURL,
+			"traces_endpoint":    grpcEndpoint(stack.HtInstanceURL),
+			"username":           stack.HtInstanceID,
+		}
+	case bundleOTLP:
+		// The gateway authenticates with the id of the stack itself
+		credentials := base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(stack.ID) + ":" + token))
+		return map[string]interface{}{
+			"stack_slug":    stack.Slug,
+			"otlp_endpoint": otlpGatewayURL(stack.RegionSlug),
+			"username":      stack.ID,
+			"authorization": "Basic " + credentials,
+			// Values of OTEL_EXPORTER_OTLP_HEADERS are url encoded
+			"otlp_headers": "Authorization=Basic%20" + credentials,
+		}
+	case bundleProfiles:
+		return map[string]interface{}{
+			"stack_slug":           stack.Slug,
+			"profiles_instance_id": stack.HpInstanceID,
+			"profiles_url":         stack.HpInstanceURL,
+			"username":             stack.HpInstanceID,
+		}
+	case bundleAlerting:
+		// The rulers are served by the metrics and logs instances, whose ids
+		// are the tenants of the rules
+		return map[string]interface{}{
+			"stack_slug":             stack.Slug,
+			"metrics_ruler_url":      stack.HmInstancePromURL,
+			"metrics_tenant_id":      stack.HmInstancePromID,
+			"logs_ruler_url":         stack.HlInstanceURL,
+			"logs_tenant_id":         stack.HlInstanceID,
+			"alertmanager_url":       stack.AmInstanceURL,
+			"alertmanager_tenant_id": stack.AmInstanceID,
+		}
+	default:
+		return nil
+	}
+}
+
+// otlpGatewayURL returns the url of the OTLP gateway serving stacks in the
+// region, as expected by OTEL_EXPORTER_OTLP_ENDPOINT
+func otlpGatewayURL(regionSlug string) string {
+	return "https://otlp-gateway-" + regionSlug + ".grafana.net/otlp"
+}
@@ -0,0 +1,60 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_creds_bundle_fake(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/tracing",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"policy": `{"displayName": "Tracing", "scopes": ["traces:write"], "realms": [{"type": "org", "identifier": "1"}]}`,
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create access policy: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/tracing",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"access_policy": "tracing",
+			"stack_slug":    "mystack",
+			"bundle":        bundleTraces,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/tracing",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Equal(t, "tempo-prod-04-prod-us-central-0.grafana.net:443", resp.Data["traces_endpoint"])
+	assert.Equal(t, 4, resp.Data["username"])
+}
+
+func TestCredentialBundle_otlp(t *testing.T) {
+	stack := &gcom.Stack{ID: 123, Slug: "mystack", RegionSlug: "prod-eu-west-0"}
+
+	bundle := credentialBundle(bundleOTLP, stack, "glc_token")
+	assert.Equal(t, "https://otlp-gateway-prod-eu-west-0.grafana.net/otlp", bundle["otlp_endpoint"])
+	// base64 of "123:glc_token"
+	assert.Equal(t, "Authorization=Basic%20MTIzOmdsY190b2tlbg==", bundle["otlp_headers"])
+}
@@ -0,0 +1,512 @@
+package grafanacloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fakeClient is an in-memory GrafanaClient for tests that do not need a real
+// grafana cloud organization
+type fakeClient struct {
+	mu       sync.Mutex
+	nextID   int
+	tokens   map[string]*gcom.TokenResponse
+	policies map[string]*gcom.AccessPolicy
+	// stackKeys holds the stack API keys by stack slug and id
+	stackKeys map[string]map[int]*gcom.StackAPIKey
+	// serviceAccounts holds the stack service accounts by stack slug and id
+	serviceAccounts map[string]map[int]*gcom.StackServiceAccount
+	// orgKeys holds the legacy api keys of the organization by name
+	orgKeys map[string]*gcom.OrgAPIKey
+	// smTokens holds the synthetic monitoring tokens of every stack
+	smTokens map[string]map[string]bool
+	// smInstalls counts the installations of synthetic monitoring
+	smInstalls int
+	// k6Tokens holds the k6 api tokens of every organization by id
+	k6Tokens map[int]map[int]*gcom.K6Token
+	// rbacRoles holds the rbac roles granted to every service account
+	rbacRoles map[int][]string
+}
+
+var _ GrafanaClient = &fakeClient{}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		tokens:    map[string]*gcom.TokenResponse{},
+		policies:  map[string]*gcom.AccessPolicy{},
+		stackKeys: map[string]map[int]*gcom.StackAPIKey{},
+
+		serviceAccounts: map[string]map[int]*gcom.StackServiceAccount{},
+		orgKeys:         map[string]*gcom.OrgAPIKey{},
+		smTokens:        map[string]map[string]bool{},
+		k6Tokens:        map[int]map[int]*gcom.K6Token{},
+		rbacRoles:       map[int][]string{},
+	}
+}
+
+func (f *fakeClient) id() string {
+	f.nextID++
+	return strconv.Itoa(f.nextID)
+}
+
+func (f *fakeClient) ListTokens(ctx context.Context, accessPolicyID string, pageSize int, pageCursor string) (*gcom.GetTokenResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resp := &gcom.GetTokenResponse{}
+	for _, token := range f.tokens {
+		if accessPolicyID == "" || token.AccessPolicyID == accessPolicyID {
+			resp.Items = append(resp.Items, *token)
+		}
+	}
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].ID < resp.Items[j].ID })
+
+	return resp, nil
+}
+
+func (f *fakeClient) GetTokenByName(ctx context.Context, name string) (*gcom.TokenResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, token := range f.tokens {
+		if token.Name == name {
+			found := *token
+			return &found, nil
+		}
+	}
+
+	return nil, fmt.Errorf("token '%s': %w", name, gcom.ErrNotFound)
+}
+
+func (f *fakeClient) GetToken(ctx context.Context, id string) (*gcom.TokenResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token, ok := f.tokens[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *token
+
+	return &found, nil
+}
+
+func (f *fakeClient) CreateToken(ctx context.Context, body gcom.CreateTokenRequest) (*gcom.TokenResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.policies[body.AccessPolicyID]; !ok {
+		return nil, fmt.Errorf("access policy '%s': %w", body.AccessPolicyID, gcom.ErrNotFound)
+	}
+	for _, token := range f.tokens {
+		if token.Name == body.Name {
+			return nil, fmt.Errorf("token '%s': %w", body.Name, gcom.ErrConflict)
+		}
+	}
+
+	token := &gcom.TokenResponse{
+		ID:             f.id(),
+		AccessPolicyID: body.AccessPolicyID,
+		Name:           body.Name,
+		DisplayName:    body.DisplayName,
+		ExpiresAt:      body.ExpiresAt,
+		Token:          "glc_fake_" + body.Name,
+	}
+	f.tokens[token.ID] = token
+	created := *token
+
+	return &created, nil
+}
+
+func (f *fakeClient) UpdateToken(ctx context.Context, id string, expirationDate time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token, ok := f.tokens[id]
+	if !ok {
+		return fmt.Errorf("token '%s': %w", id, gcom.ErrNotFound)
+	}
+	token.ExpiresAt = expirationDate
+
+	return nil
+}
+
+func (f *fakeClient) DeleteToken(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.tokens, id)
+
+	return nil
+}
+
+func (f *fakeClient) CreateAccessPolicy(ctx context.Context, body map[string]interface{}) (*gcom.AccessPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policy := &gcom.AccessPolicy{ID: f.id()}
+	if err := applyPolicyBody(policy, body); err != nil {
+		return nil, err
+	}
+	f.policies[policy.ID] = policy
+	created := *policy
+
+	return &created, nil
+}
+
+func (f *fakeClient) UpdateAccessPolicy(ctx context.Context, id string, body map[string]interface{}) (*gcom.AccessPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policy, ok := f.policies[id]
+	if !ok {
+		return nil, nil
+	}
+	if err := applyPolicyBody(policy, body); err != nil {
+		return nil, err
+	}
+	updated := *policy
+
+	return &updated, nil
+}
+
+func (f *fakeClient) GetAccessPolicy(ctx context.Context, id string) (*gcom.AccessPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	policy, ok := f.policies[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *policy
+
+	return &found, nil
+}
+
+func (f *fakeClient) ListAccessPolicies(ctx context.Context, pageSize int, pageCursor string) (*gcom.ListAccessPoliciesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resp := &gcom.ListAccessPoliciesResponse{}
+	for _, policy := range f.policies {
+		resp.Items = append(resp.Items, *policy)
+	}
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].ID < resp.Items[j].ID })
+
+	return resp, nil
+}
+
+func (f *fakeClient) GetAccessPolicyByName(ctx context.Context, name string) (*gcom.AccessPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, policy := range f.policies {
+		if policy.Name == name {
+			found := *policy
+			return &found, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *fakeClient) DeleteAccessPolicy(ctx context.Context, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, existed := f.policies[id]
+	delete(f.policies, id)
+
+	return existed, nil
+}
+
+func (f *fakeClient) GetOrg(ctx context.Context, slug string) (*gcom.Org, error) {
+	return &gcom.Org{ID: 1, Slug: slug, Name: slug}, nil
+}
+
+// GetStack returns a stack for every slug besides "missing"
+func (f *fakeClient) GetStack(ctx context.Context, slug string) (*gcom.Stack, error) {
+	if slug == "missing" {
+		return nil, nil
+	}
+
+	return &gcom.Stack{
+		ID:               1,
+		Slug:             slug,
+		RegionSlug:       "prod-us-central-0",
+		HmInstancePromID: 2,
+		HlInstanceID:     3,
+		HtInstanceID:     4,
+		HtInstanceURL:    "https://tempo-prod-04-prod-us-central-0.grafana.net",
+		HpInstanceID:     5,
+		HpInstanceURL:    "https://profiles-prod-001.grafana.net",
+	}, nil
+}
+
+func (f *fakeClient) CachedStack(ctx context.Context, slug string) (*gcom.Stack, error) {
+	return f.GetStack(ctx, slug)
+}
+
+func (f *fakeClient) StackID(ctx context.Context, slug string) (int, error) {
+	stack, err := f.GetStack(ctx, slug)
+	if err != nil {
+		return 0, err
+	}
+	if stack == nil {
+		return 0, fmt.Errorf("stack '%s' does not exist", slug)
+	}
+
+	return stack.ID, nil
+}
+
+func (f *fakeClient) ListStacks(ctx context.Context, orgSlug string) ([]gcom.Stack, error) {
+	return []gcom.Stack{
+		{ID: 1, Slug: "mystack", Name: "mystack", RegionSlug: "prod-us-central-0", Status: "active"},
+	}, nil
+}
+
+func (f *fakeClient) CreateStack(ctx context.Context, body gcom.CreateStackRequest) (*gcom.Stack, error) {
+	return &gcom.Stack{ID: 2, Slug: body.Slug, Name: body.Name, RegionSlug: body.Region, Status: "active"}, nil
+}
+
+func (f *fakeClient) DeleteStack(ctx context.Context, slug string) (bool, error) {
+	return true, nil
+}
+
+func (f *fakeClient) InstallSyntheticMonitoring(ctx context.Context, smURL string, body gcom.InstallSyntheticMonitoringRequest) (*gcom.SyntheticMonitoringInstallation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.smInstalls++
+	return &gcom.SyntheticMonitoringInstallation{AccessToken: "sm-access-" + strconv.Itoa(body.StackID)}, nil
+}
+
+func (f *fakeClient) CreateSyntheticMonitoringToken(ctx context.Context, smURL string, accessToken string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.smTokens[accessToken] == nil {
+		f.smTokens[accessToken] = map[string]bool{}
+	}
+	token := "sm-token-" + f.id()
+	f.smTokens[accessToken][token] = true
+
+	return token, nil
+}
+
+func (f *fakeClient) DeleteSyntheticMonitoringToken(ctx context.Context, smURL string, accessToken string, token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.smTokens[accessToken], token)
+
+	return nil
+}
+
+func (f *fakeClient) CreateOrgAPIKey(ctx context.Context, orgSlug string, body gcom.CreateOrgAPIKeyRequest) (*gcom.OrgAPIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.orgKeys[body.Name]; ok {
+		return nil, fmt.Errorf("api key '%s': %w", body.Name, gcom.ErrConflict)
+	}
+	f.nextID++
+	key := &gcom.OrgAPIKey{ID: f.nextID, Name: body.Name, Role: body.Role, Token: "eyJrIjoib3JnIn0="}
+	f.orgKeys[key.Name] = key
+	created := *key
+
+	return &created, nil
+}
+
+func (f *fakeClient) DeleteOrgAPIKey(ctx context.Context, orgSlug string, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.orgKeys, name)
+
+	return nil
+}
+
+func (f *fakeClient) CreateK6Token(ctx context.Context, k6URL string, adminToken string, orgID int, body gcom.CreateK6TokenRequest) (*gcom.K6Token, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.k6Tokens[orgID] == nil {
+		f.k6Tokens[orgID] = map[int]*gcom.K6Token{}
+	}
+	f.nextID++
+	token := &gcom.K6Token{ID: f.nextID, Name: body.Name, ProjectID: body.ProjectID, Token: "k6-token"}
+	f.k6Tokens[orgID][token.ID] = token
+	created := *token
+
+	return &created, nil
+}
+
+func (f *fakeClient) DeleteK6Token(ctx context.Context, k6URL string, adminToken string, orgID int, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.k6Tokens[orgID], id)
+
+	return nil
+}
+
+func (f *fakeClient) CreateStackAPIKey(ctx context.Context, stackSlug string, body gcom.CreateStackAPIKeyRequest) (*gcom.StackAPIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	key := &gcom.StackAPIKey{ID: f.nextID, Name: body.Name, Key: "eyJrIjoiZmFrZSJ9"}
+	if f.stackKeys[stackSlug] == nil {
+		f.stackKeys[stackSlug] = map[int]*gcom.StackAPIKey{}
+	}
+	f.stackKeys[stackSlug][key.ID] = key
+	created := *key
+
+	return &created, nil
+}
+
+func (f *fakeClient) GetStackAPIKeyByName(ctx context.Context, stackSlug string, name string) (*gcom.StackAPIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, key := range f.stackKeys[stackSlug] {
+		if key.Name == name {
+			found := *key
+			return &found, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *fakeClient) DeleteStackAPIKey(ctx context.Context, stackSlug string, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.stackKeys[stackSlug], id)
+
+	return nil
+}
+
+func (f *fakeClient) CreateStackServiceAccount(ctx context.Context, stackSlug string, body gcom.CreateStackServiceAccountRequest) (*gcom.StackServiceAccount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	account := &gcom.StackServiceAccount{ID: f.nextID, Name: body.Name, Login: "sa-" + body.Name, Role: body.Role}
+	if f.serviceAccounts[stackSlug] == nil {
+		f.serviceAccounts[stackSlug] = map[int]*gcom.StackServiceAccount{}
+	}
+	f.serviceAccounts[stackSlug][account.ID] = account
+	created := *account
+
+	return &created, nil
+}
+
+func (f *fakeClient) CreateStackServiceAccountToken(ctx context.Context, stackSlug string, serviceAccountID int, body gcom.CreateStackServiceAccountTokenRequest) (*gcom.StackServiceAccountToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.serviceAccounts[stackSlug][serviceAccountID]; !ok {
+		return nil, fmt.Errorf("service account '%d': %w", serviceAccountID, gcom.ErrNotFound)
+	}
+	f.nextID++
+
+	return &gcom.StackServiceAccountToken{ID: f.nextID, Name: body.Name, Key: "glsa_fake"}, nil
+}
+
+func (f *fakeClient) GetStackServiceAccountByName(ctx context.Context, stackSlug string, name string) (*gcom.StackServiceAccount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, account := range f.serviceAccounts[stackSlug] {
+		if account.Name == name {
+			found := *account
+			return &found, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *fakeClient) DeleteStackServiceAccount(ctx context.Context, stackSlug string, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.serviceAccounts[stackSlug], id)
+
+	return nil
+}
+
+func (f *fakeClient) AssignStackRBACRole(ctx context.Context, stackSlug string, userID int, roleUID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.serviceAccounts[stackSlug][userID]; !ok {
+		return fmt.Errorf("service account '%d': %w", userID, gcom.ErrNotFound)
+	}
+	f.rbacRoles[userID] = append(f.rbacRoles[userID], roleUID)
+
+	return nil
+}
+
+func (f *fakeClient) DeleteStackServiceAccountToken(ctx context.Context, stackSlug string, serviceAccountID int, id int) error {
+	return nil
+}
+
+func (f *fakeClient) Region() string {
+	return "prod-test-0"
+}
+
+func (f *fakeClient) OrgSlug() string {
+	return "test-org"
+}
+
+// applyPolicyBody sets the fields of policy present in a request body
+func applyPolicyBody(policy *gcom.AccessPolicy, body map[string]interface{}) error {
+	in, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(in, policy)
+}
+
+// testFakeBackend returns a backend configured with config/token whose
+// configurations all use the returned fake client
+func testFakeBackend(t *testing.T) (*backend, logical.Storage, *fakeClient) {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Factory(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeClient()
+	backend := b.(*backend)
+	backend.newClient = func(name string, conf *accessTokenConfig) (GrafanaClient, error) {
+		return fake, nil
+	}
+
+	entry, err := logical.StorageEntryJSON(configTokenKey, accessTokenConfig{Token: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.StorageView.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	return backend, config.StorageView, fake
+}
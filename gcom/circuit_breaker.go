@@ -0,0 +1,73 @@
+package gcom
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold is the number of consecutive failed requests after
+	// which requests fail fast
+	breakerThreshold = 5
+	// breakerCooldown is how long requests fail fast before a single probe
+	// request is let through
+	breakerCooldown = 30 * time.Second
+)
+
+// ErrAPIUnavailable is returned without sending the request while grafana
+// cloud is considered down
+var ErrAPIUnavailable = errors.New("grafana cloud api unavailable")
+
+// circuitBreaker stops requests from waiting on timeouts while grafana cloud
+// is down. It opens after breakerThreshold consecutive failures, and once
+// breakerCooldown has passed lets one request through to probe whether the
+// api recovered.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a request may be sent
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures < breakerThreshold {
+		return nil
+	}
+	if cb.probing || time.Since(cb.openedAt) < breakerCooldown {
+		return ErrAPIUnavailable
+	}
+	cb.probing = true
+
+	return nil
+}
+
+// record reports the outcome of a request let through by allow
+func (cb *circuitBreaker) record(failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+	if !failed {
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.failures >= breakerThreshold {
+		cb.openedAt = time.Now()
+	}
+}
+
+// release gives up a request let through by allow without an outcome, like
+// one cancelled by its caller
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+}
@@ -0,0 +1,46 @@
+package gcom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	testCases := []struct {
+		name       string
+		failures   int
+		cooledDown bool
+		allow      error
+		// then reports the outcome of the request let through, if any,
+		// before allow is called again
+		then      func(cb *circuitBreaker)
+		nextAllow error
+	}{
+		{"allowsRequestsBelowTheThreshold", breakerThreshold - 1, false, nil, nil, nil},
+		{"failsFastOnceOpen", breakerThreshold, false, ErrAPIUnavailable, nil, ErrAPIUnavailable},
+		{"letsOneProbeThroughAfterTheCooldown", breakerThreshold, true, nil, nil, ErrAPIUnavailable},
+		{"closesWhenTheProbeSucceeds", breakerThreshold, true, nil, func(cb *circuitBreaker) { cb.record(false) }, nil},
+		{"reopensWhenTheProbeFails", breakerThreshold, true, nil, func(cb *circuitBreaker) { cb.record(true) }, ErrAPIUnavailable},
+		{"probesAgainWhenTheProbeIsReleased", breakerThreshold, true, nil, func(cb *circuitBreaker) { cb.release() }, nil},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cb := &circuitBreaker{}
+			for i := 0; i < testCase.failures; i++ {
+				cb.record(true)
+			}
+			if testCase.cooledDown {
+				cb.openedAt = cb.openedAt.Add(-breakerCooldown - time.Second)
+			}
+
+			assert.Equal(t, testCase.allow, cb.allow())
+			if testCase.then != nil {
+				testCase.then(cb)
+			}
+			assert.Equal(t, testCase.nextAllow, cb.allow())
+		})
+	}
+}
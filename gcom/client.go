@@ -0,0 +1,1497 @@
+// Package gcom is a client for the Grafana Cloud API at grafana.com. It is
+// the client used by the vault plugin and can be imported on its own.
+package gcom
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/time/rate"
+)
+
+type Metadata struct {
+	Region string `json:"r"`
+}
+
+type GrafanaToken struct {
+	Organization string   `json:"o"`
+	TokenName    string   `json:"n"`
+	K            string   `json:"k"`
+	Metadata     Metadata `json:"m"`
+}
+
+type CreateTokenRequest struct {
+	AccessPolicyID string    `json:"accessPolicyId"`
+	Name           string    `json:"name"`
+	DisplayName    string    `json:"displayName"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+}
+
+// MarshalJSON omits expiresAt when it is zero so the token does not expire
+func (r CreateTokenRequest) MarshalJSON() ([]byte, error) {
+	type request CreateTokenRequest
+	if !r.ExpiresAt.IsZero() {
+		return json.Marshal(request(r))
+	}
+
+	return json.Marshal(struct {
+		request
+		ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	}{request: request(r)})
+}
+
+type TokenResponse struct {
+	ID             string    `json:"id"`
+	AccessPolicyID string    `json:"accessPolicyId"`
+	Name           string    `json:"name"`
+	DisplayName    string    `json:"displayName"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	FirstUsedAt    time.Time `json:"firstUsedAt"`
+	LastUsedAt     time.Time `json:"lastUsedAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	Token          string    `json:"token"`
+}
+
+func DecodeToken(token string) (GrafanaToken, error) {
+	token = strings.TrimPrefix(token, "glc_")
+	decodedToken, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return GrafanaToken{}, err
+	}
+
+	var grafanaToken GrafanaToken
+	if err := json.Unmarshal(decodedToken, &grafanaToken); err != nil {
+		return GrafanaToken{}, err
+	}
+
+	return grafanaToken, nil
+}
+
+// GrafanaAPIError is an error response of the grafana cloud api along with
+// the request it answered
+type GrafanaAPIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	StatusCode int    `json:"-"`
+	Method     string `json:"-"`
+	URL        string `json:"-"`
+	// RequestID is the X-Request-Id of the response, which grafana support
+	// can use to find the request
+	RequestID string `json:"-"`
+}
+
+func (e GrafanaAPIError) Error() string {
+	msg := fmt.Sprintf("error returned from grafana for %s '%s' status: %d, code: %s, err: %s", e.Method, e.URL, e.StatusCode, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(", request id: %s", e.RequestID)
+	}
+
+	return msg
+}
+
+// Errors returned by the client can be matched against these with errors.Is
+// to tell why grafana cloud rejected a request
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// statusError is returned for requests grafana cloud responded to with an
+// unexpected status code
+type statusError struct {
+	StatusCode int
+	err        error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+func (e *statusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	default:
+		return false
+	}
+}
+
+type withHeader struct {
+	http.Header
+	rt http.RoundTripper
+}
+
+type Link struct {
+	Rel string `json:"rel"`
+
+	Href string `json:"href"`
+}
+
+type GetTokenResponse struct {
+	Items    []TokenResponse `json:"items"`
+	Metadata ListMetadata    `json:"metadata"`
+}
+
+type Pagination struct {
+	PageSize   int    `json:"pageSize"`
+	PageCursor string `json:"pageCursor"`
+	NextPage   string `json:"nextPage"`
+}
+
+type ListMetadata struct {
+	Pagination Pagination `json:"pagination"`
+}
+
+type ListAccessPoliciesResponse struct {
+	Items    []AccessPolicy `json:"items"`
+	Metadata ListMetadata   `json:"metadata"`
+}
+
+// NextPageCursor returns the cursor to request the following page with, or an
+// empty string when this is the last page
+func (m ListMetadata) NextPageCursor() (string, error) {
+	if m.Pagination.NextPage == "" {
+		return "", nil
+	}
+	next, err := url.Parse(m.Pagination.NextPage)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse next page '%s': %w", m.Pagination.NextPage, err)
+	}
+
+	return next.Query().Get("pageCursor"), nil
+}
+
+// NextPageCursor returns the cursor to request the following page with, or an
+// empty string when this is the last page
+func (r ListAccessPoliciesResponse) NextPageCursor() (string, error) {
+	return r.Metadata.NextPageCursor()
+}
+
+type AccessPolicy struct {
+	ID          string   `json:"id,omitempty"`
+	OrgID       string   `json:"orgId,omitempty"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+	Scopes      []string `json:"scopes"`
+	Realms      []struct {
+		Type          string `json:"type,omitempty"`
+		Identifier    string `json:"identifier,omitempty"`
+		LabelPolicies []struct {
+			Selector string `json:"selector,omitempty"`
+		} `json:"labelPolicies,omitempty"`
+	} `json:"realms,omitempty"`
+	Conditions struct {
+		AllowedSubnets []string `json:"allowedSubnets,omitempty"`
+	} `json:"conditions,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+type Org struct {
+	ID   int    `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+type Stack struct {
+	ID         int    `json:"id"`
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	OrgID      int    `json:"orgId"`
+	RegionSlug string `json:"regionSlug"`
+	Status     string `json:"status"`
+	URL        string `json:"url"`
+	// The hosted metrics (prometheus), logs (loki), traces (tempo), profiles
+	// (pyroscope) and alertmanager instances of the stack
+	HmInstancePromID  int    `json:"hmInstancePromId"`
+	HmInstancePromURL string `json:"hmInstancePromUrl"`
+	HlInstanceID      int    `json:"hlInstanceId"`
+	HlInstanceURL     string `json:"hlInstanceUrl"`
+	HtInstanceID      int    `json:"htInstanceId"`
+	HtInstanceURL     string `json:"htInstanceUrl"`
+	HpInstanceID      int    `json:"hpInstanceId"`
+	HpInstanceURL     string `json:"hpInstanceUrl"`
+	AmInstanceID      int    `json:"amInstanceId"`
+	AmInstanceURL     string `json:"amInstanceUrl"`
+}
+
+func WithHeader(rt http.RoundTripper) withHeader {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return withHeader{Header: make(http.Header), rt: rt}
+}
+
+// RoundTrip sets the headers on the request, except the ones the request
+// already sets itself, like the authorization of apis not authenticated with
+// the token of the client
+func (h withHeader) RoundTrip(req *http.Request) (*http.Response, error) {
+	for k, v := range h.Header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = v
+		}
+	}
+
+	return h.rt.RoundTrip(req)
+}
+
+// Client calls the grafana cloud api with the token it was created with. It
+// is safe for concurrent use.
+type Client struct {
+	BaseURL   string
+	UserAgent string
+
+	httpClient *http.Client
+	region     string
+	orgSlug    string
+	// limiter delays requests to stay below a rate limit
+	limiter *rate.Limiter
+	// logger traces every request when set
+	logger hclog.Logger
+	// breaker fails requests fast while grafana cloud is down
+	breaker *circuitBreaker
+	// stacks caches the stacks looked up by CachedStack and StackID
+	stacks *stackCache
+	// onRequest is called after every request when set
+	onRequest func(method string, statusCode int, errorCode string, duration time.Duration)
+}
+
+func (c *Client) Region() string {
+	return c.region
+}
+
+func (c *Client) OrgSlug() string {
+	return c.orgSlug
+}
+
+const (
+	maxRequestRetries = 3
+	retryBaseDelay    = 500 * time.Millisecond
+	maxRetryDelay     = 10 * time.Second
+)
+
+// doWithRetries sends the request, retrying idempotent requests that failed
+// to send or were answered with 429 or a 5xx status. Retries back off
+// exponentially with jitter unless grafana cloud asks to wait with
+// Retry-After.
+func (c *Client) doWithRetries(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if !isIdempotent(req.Method) || !canRewindBody(req) || attempt >= maxRequestRetries || (err == nil && !isRetryableStatus(resp.StatusCode)) {
+			return resp, err
+		}
+
+		delay := retryDelay(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		// The body was consumed by the previous attempt
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// canRewindBody returns whether the body of req can be sent again by a retry
+func canRewindBody(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// retryDelay returns how long to wait before retrying after the given attempt,
+// preferring the Retry-After header of resp when it has one
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+				return min(time.Duration(seconds)*time.Second, maxRetryDelay)
+			}
+			if at, err := http.ParseTime(retryAfter); err == nil {
+				return min(max(time.Until(at), 0), maxRetryDelay)
+			}
+		}
+	}
+
+	backoff := min(retryBaseDelay<<attempt, maxRetryDelay)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (c *Client) performGrafanaAPIOperation(req *http.Request) (*http.Response, error) {
+	newParams := req.URL.Query()
+	newParams.Add("region", c.region)
+	req.URL.RawQuery = newParams.Encode()
+
+	if c.breaker != nil {
+		if err := c.breaker.allow(); err != nil {
+			return nil, fmt.Errorf("not sending request to '%s': %w", req.URL.String(), err)
+		}
+	}
+
+	start := time.Now()
+	resp, err := c.doWithRetries(req)
+	if err == nil {
+		resp.Body = newBoundedBody(resp.Body, maxResponseBodySize)
+	}
+	c.logRequest(req, resp, err, start)
+	if c.breaker != nil {
+		switch {
+		case err != nil && req.Context().Err() != nil:
+			c.breaker.release()
+		case err != nil:
+			c.breaker.record(true)
+		default:
+			c.breaker.record(isRetryableStatus(resp.StatusCode))
+		}
+	}
+	if err != nil {
+		c.recordRequest(req.Method, 0, "", start)
+		return nil, fmt.Errorf("error attempting request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		defer resp.Body.Close()
+		grafanaError := GrafanaAPIError{
+			StatusCode: resp.StatusCode,
+			Method:     req.Method,
+			URL:        req.URL.String(),
+			RequestID:  resp.Header.Get("X-Request-Id"),
+		}
+		// Proxies in front of the api may answer with a body that is not
+		// json, which should not hide the status code
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		if err != nil {
+			grafanaError.Message = fmt.Sprintf("%s, error reading response body: %s", http.StatusText(resp.StatusCode), err)
+		} else if err := json.Unmarshal(body, &grafanaError); err != nil || (grafanaError.Code == "" && grafanaError.Message == "") {
+			grafanaError.Message = fmt.Sprintf("%s, unexpected %s response", http.StatusText(resp.StatusCode), responseContentType(resp))
+			if snippet := bodySnippet(body); snippet != "" {
+				grafanaError.Message += ": " + snippet
+			}
+		}
+		c.recordRequest(req.Method, resp.StatusCode, grafanaError.Code, start)
+
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        grafanaError,
+		}
+	}
+	c.recordRequest(req.Method, resp.StatusCode, "", start)
+
+	return resp, nil
+}
+
+// recordRequest reports the outcome of a request to onRequest
+func (c *Client) recordRequest(method string, statusCode int, errorCode string, start time.Time) {
+	if c.onRequest != nil {
+		c.onRequest(method, statusCode, errorCode, time.Since(start))
+	}
+}
+
+// responseContentType returns the media type of the response for errors
+func responseContentType(resp *http.Response) string {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return "non-JSON"
+	}
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+
+	return strings.TrimSpace(contentType)
+}
+
+// ListTokens returns a single page of the tokens in the organization,
+// restricted to the tokens of accessPolicyID when it is set. An empty
+// pageCursor requests the first page.
+func (c *Client) ListTokens(ctx context.Context, accessPolicyID string, pageSize int, pageCursor string) (*GetTokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/tokens", nil)
+	if err != nil {
+		return nil, err
+	}
+	queryParams := req.URL.Query()
+	if accessPolicyID != "" {
+		queryParams.Add("accessPolicyId", accessPolicyID)
+	}
+	if pageSize > 0 {
+		queryParams.Add("pageSize", strconv.Itoa(pageSize))
+	}
+	if pageCursor != "" {
+		queryParams.Add("pageCursor", pageCursor)
+	}
+	req.URL.RawQuery = queryParams.Encode()
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jsonResponse GetTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding list tokens response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// GetTokenByName returns the token with the given name. Every page of tokens
+// is searched, and expired tokens are skipped when an unexpired token shares
+// their name. Returns an error matching ErrNotFound when there is no such
+// token.
+func (c *Client) GetTokenByName(ctx context.Context, name string) (*TokenResponse, error) {
+	var matches []TokenResponse
+	pageCursor := ""
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/tokens", nil)
+		if err != nil {
+			return nil, err
+		}
+		queryParams := req.URL.Query()
+		queryParams.Add("name", name)
+		if pageCursor != "" {
+			queryParams.Add("pageCursor", pageCursor)
+		}
+		req.URL.RawQuery = queryParams.Encode()
+
+		resp, err := c.performGrafanaAPIOperation(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var jsonResponse GetTokenResponse
+		err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding get token response: %w", err)
+		}
+
+		for _, token := range jsonResponse.Items {
+			if token.Name == name {
+				matches = append(matches, token)
+			}
+		}
+
+		pageCursor, err = jsonResponse.Metadata.NextPageCursor()
+		if err != nil {
+			return nil, err
+		}
+		if pageCursor == "" {
+			break
+		}
+	}
+
+	if len(matches) > 1 {
+		unexpired := matches[:0:0]
+		for _, token := range matches {
+			if token.ExpiresAt.IsZero() || token.ExpiresAt.After(time.Now()) {
+				unexpired = append(unexpired, token)
+			}
+		}
+		if len(unexpired) > 0 {
+			matches = unexpired
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no token named '%s': %w", name, ErrNotFound)
+	case 1:
+		return &matches[0], nil
+	default:
+		ids := make([]string, 0, len(matches))
+		for _, token := range matches {
+			ids = append(ids, token.ID)
+		}
+		return nil, fmt.Errorf("found %d tokens named '%s': %s", len(matches), name, strings.Join(ids, ", "))
+	}
+}
+
+func (c *Client) GetToken(ctx context.Context, id string) (*TokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/tokens/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var jsonResponse TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding get token response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+func (c *Client) CreateToken(ctx context.Context, reqBody CreateTokenRequest) (*TokenResponse, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/tokens", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating 'create token' request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("access policy '%s' not found", reqBody.AccessPolicyID),
+		}
+	}
+
+	var jsonResponse TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create token response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+func (c *Client) UpdateToken(ctx context.Context, id string, expirationDate time.Time) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"expiresAt": expirationDate,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/tokens/"+id, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+func (c *Client) DeleteToken(ctx context.Context, id string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.BaseURL+"/tokens/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+func (c *Client) CreateAccessPolicy(ctx context.Context, policy map[string]interface{}) (*AccessPolicy, error) {
+	postBody, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/accesspolicies", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jsonResponse AccessPolicy
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create access policy response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// UpdateAccessPolicy replaces the access policy with the given ID. Returns nil
+// when the access policy does not exist.
+func (c *Client) UpdateAccessPolicy(ctx context.Context, id string, policy map[string]interface{}) (*AccessPolicy, error) {
+	postBody, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/accesspolicies/"+id, bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var jsonResponse AccessPolicy
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding update access policy response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// GetAccessPolicy returns the access policy with the given ID, or nil when it
+// does not exist
+func (c *Client) GetAccessPolicy(ctx context.Context, id string) (*AccessPolicy, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/accesspolicies/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var jsonResponse AccessPolicy
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding get access policy response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// ListAccessPolicies returns a single page of the access policies in the
+// organization. An empty pageCursor requests the first page.
+func (c *Client) ListAccessPolicies(ctx context.Context, pageSize int, pageCursor string) (*ListAccessPoliciesResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/accesspolicies", nil)
+	if err != nil {
+		return nil, err
+	}
+	queryParams := req.URL.Query()
+	if pageSize > 0 {
+		queryParams.Add("pageSize", strconv.Itoa(pageSize))
+	}
+	if pageCursor != "" {
+		queryParams.Add("pageCursor", pageCursor)
+	}
+	req.URL.RawQuery = queryParams.Encode()
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jsonResponse ListAccessPoliciesResponse
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding list access policies response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// GetAccessPolicyByName returns the access policy with the given name, or
+// nil when there is none. Every page of access policies is searched.
+func (c *Client) GetAccessPolicyByName(ctx context.Context, name string) (*AccessPolicy, error) {
+	pageCursor := ""
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/accesspolicies", nil)
+		if err != nil {
+			return nil, err
+		}
+		queryParams := req.URL.Query()
+		queryParams.Add("name", name)
+		if pageCursor != "" {
+			queryParams.Add("pageCursor", pageCursor)
+		}
+		req.URL.RawQuery = queryParams.Encode()
+
+		resp, err := c.performGrafanaAPIOperation(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var jsonResponse ListAccessPoliciesResponse
+		err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding list access policies response: %w", err)
+		}
+
+		for _, policy := range jsonResponse.Items {
+			if policy.Name == name {
+				return &policy, nil
+			}
+		}
+
+		pageCursor, err = jsonResponse.Metadata.NextPageCursor()
+		if err != nil {
+			return nil, err
+		}
+		if pageCursor == "" {
+			return nil, nil
+		}
+	}
+}
+
+// DeleteAccessPolicy deletes the access policy and reports whether it
+// existed
+func (c *Client) DeleteAccessPolicy(ctx context.Context, id string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.BaseURL+"/accesspolicies/"+id, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode != http.StatusNotFound, nil
+}
+
+// legacyBaseURL is the base of the unversioned endpoints, like orgs and
+// instances, that are not served under /v1
+func (c *Client) legacyBaseURL() string {
+	return strings.TrimSuffix(c.BaseURL, "/v1")
+}
+
+// GetOrg returns the organization with the given slug, or nil when it does
+// not exist
+func (c *Client) GetOrg(ctx context.Context, slug string) (*Org, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.legacyBaseURL()+"/orgs/"+url.PathEscape(slug), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var jsonResponse Org
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding get org response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// GetStack returns the stack with the given slug, or nil when it does not
+// exist
+func (c *Client) GetStack(ctx context.Context, slug string) (*Stack, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.legacyBaseURL()+"/instances/"+url.PathEscape(slug), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var jsonResponse Stack
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding get stack response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// ListStacks returns the stacks of the organization with the given slug
+func (c *Client) ListStacks(ctx context.Context, orgSlug string) ([]Stack, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.legacyBaseURL()+"/orgs/"+url.PathEscape(orgSlug)+"/instances", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("organization '%s' not found", orgSlug),
+		}
+	}
+
+	var jsonResponse struct {
+		Items []Stack `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding list stacks response: %w", err)
+	}
+
+	return jsonResponse.Items, nil
+}
+
+// CreateStackRequest is the body of a request creating a stack
+type CreateStackRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	// Region is the slug of the region the stack is created in, like
+	// prod-us-central-0. Defaults to the region of the organization.
+	Region      string `json:"region,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// CreateStack creates a stack in the organization of the token
+func (c *Client) CreateStack(ctx context.Context, reqBody CreateStackRequest) (*Stack, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.legacyBaseURL()+"/instances", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jsonResponse Stack
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create stack response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// DeleteStack deletes the stack with the given slug along with all of its
+// data. Returns false when it did not exist.
+func (c *Client) DeleteStack(ctx context.Context, slug string) (bool, error) {
+	c.stacks.forget(slug)
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.legacyBaseURL()+"/instances/"+url.PathEscape(slug), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode != http.StatusNotFound, nil
+}
+
+// CreateOrgAPIKeyRequest is the body of a request creating a legacy Grafana
+// Cloud API key of an organization
+type CreateOrgAPIKeyRequest struct {
+	Name string `json:"name"`
+	// Role is the Grafana Cloud role of the key, like Viewer,
+	// MetricsPublisher, PluginPublisher, Editor or Admin
+	Role string `json:"role"`
+	// SecondsToLive is the lifetime of the key. The key does not expire when
+	// it is 0.
+	SecondsToLive int64 `json:"secondsToLive,omitempty"`
+}
+
+// OrgAPIKey is a legacy Grafana Cloud API key of an organization. Token is
+// only set when the key is created.
+type OrgAPIKey struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Token string `json:"token"`
+}
+
+// CreateOrgAPIKey creates a legacy Grafana Cloud API key in the organization
+// with the given slug, for the endpoints that do not accept access policy
+// tokens
+func (c *Client) CreateOrgAPIKey(ctx context.Context, orgSlug string, reqBody CreateOrgAPIKeyRequest) (*OrgAPIKey, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.legacyBaseURL()+"/orgs/"+url.PathEscape(orgSlug)+"/api-keys", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("organization '%s' not found", orgSlug),
+		}
+	}
+
+	var jsonResponse OrgAPIKey
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create api key response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// DeleteOrgAPIKey deletes the legacy API key with the given name from the
+// organization. Deleting a key that does not exist succeeds.
+func (c *Client) DeleteOrgAPIKey(ctx context.Context, orgSlug string, name string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.legacyBaseURL()+"/orgs/"+url.PathEscape(orgSlug)+"/api-keys/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// CreateStackAPIKeyRequest is the body of a request creating a Grafana API
+// key in a stack
+type CreateStackAPIKeyRequest struct {
+	Name string `json:"name"`
+	// Role is the Grafana organization role of the key: Viewer, Editor or
+	// Admin
+	Role string `json:"role"`
+	// SecondsToLive is the lifetime of the key. The key does not expire when
+	// it is 0.
+	SecondsToLive int64 `json:"secondsToLive,omitempty"`
+}
+
+// StackAPIKey is a Grafana API key of a stack. Key is only set when the key
+// is created.
+type StackAPIKey struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// CreateStackAPIKey creates a Grafana API key in the stack with the given
+// slug, for use with the Grafana HTTP API of the stack
+func (c *Client) CreateStackAPIKey(ctx context.Context, stackSlug string, reqBody CreateStackAPIKeyRequest) (*StackAPIKey, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/auth/keys", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("stack '%s' not found", stackSlug),
+		}
+	}
+
+	var jsonResponse StackAPIKey
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create stack api key response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// GetStackAPIKeyByName returns the API key of the stack with the given name,
+// or nil when there is none
+func (c *Client) GetStackAPIKeyByName(ctx context.Context, stackSlug string, name string) (*StackAPIKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/auth/keys", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jsonResponse []StackAPIKey
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding list stack api keys response: %w", err)
+	}
+
+	for _, key := range jsonResponse {
+		if key.Name == name {
+			return &key, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DeleteStackAPIKey deletes the Grafana API key with the given ID from the
+// stack. Deleting a key that does not exist succeeds.
+func (c *Client) DeleteStackAPIKey(ctx context.Context, stackSlug string, id int) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/auth/keys/"+strconv.Itoa(id), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// CreateStackServiceAccountRequest is the body of a request creating a Grafana
+// service account in a stack
+type CreateStackServiceAccountRequest struct {
+	Name string `json:"name"`
+	// Role is the Grafana organization role of the service account: Viewer,
+	// Editor or Admin
+	Role       string `json:"role"`
+	IsDisabled bool   `json:"isDisabled"`
+}
+
+// StackServiceAccount is a Grafana service account of a stack
+type StackServiceAccount struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Login string `json:"login"`
+	Role  string `json:"role"`
+}
+
+// CreateStackServiceAccountTokenRequest is the body of a request adding a
+// token to a service account of a stack
+type CreateStackServiceAccountTokenRequest struct {
+	Name string `json:"name"`
+	// SecondsToLive is the lifetime of the token. The token does not expire
+	// when it is 0.
+	SecondsToLive int64 `json:"secondsToLive,omitempty"`
+}
+
+// StackServiceAccountToken is a token of a service account of a stack. Key is
+// only set when the token is created.
+type StackServiceAccountToken struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// CreateStackServiceAccount creates a Grafana service account in the stack
+// with the given slug
+func (c *Client) CreateStackServiceAccount(ctx context.Context, stackSlug string, reqBody CreateStackServiceAccountRequest) (*StackServiceAccount, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/serviceaccounts", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("stack '%s' not found", stackSlug),
+		}
+	}
+
+	var jsonResponse StackServiceAccount
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create service account response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// CreateStackServiceAccountToken adds a token to the service account with
+// the given ID in the stack
+func (c *Client) CreateStackServiceAccountToken(ctx context.Context, stackSlug string, serviceAccountID int, reqBody CreateStackServiceAccountTokenRequest) (*StackServiceAccountToken, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/serviceaccounts/"+strconv.Itoa(serviceAccountID)+"/tokens", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("service account '%d' not found in stack '%s'", serviceAccountID, stackSlug),
+		}
+	}
+
+	var jsonResponse StackServiceAccountToken
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create service account token response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// AssignStackRBACRole grants the Grafana RBAC role with the given UID to the
+// user or service account with the given ID in the stack
+func (c *Client) AssignStackRBACRole(ctx context.Context, stackSlug string, userID int, roleUID string) error {
+	postBody, err := json.Marshal(map[string]string{"roleUid": roleUID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/access-control/users/"+strconv.Itoa(userID)+"/roles", bytes.NewBuffer(postBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("role '%s' or user '%d' not found in stack '%s'", roleUID, userID, stackSlug),
+		}
+	}
+
+	return nil
+}
+
+// searchStackServiceAccountsResponse is the page of service accounts
+// returned by a search of the service accounts of a stack
+type searchStackServiceAccountsResponse struct {
+	ServiceAccounts []StackServiceAccount `json:"serviceAccounts"`
+}
+
+// GetStackServiceAccountByName returns the service account of the stack with
+// the given name, or nil when there is none
+func (c *Client) GetStackServiceAccountByName(ctx context.Context, stackSlug string, name string) (*StackServiceAccount, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/serviceaccounts/search", nil)
+	if err != nil {
+		return nil, err
+	}
+	queryParams := req.URL.Query()
+	queryParams.Add("query", name)
+	req.URL.RawQuery = queryParams.Encode()
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jsonResponse searchStackServiceAccountsResponse
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding search service accounts response: %w", err)
+	}
+
+	// The query also matches names containing it
+	for _, account := range jsonResponse.ServiceAccounts {
+		if account.Name == name {
+			return &account, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DeleteStackServiceAccount deletes the service account with the given ID,
+// and with it its tokens, from the stack. Deleting a service account that
+// does not exist succeeds.
+func (c *Client) DeleteStackServiceAccount(ctx context.Context, stackSlug string, id int) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/serviceaccounts/"+strconv.Itoa(id), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+// DeleteStackServiceAccountToken deletes a token of the service account from
+// the stack. Deleting a token that does not exist succeeds.
+func (c *Client) DeleteStackServiceAccountToken(ctx context.Context, stackSlug string, serviceAccountID int, id int) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.legacyBaseURL()+"/instances/"+url.PathEscape(stackSlug)+"/api/serviceaccounts/"+strconv.Itoa(serviceAccountID)+"/tokens/"+strconv.Itoa(id), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
+const (
+	DefaultAPIURL        = "https://grafana.com/api/v1"
+	defaultClientTimeout = 10 * time.Second
+	defaultUserAgent     = "vault-plugin-secrets-grafana-cloud"
+)
+
+// regionAPIURL returns the api url serving region. Grafana Cloud serves every
+// region from DefaultAPIURL, so only regions given in hosts are sent
+// elsewhere. Hosts may be given as a bare host name, which is reached over
+// https under /api/v1, or as a full url.
+func regionAPIURL(region string, hosts map[string]string) string {
+	host := hosts[region]
+	if host == "" {
+		return DefaultAPIURL
+	}
+
+	return HostAPIURL(host)
+}
+
+// HostAPIURL turns a region host into the url of its api
+func HostAPIURL(host string) string {
+	if strings.Contains(host, "://") {
+		return strings.TrimSuffix(host, "/")
+	}
+
+	return "https://" + strings.TrimSuffix(host, "/") + "/api/v1"
+}
+
+// sharedTransport is used by every client without a transport of its own so
+// connections to grafana cloud are pooled across requests
+var sharedTransport = NewTransport()
+
+// NewTransport returns a transport that keeps enough idle connections to
+// grafana cloud around to serve bursts of requests without reconnecting
+func NewTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 20
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.ForceAttemptHTTP2 = true
+
+	return transport
+}
+
+// Options configures how a client reaches the grafana cloud api. Zero
+// values fall back to the defaults.
+type Options struct {
+	// BaseURL defaults to the api url of the region, see regionAPIURL
+	BaseURL string
+	// RegionHosts maps regions to the api host serving them instead of
+	// DefaultAPIURL
+	RegionHosts map[string]string
+	// Timeout of each request, defaults to defaultClientTimeout
+	Timeout time.Duration
+	// Transport defaults to sharedTransport
+	Transport http.RoundTripper
+	// Region and OrgSlug default to the ones decoded from the token
+	Region  string
+	OrgSlug string
+	// UserAgent defaults to defaultUserAgent
+	UserAgent string
+	// Limiter delays requests when set, to stay below a rate limit
+	Limiter *rate.Limiter
+	// StackCacheTTL is how long CachedStack and StackID reuse a stack, defaults
+	// to defaultStackCacheTTL
+	StackCacheTTL time.Duration
+	// Logger traces every request and its response, with secrets redacted,
+	// when set
+	Logger hclog.Logger
+	// OnRequest is called after every request with its outcome, for metrics.
+	// statusCode is 0 when no response was received.
+	OnRequest func(method string, statusCode int, errorCode string, duration time.Duration)
+}
+
+// NewClient creates a client authenticated with token. The region and
+// organization default to the ones embedded in the token, which only needs to
+// decode when either of them is not set in opts.
+func NewClient(token string, opts Options) (*Client, error) {
+	var decodedToken GrafanaToken
+	if opts.Region == "" || opts.OrgSlug == "" {
+		var err error
+		decodedToken, err = DecodeToken(token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tokens: %w", err)
+		}
+	}
+
+	c := &Client{
+		UserAgent: defaultUserAgent,
+		breaker:   &circuitBreaker{},
+		stacks:    newStackCache(opts.StackCacheTTL),
+		region:    decodedToken.Metadata.Region,
+		orgSlug:   decodedToken.Organization,
+		limiter:   opts.Limiter,
+		logger:    opts.Logger,
+		onRequest: opts.OnRequest,
+	}
+	if opts.UserAgent != "" {
+		c.UserAgent = opts.UserAgent
+	}
+	if opts.Region != "" {
+		c.region = opts.Region
+	}
+	c.BaseURL = regionAPIURL(c.region, opts.RegionHosts)
+	if opts.BaseURL != "" {
+		c.BaseURL = opts.BaseURL
+	}
+	if opts.OrgSlug != "" {
+		c.orgSlug = opts.OrgSlug
+	}
+
+	timeout := defaultClientTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	transport := opts.Transport
+	if transport == nil {
+		transport = sharedTransport
+	}
+	headers := WithHeader(transport)
+	headers.Set("Authorization", "Bearer "+token)
+	headers.Set("User-Agent", c.UserAgent)
+	c.httpClient = &http.Client{
+		Timeout:   timeout,
+		Transport: headers,
+	}
+
+	return c, nil
+}
@@ -0,0 +1,88 @@
+package gcom
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_access_policies(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, strings.TrimSpace(r.Method+" "+r.URL.RequestURI()+" "+string(body)))
+		switch r.URL.Path {
+		case "/accesspolicies":
+			w.Write([]byte(`{"items": [{"id": "1", "name": "readers"}], "metadata": {"pagination": {"pageSize": 1, "nextPage": "/accesspolicies?pageCursor=next"}}}`))
+		case "/accesspolicies/1":
+			w.Write([]byte(`{"id": "1", "name": "readers", "scopes": ["metrics:read"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := &Client{BaseURL: server.URL, httpClient: server.Client()}
+
+	testCases := []struct {
+		name            string
+		call            func() (interface{}, error)
+		expected        interface{}
+		expectedRequest string
+	}{
+		{
+			"getsAccessPolicy",
+			func() (interface{}, error) { return c.GetAccessPolicy(context.Background(), "1") },
+			&AccessPolicy{ID: "1", Name: "readers", Scopes: []string{"metrics:read"}},
+			"GET /accesspolicies/1?region=",
+		},
+		{
+			"getsNilForMissingAccessPolicy",
+			func() (interface{}, error) { return c.GetAccessPolicy(context.Background(), "2") },
+			(*AccessPolicy)(nil),
+			"GET /accesspolicies/2?region=",
+		},
+		{
+			"updatesAccessPolicy",
+			func() (interface{}, error) {
+				return c.UpdateAccessPolicy(context.Background(), "1", map[string]interface{}{"scopes": []string{"metrics:read"}})
+			},
+			&AccessPolicy{ID: "1", Name: "readers", Scopes: []string{"metrics:read"}},
+			`POST /accesspolicies/1?region= {"scopes":["metrics:read"]}`,
+		},
+		{
+			"updatesNothingForMissingAccessPolicy",
+			func() (interface{}, error) {
+				return c.UpdateAccessPolicy(context.Background(), "2", map[string]interface{}{"scopes": []string{"metrics:read"}})
+			},
+			(*AccessPolicy)(nil),
+			`POST /accesspolicies/2?region= {"scopes":["metrics:read"]}`,
+		},
+		{
+			"listsPageOfAccessPolicies",
+			func() (interface{}, error) { return c.ListAccessPolicies(context.Background(), 1, "first") },
+			&ListAccessPoliciesResponse{
+				Items:    []AccessPolicy{{ID: "1", Name: "readers"}},
+				Metadata: ListMetadata{Pagination: Pagination{PageSize: 1, NextPage: "/accesspolicies?pageCursor=next"}},
+			},
+			"GET /accesspolicies?pageCursor=first&pageSize=1&region=",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			requests = nil
+
+			resp, err := testCase.call()
+			if assert.NoError(t, err) {
+				assert.Equal(t, testCase.expected, resp)
+			}
+			assert.Equal(t, []string{testCase.expectedRequest}, requests)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package gcom
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxResponseBodySize bounds how much of a response body is read, so a
+	// misbehaving proxy can not exhaust the memory of the caller
+	maxResponseBodySize = 10 << 20
+	// maxErrorBodySize bounds how much of an error response body is read
+	maxErrorBodySize = 64 << 10
+	// maxErrorSnippetLength is the number of characters of a non-JSON error
+	// response included in the error
+	maxErrorSnippetLength = 200
+)
+
+// boundedBody fails reads once more than remaining bytes were read
+type boundedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func newBoundedBody(body io.ReadCloser, limit int64) *boundedBody {
+	return &boundedBody{ReadCloser: body, limit: limit, remaining: limit}
+}
+
+func (b *boundedBody) Read(p []byte) (int, error) {
+	// One more byte than allowed is read to tell a body of exactly the limit
+	// apart from a longer one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, fmt.Errorf("response body is larger than %d bytes", b.limit)
+	}
+	b.remaining -= int64(n)
+
+	return n, err
+}
+
+// failingReader returns err once the buffered part of a body is consumed
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+var (
+	htmlTagRegex    = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]*>`)
+	whitespaceRegex = regexp.MustCompile(`\s+`)
+)
+
+// bodySnippet returns the start of a non-JSON body as readable text, with the
+// markup of HTML pages, like the maintenance and gateway error pages of
+// grafana's edge, stripped
+func bodySnippet(body []byte) string {
+	text := htmlTagRegex.ReplaceAllString(string(body), " ")
+	text = strings.TrimSpace(whitespaceRegex.ReplaceAllString(text, " "))
+	if len(text) > maxErrorSnippetLength {
+		text = text[:maxErrorSnippetLength] + "..."
+	}
+
+	return text
+}
@@ -0,0 +1,38 @@
+package gcom
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodySnippet(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"empty", ``, ``},
+		{"text", "upstream connect error\n", `upstream connect error`},
+		{"html", "<html><head><style>p {}</style><title>502</title></head>\n<body><p>Bad   Gateway</p></body></html>", `502 Bad Gateway`},
+		{"truncated", strings.Repeat("a", maxErrorSnippetLength+1), strings.Repeat("a", maxErrorSnippetLength) + "..."},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, bodySnippet([]byte(testCase.body)))
+		})
+	}
+}
+
+func TestBoundedBody(t *testing.T) {
+	body, err := io.ReadAll(newBoundedBody(io.NopCloser(strings.NewReader("12345")), 5))
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(body))
+
+	body, err = io.ReadAll(newBoundedBody(io.NopCloser(strings.NewReader("123456")), 5))
+	assert.Error(t, err)
+	assert.Equal(t, "12345", string(body))
+}
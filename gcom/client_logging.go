@@ -0,0 +1,109 @@
+package gcom
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// redactedValue replaces secrets in logged requests and responses
+const redactedValue = "[redacted]"
+
+// redactedKeys are json keys whose values are never logged
+var redactedKeys = map[string]bool{
+	"token":       true,
+	"key":         true,
+	"accessToken": true,
+}
+
+// logRequest logs a request to the grafana cloud api and its response at
+// trace level, with the authorization header and tokens redacted. It is a
+// no-op unless the client has a logger with trace enabled.
+func (c *Client) logRequest(req *http.Request, resp *http.Response, err error, start time.Time) {
+	if c.logger == nil || !c.logger.IsTrace() {
+		return
+	}
+
+	args := []interface{}{
+		"method", req.Method,
+		"url", req.URL.String(),
+		"duration", time.Since(start),
+		"request_headers", redactHeaders(req.Header),
+	}
+	if req.GetBody != nil {
+		if body, bodyErr := req.GetBody(); bodyErr == nil {
+			reqBody, _ := io.ReadAll(body)
+			body.Close()
+			args = append(args, "request_size", len(reqBody), "request_body", redactBody(reqBody))
+		}
+	}
+	if err != nil {
+		c.logger.Trace("grafana cloud api request failed", append(args, "error", err)...)
+		return
+	}
+
+	// The body is buffered so it can still be read by the caller
+	respBody, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if readErr != nil {
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(respBody), failingReader{err: readErr}))
+		args = append(args, "read_error", readErr)
+	}
+
+	c.logger.Trace("grafana cloud api request", append(args,
+		"status", resp.StatusCode,
+		"response_size", len(respBody),
+		"response_body", redactBody(respBody),
+	)...)
+}
+
+func redactHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+	if redacted.Get("Authorization") != "" {
+		redacted.Set("Authorization", redactedValue)
+	}
+
+	return redacted
+}
+
+// redactBody returns the json body with the values of redactedKeys replaced,
+// or only its size when it is not json
+func redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return "[non-json body]"
+	}
+
+	redacted, err := json.Marshal(redactValue(decoded))
+	if err != nil {
+		return "[unloggable body]"
+	}
+
+	return string(redacted)
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if redactedKeys[key] {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redactValue(nested)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactValue(nested)
+		}
+	}
+
+	return value
+}
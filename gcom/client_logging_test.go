@@ -0,0 +1,26 @@
+package gcom
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactBody(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"empty", ``, ``},
+		{"not json", `<html>bad gateway</html>`, `[non-json body]`},
+		{"token", `{"id":"1","token":"glc_secret"}`, `{"id":"1","token":"[redacted]"}`},
+		{"nested", `{"items":[{"name":"a","token":"glc_secret"}]}`, `{"items":[{"name":"a","token":"[redacted]"}]}`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, redactBody([]byte(testCase.body)))
+		})
+	}
+}
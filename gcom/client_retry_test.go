@@ -0,0 +1,51 @@
+package gcom
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoWithRetriesRewindsBody(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	c := &Client{httpClient: server.Client()}
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL, bytes.NewBufferString(`{"token":"sm"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.doWithRetries(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, []string{`{"token":"sm"}`, `{"token":"sm"}`}, bodies)
+
+	// A body that can not be sent again is not retried
+	bodies = nil
+	req, err = http.NewRequest(http.MethodDelete, server.URL, io.NopCloser(strings.NewReader(`{"token":"sm"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = c.doWithRetries(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	assert.Len(t, bodies, 1)
+}
@@ -0,0 +1,91 @@
+package gcom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultK6APIURL is the url of the Grafana Cloud k6 api
+const DefaultK6APIURL = "https://api.k6.io"
+
+// CreateK6TokenRequest is the body of a request creating a k6 api token
+type CreateK6TokenRequest struct {
+	Name string `json:"name"`
+	// ProjectID restricts the token to a project. The token has access to
+	// the whole organization when it is 0.
+	ProjectID int `json:"project_id,omitempty"`
+}
+
+// K6Token is an api token of a k6 organization. Token is only set when the
+// token is created.
+type K6Token struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	ProjectID int    `json:"project_id"`
+	Token     string `json:"token"`
+}
+
+// k6TokensURL is the url of the api tokens of the k6 organization
+func k6TokensURL(k6URL string, orgID int) string {
+	return strings.TrimSuffix(k6URL, "/") + "/v3/organizations/" + strconv.Itoa(orgID) + "/api-tokens"
+}
+
+// CreateK6Token creates an api token in the k6 organization through the api
+// at k6URL, authenticated with the k6 token adminToken rather than the token
+// of the client
+func (c *Client) CreateK6Token(ctx context.Context, k6URL string, adminToken string, orgID int, reqBody CreateK6TokenRequest) (*K6Token, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", k6TokensURL(k6URL, orgID), bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Token "+adminToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("k6 organization '%d' not found", orgID),
+		}
+	}
+
+	var jsonResponse K6Token
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding create k6 token response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// DeleteK6Token deletes the api token with the given ID from the k6
+// organization. Deleting a token that does not exist succeeds.
+func (c *Client) DeleteK6Token(ctx context.Context, k6URL string, adminToken string, orgID int, id int) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", k6TokensURL(k6URL, orgID)+"/"+strconv.Itoa(id), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+adminToken)
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
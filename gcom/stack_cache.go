@@ -0,0 +1,96 @@
+package gcom
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultStackCacheTTL is how long stacks looked up by slug are reused
+const defaultStackCacheTTL = 5 * time.Minute
+
+// stackCache keeps the stacks looked up by slug, so resolving the same slug
+// over and over, e.g. for every issued token, does not hit the api each time.
+// Stacks that do not exist are not cached so they are found once created.
+type stackCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]stackCacheEntry
+}
+
+type stackCacheEntry struct {
+	stack     Stack
+	expiresAt time.Time
+}
+
+func newStackCache(ttl time.Duration) *stackCache {
+	if ttl <= 0 {
+		ttl = defaultStackCacheTTL
+	}
+
+	return &stackCache{
+		ttl:     ttl,
+		entries: map[string]stackCacheEntry{},
+	}
+}
+
+func (sc *stackCache) get(slug string) (*Stack, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	entry, ok := sc.entries[slug]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(sc.entries, slug)
+		return nil, false
+	}
+	stack := entry.stack
+
+	return &stack, true
+}
+
+func (sc *stackCache) put(stack Stack) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.entries[stack.Slug] = stackCacheEntry{
+		stack:     stack,
+		expiresAt: time.Now().Add(sc.ttl),
+	}
+}
+
+func (sc *stackCache) forget(slug string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delete(sc.entries, slug)
+}
+
+// CachedStack returns the stack with the given slug like GetStack, reusing
+// the stack looked up within the cache TTL
+func (c *Client) CachedStack(ctx context.Context, slug string) (*Stack, error) {
+	if stack, ok := c.stacks.get(slug); ok {
+		return stack, nil
+	}
+
+	stack, err := c.GetStack(ctx, slug)
+	if err != nil || stack == nil {
+		return stack, err
+	}
+	c.stacks.put(*stack)
+
+	return stack, nil
+}
+
+// StackID resolves the slug of a stack to its numeric ID
+func (c *Client) StackID(ctx context.Context, slug string) (int, error) {
+	stack, err := c.CachedStack(ctx, slug)
+	if err != nil {
+		return 0, err
+	}
+	if stack == nil {
+		return 0, fmt.Errorf("stack '%s' does not exist", slug)
+	}
+
+	return stack.ID, nil
+}
@@ -0,0 +1,28 @@
+package gcom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStackCache(t *testing.T) {
+	sc := newStackCache(time.Minute)
+	sc.put(Stack{ID: 1, Slug: "mystack"})
+
+	stack, ok := sc.get("mystack")
+	assert.True(t, ok)
+	assert.Equal(t, 1, stack.ID)
+
+	_, ok = sc.get("other")
+	assert.False(t, ok)
+
+	sc.forget("mystack")
+	_, ok = sc.get("mystack")
+	assert.False(t, ok)
+
+	sc.entries["expired"] = stackCacheEntry{stack: Stack{ID: 2}, expiresAt: time.Now().Add(-time.Second)}
+	_, ok = sc.get("expired")
+	assert.False(t, ok)
+}
@@ -0,0 +1,137 @@
+package gcom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultSyntheticMonitoringURLs maps the regions of stacks to the url of the
+// Synthetic Monitoring api serving them
+var defaultSyntheticMonitoringURLs = map[string]string{
+	"prod-us-central-0":   "https://synthetic-monitoring-api.grafana.net",
+	"prod-us-east-0":      "https://synthetic-monitoring-api-us-east-0.grafana.net",
+	"prod-eu-west-0":      "https://synthetic-monitoring-api-eu-west.grafana.net",
+	"prod-ap-southeast-0": "https://synthetic-monitoring-api-ap-southeast-0.grafana.net",
+}
+
+// SyntheticMonitoringURL returns the url of the Synthetic Monitoring api
+// serving stacks in the region, or an empty string when it is unknown
+func SyntheticMonitoringURL(regionSlug string) string {
+	return defaultSyntheticMonitoringURLs[regionSlug]
+}
+
+// InstallSyntheticMonitoringRequest is the body of a request installing
+// Synthetic Monitoring in a stack. The instances receive the metrics and logs
+// of the checks.
+type InstallSyntheticMonitoringRequest struct {
+	StackID           int `json:"stackId"`
+	MetricsInstanceID int `json:"metricsInstanceId"`
+	LogsInstanceID    int `json:"logsInstanceId"`
+}
+
+// SyntheticMonitoringInstallation is the Synthetic Monitoring tenant of a
+// stack along with an access token to manage it
+type SyntheticMonitoringInstallation struct {
+	AccessToken string `json:"accessToken"`
+	TenantInfo  struct {
+		ID int `json:"id"`
+	} `json:"tenantInfo"`
+}
+
+// InstallSyntheticMonitoring installs Synthetic Monitoring in a stack through
+// the api at smURL, authenticated with the token of the client. Installing it
+// again returns the existing tenant with a new access token.
+func (c *Client) InstallSyntheticMonitoring(ctx context.Context, smURL string, reqBody InstallSyntheticMonitoringRequest) (*SyntheticMonitoringInstallation, error) {
+	postBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(smURL, "/")+"/api/v1/register/install", bytes.NewBuffer(postBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("synthetic monitoring api not found at '%s'", smURL),
+		}
+	}
+
+	var jsonResponse SyntheticMonitoringInstallation
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding install synthetic monitoring response: %w", err)
+	}
+
+	return &jsonResponse, nil
+}
+
+// CreateSyntheticMonitoringToken creates an api token of the Synthetic
+// Monitoring tenant the access token returned by InstallSyntheticMonitoring
+// belongs to
+func (c *Client) CreateSyntheticMonitoringToken(ctx context.Context, smURL string, accessToken string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(smURL, "/")+"/api/v1/token/create", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", &statusError{
+			StatusCode: resp.StatusCode,
+			err:        fmt.Errorf("synthetic monitoring api not found at '%s'", smURL),
+		}
+	}
+
+	var jsonResponse struct {
+		Token string `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&jsonResponse)
+	if err != nil {
+		return "", fmt.Errorf("error decoding create synthetic monitoring token response: %w", err)
+	}
+
+	return jsonResponse.Token, nil
+}
+
+// DeleteSyntheticMonitoringToken deletes an api token of the Synthetic
+// Monitoring tenant the access token belongs to. Deleting a token that does
+// not exist succeeds.
+func (c *Client) DeleteSyntheticMonitoringToken(ctx context.Context, smURL string, accessToken string, token string) error {
+	deleteBody, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "DELETE", strings.TrimSuffix(smURL, "/")+"/api/v1/token/delete", bytes.NewBuffer(deleteBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.performGrafanaAPIOperation(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package grafanacloud
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateLabelSelector checks that selector is a PromQL style label selector
+// such as {env="prod", team=~"a|b"}
+func validateLabelSelector(selector string) error {
+	selector = strings.TrimSpace(selector)
+	if !strings.HasPrefix(selector, "{") || !strings.HasSuffix(selector, "}") {
+		return fmt.Errorf("selector must be enclosed in braces")
+	}
+
+	matchers, err := splitLabelMatchers(selector[1 : len(selector)-1])
+	if err != nil {
+		return err
+	}
+	if len(matchers) == 0 {
+		return fmt.Errorf("selector must have at least one matcher")
+	}
+
+	for _, matcher := range matchers {
+		if err := validateLabelMatcher(matcher); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitLabelMatchers splits the matchers of a selector on the commas outside
+// of quoted values
+func splitLabelMatchers(matchers string) ([]string, error) {
+	var split []string
+	var current strings.Builder
+	inQuotes, escaped := false, false
+	for _, r := range matchers {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && inQuotes:
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == ',' && !inQuotes:
+			split = append(split, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quoted value")
+	}
+	if last := strings.TrimSpace(current.String()); last != "" || len(split) > 0 {
+		split = append(split, current.String())
+	}
+
+	return split, nil
+}
+
+func validateLabelMatcher(matcher string) error {
+	matcher = strings.TrimSpace(matcher)
+
+	opIndex := strings.IndexAny(matcher, "=!")
+	if opIndex < 0 {
+		return fmt.Errorf("matcher '%s' has no operator", matcher)
+	}
+	name := strings.TrimSpace(matcher[:opIndex])
+	if !labelNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid label name '%s'", name)
+	}
+
+	rest := matcher[opIndex:]
+	var op string
+	for _, candidate := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return fmt.Errorf("matcher '%s' has an invalid operator", matcher)
+	}
+
+	value, err := strconv.Unquote(strings.TrimSpace(rest[len(op):]))
+	if err != nil {
+		return fmt.Errorf("value of matcher '%s' must be a quoted string", matcher)
+	}
+	if op == "=~" || op == "!~" {
+		if _, err := regexp.Compile(value); err != nil {
+			return fmt.Errorf("invalid regex in matcher '%s': %w", matcher, err)
+		}
+	}
+
+	return nil
+}
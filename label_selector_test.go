@@ -0,0 +1,38 @@
+package grafanacloud
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLabelSelector(t *testing.T) {
+	testCases := []struct {
+		selector string
+		valid    bool
+	}{
+		{`{env="prod"}`, true},
+		{`{env="prod", team=~"a|b"}`, true},
+		{`{namespace!="kube-system",cluster!~"dev-.*"}`, true},
+		{`{msg="a,b"}`, true},
+		{`{msg="quoted \"value\""}`, true},
+		{`env="prod"`, false},
+		{`{}`, false},
+		{`{env=prod}`, false},
+		{`{env}`, false},
+		{`{1env="prod"}`, false},
+		{`{env=~"("}`, false},
+		{`{env="prod}`, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.selector, func(t *testing.T) {
+			err := validateLabelSelector(testCase.selector)
+			if testCase.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
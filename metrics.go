@@ -0,0 +1,52 @@
+package grafanacloud
+
+import (
+	"strconv"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// metricKey prefixes the name of a metric emitted by the backend so they are
+// grouped under secrets.grafana_cloud
+func metricKey(parts ...string) []string {
+	return append([]string{"secrets", "grafana_cloud"}, parts...)
+}
+
+// recordAPIRequest measures a request to the grafana cloud api. statusCode is
+// 0 when no response was received.
+func recordAPIRequest(method string, statusCode int, errorCode string, duration time.Duration) {
+	status := "none"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	labels := []metrics.Label{
+		{Name: "method", Value: method},
+		{Name: "status", Value: status},
+	}
+	metrics.AddSampleWithLabels(metricKey("api", "request"), float32(duration.Seconds()*1000), labels)
+
+	if statusCode == 0 || (statusCode >= 400 && statusCode != 404) {
+		metrics.IncrCounterWithLabels(metricKey("api", "error"), 1, append(labels, metrics.Label{Name: "code", Value: errorCode}))
+	}
+}
+
+// recordTokens counts tokens issued or revoked for a role
+func recordTokens(event string, role string, count int) {
+	metrics.IncrCounterWithLabels(metricKey("creds", event), float32(count), []metrics.Label{
+		{Name: "role", Value: role},
+	})
+}
+
+// recordRootRotation counts rotations of the token of a configuration by
+// whether they succeeded
+func recordRootRotation(configName string, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	metrics.IncrCounterWithLabels(metricKey("rotate_root"), 1, []metrics.Label{
+		{Name: "config", Value: configTokenStorageKey(configName)},
+		{Name: "outcome", Value: outcome},
+	})
+}
@@ -3,16 +3,37 @@ package grafanacloud
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 func pathListAccessPolicies(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "access_policies/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"after": {
+				Type:        framework.TypeString,
+				Description: "Only list access policies sorting after this name",
+			},
+			"limit": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of access policies to return. Unlimited when 0",
+			},
+		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ListOperation: b.pathAccessPolicyList,
@@ -37,15 +58,88 @@ func pathAccessPolicies(b *backend) *framework.Path {
 
 			"policy": &framework.FieldSchema{
 				Type:        framework.TypeString,
-				Description: `The policy to apply for the access policy. Accepts all arguments specified by https://grafana.com/docs/grafana-cloud/developer-resources/api-reference/cloud-api/#create-an-access-policy`,
+				Description: `The policy to apply for the access policy. Accepts all arguments specified by https://grafana.com/docs/grafana-cloud/developer-resources/api-reference/cloud-api/#create-an-access-policy. {{org_id}} and {{stack_id:"<slug>"}} are replaced with the matching ids`,
+			},
+
+			"display_name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Display name of the access policy. Overrides displayName of policy",
+			},
+
+			"scopes": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Scopes granted by the access policy, e.g. metrics:read. Overrides scopes of policy",
+			},
+
+			"realms": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Realms of the access policy as type:identifier pairs, e.g. org:1234, stack:5678 or org:{{org_id}}. Overrides realms of policy",
+			},
+
+			"label_policies": &framework.FieldSchema{
+				Type:        framework.TypeStringSlice,
+				Description: `Label selectors restricting the data of every realm, e.g. {env="prod"}. Overrides labelPolicies of the realms`,
+			},
+
+			"conditions": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: `Conditions of the access policy, e.g. {"allowedSubnets": ["10.0.0.0/8"]}. Overrides conditions of policy`,
+			},
+
+			"allowed_subnets": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "IPv4 or IPv6 CIDRs tokens of the access policy may be used from. Overrides allowedSubnets of conditions and policy",
+			},
+
+			"config": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ whose organization the access policy is created in. Uses config/token when empty",
+			},
+
+			"ttl_jitter": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Percentage (0-50) of the TTL to randomly shave off each issued token so tokens issued together do not expire together",
+			},
+
+			"pool_size": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Number of ready tokens to keep pre-provisioned for this access policy. Disabled when 0",
+			},
+
+			"rate_limit": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Maximum number of credentials issued per minute for this access policy. Unlimited when 0",
+			},
+
+			"status": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Either active or inactive. Credentials are not issued for inactive access policies",
+			},
+
+			"update_remote": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Also set the status of the access policy in Grafana Cloud, which disables the tokens already issued for it when inactive",
+			},
+
+			"dry_run": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Validate the access policy and return the payload that would be sent to Grafana Cloud without changing anything",
+			},
+
+			"keep_remote": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "On delete, only stop managing the access policy and leave it in Grafana Cloud",
 			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.DeleteOperation: b.pathAccessPoliciesDelete,
 			logical.ReadOperation:   b.pathAccessPoliciesRead,
-			logical.UpdateOperation: b.pathAccessPoliciesWrite,
+			logical.CreateOperation: b.pathAccessPoliciesWrite,
+			logical.UpdateOperation: b.pathAccessPoliciesUpdate,
+			logical.PatchOperation:  b.pathAccessPoliciesUpdate,
 		},
+		ExistenceCheck: b.accessPoliciesExistenceCheck,
 
 		HelpSynopsis:    pathAccessPoliciesHelpSyn,
 		HelpDescription: pathAccessPoliciesHelpDesc,
@@ -58,7 +152,29 @@ func (b *backend) pathAccessPolicyList(ctx context.Context, req *logical.Request
 		return nil, err
 	}
 
-	return logical.ListResponse(entries), nil
+	limit := d.Get("limit").(int)
+	if limit < 0 {
+		return logical.ErrorResponse("limit must not be negative, got %d", limit), nil
+	}
+
+	return logical.ListResponse(paginateKeys(entries, d.Get("after").(string), limit)), nil
+}
+
+// paginateKeys returns at most limit of the sorted keys that come after the
+// key after. All keys are returned when after is empty and limit is 0.
+func paginateKeys(keys []string, after string, limit int) []string {
+	sort.Strings(keys)
+	if after != "" {
+		keys = keys[sort.SearchStrings(keys, after):]
+		if len(keys) > 0 && keys[0] == after {
+			keys = keys[1:]
+		}
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	return keys
 }
 
 func (b *backend) pathAccessPoliciesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -67,6 +183,10 @@ func (b *backend) pathAccessPoliciesDelete(ctx context.Context, req *logical.Req
 		return logical.ErrorResponse("missing access policy name"), nil
 	}
 
+	lock := locksutil.LockForKey(b.policyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
 	entry, err := b.accessPoliciesRead(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
@@ -74,16 +194,31 @@ func (b *backend) pathAccessPoliciesDelete(ctx context.Context, req *logical.Req
 	if entry == nil {
 		return nil, nil
 	}
+	if entry.Role != "" {
+		return logical.ErrorResponse("access policy '%s' is generated for role '%s' and is deleted with it", name, entry.Role), nil
+	}
 
-	c, err := b.client(ctx, req.Storage)
+	c, err := b.configClient(ctx, req.Storage, entry.Config)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = c.DeleteAccessPolicy(entry.Policy.ID)
-	if err != nil {
-		return logical.ErrorResponse("failed to delete access policy with id '%s': %s", entry.Policy.ID, err), nil
+	if err := b.drainTokenPool(ctx, req.Storage, c, name); err != nil {
+		return logical.ErrorResponse("failed to delete pooled tokens of access policy '%s': %s", name, err), nil
+	}
 
+	// A policy already deleted in grafana cloud is not an error so the entry
+	// does not get stuck in storage
+	var resp *logical.Response
+	if !d.Get("keep_remote").(bool) {
+		existed, err := c.DeleteAccessPolicy(ctx, entry.Policy.ID)
+		if err != nil {
+			return logical.ErrorResponse("failed to delete access policy with id '%s': %s", entry.Policy.ID, err), nil
+		}
+		if !existed {
+			resp = &logical.Response{}
+			resp.AddWarning(fmt.Sprintf("access policy '%s' was already deleted in grafana cloud", entry.Policy.ID))
+		}
 	}
 
 	var respPolicy map[string]interface{}
@@ -100,8 +235,9 @@ func (b *backend) pathAccessPoliciesDelete(ctx context.Context, req *logical.Req
 	if err != nil {
 		return nil, err
 	}
+	b.evictCredsLimiter("access_policies/" + name)
 
-	return nil, nil
+	return resp, nil
 }
 
 func (b *backend) pathAccessPoliciesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -127,12 +263,145 @@ func (b *backend) pathAccessPoliciesRead(ctx context.Context, req *logical.Reque
 		return nil, fmt.Errorf("failed to unmarshal resp: %w", err)
 	}
 
-	return &logical.Response{
+	// Entries written before checksums were stored get one computed on read
+	if entry.Checksum == "" {
+		checksum, err := accessPolicyChecksum(entry.Policy)
+		if err != nil {
+			return nil, err
+		}
+		respPolicy["checksum"] = checksum
+	}
+
+	resp := &logical.Response{
 		Data: respPolicy,
-	}, nil
+	}
+
+	// Out of band edits in grafana cloud are reported rather than failing the
+	// read, which only depends on storage
+	c, err := b.configClient(ctx, req.Storage, entry.Config)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("failed to check access policy for drift: %s", err))
+		return resp, nil
+	}
+	remote, err := c.GetAccessPolicy(ctx, entry.Policy.ID)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("failed to check access policy for drift: %s", err))
+		return resp, nil
+	}
+
+	if remote == nil {
+		resp.Data["remote"] = nil
+		resp.Data["drifted"] = true
+		resp.Data["drifted_fields"] = []string{}
+		resp.AddWarning(fmt.Sprintf("access policy '%s' does not exist in grafana cloud", entry.Policy.ID))
+		return resp, nil
+	}
+
+	var remoteData map[string]interface{}
+	in, err := json.Marshal(remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal resp: %w", err)
+	}
+	if err := json.Unmarshal(in, &remoteData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resp: %w", err)
+	}
+	driftedFields, err := accessPolicyDrift(entry.Policy, *remote)
+	if err != nil {
+		return nil, err
+	}
+	resp.Data["remote"] = remoteData
+	resp.Data["drifted"] = len(driftedFields) > 0
+	resp.Data["drifted_fields"] = driftedFields
+
+	return resp, nil
+}
+
+// accessPolicyDrift returns the fields of the stored access policy that
+// differ from the remote one
+func accessPolicyDrift(stored gcom.AccessPolicy, remote gcom.AccessPolicy) ([]string, error) {
+	sortedScopes := func(scopes []string) []string {
+		sorted := slices.Clone(scopes)
+		slices.Sort(sorted)
+		return sorted
+	}
+
+	fields := []struct {
+		name           string
+		stored, remote interface{}
+	}{
+		{"name", stored.Name, remote.Name},
+		{"displayName", stored.DisplayName, remote.DisplayName},
+		{"scopes", sortedScopes(stored.Scopes), sortedScopes(remote.Scopes)},
+		{"realms", stored.Realms, remote.Realms},
+		{"conditions", stored.Conditions, remote.Conditions},
+	}
+
+	drifted := []string{}
+	for _, field := range fields {
+		storedJSON, err := json.Marshal(field.stored)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s: %w", field.name, err)
+		}
+		remoteJSON, err := json.Marshal(field.remote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s: %w", field.name, err)
+		}
+		if !bytes.Equal(storedJSON, remoteJSON) {
+			drifted = append(drifted, field.name)
+		}
+	}
+
+	return drifted, nil
+}
+
+func (b *backend) accessPoliciesExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	entry, err := b.accessPoliciesRead(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return entry != nil, nil
+}
+
+// pathAccessPoliciesUpdate changes an existing access policy. Patches only
+// change the given fields, merging 'policy' into the stored definition.
+func (b *backend) pathAccessPoliciesUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing access policy name"), nil
+	}
+
+	lock := locksutil.LockForKey(b.policyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := b.accessPoliciesRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("access policy '%s' does not exist", name), nil
+	}
+
+	return b.writeAccessPolicy(ctx, req, d)
 }
 
 func (b *backend) pathAccessPoliciesWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing access policy name"), nil
+	}
+
+	lock := locksutil.LockForKey(b.policyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return b.writeAccessPolicy(ctx, req, d)
+}
+
+// writeAccessPolicy creates or changes the access policy of a write or patch.
+// The caller must hold the policy lock of the access policy.
+func (b *backend) writeAccessPolicy(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	var resp logical.Response
 
 	name := d.Get("name").(string)
@@ -147,32 +416,246 @@ func (b *backend) pathAccessPoliciesWrite(ctx context.Context, req *logical.Requ
 	if entry == nil {
 		entry = &accessPolicyEntry{}
 	}
+	if entry.Role != "" {
+		return logical.ErrorResponse("access policy '%s' is generated for role '%s' and can only be changed through the role", name, entry.Role), nil
+	}
+
+	if configRaw, ok := d.GetOk("config"); ok {
+		config := configRaw.(string)
+		if entry.Policy.ID != "" && config != entry.Config {
+			return logical.ErrorResponse("cannot change the config of existing access policy '%s'", name), nil
+		}
+		entry.Config = config
+	}
+
+	if ttlJitterRaw, ok := d.GetOk("ttl_jitter"); ok {
+		ttlJitter := ttlJitterRaw.(int)
+		if ttlJitter < 0 || ttlJitter > maxTTLJitter {
+			return logical.ErrorResponse("ttl_jitter must be between 0 and %d, got %d", maxTTLJitter, ttlJitter), nil
+		}
+		entry.TTLJitter = ttlJitter
+	}
+
+	if poolSizeRaw, ok := d.GetOk("pool_size"); ok {
+		poolSize := poolSizeRaw.(int)
+		if poolSize < 0 {
+			return logical.ErrorResponse("pool_size must not be negative, got %d", poolSize), nil
+		}
+		entry.PoolSize = poolSize
+	}
+
+	if rateLimitRaw, ok := d.GetOk("rate_limit"); ok {
+		rateLimit := rateLimitRaw.(int)
+		if rateLimit < 0 {
+			return logical.ErrorResponse("rate_limit must not be negative, got %d", rateLimit), nil
+		}
+		entry.RateLimit = rateLimit
+	}
 
-	var policy map[string]interface{}
+	c, err := b.configClient(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusRaw, ok := d.GetOk("status"); ok {
+		status := statusRaw.(string)
+		if status != accessPolicyStatusActive && status != accessPolicyStatusInactive {
+			return logical.ErrorResponse("status must be '%s' or '%s', got '%s'", accessPolicyStatusActive, accessPolicyStatusInactive, status), nil
+		}
+		entry.Status = status
+	}
+	if entry.Status == "" {
+		entry.Status = accessPolicyStatusActive
+	}
+
+	policy := map[string]interface{}{}
 	if policyRaw, ok := d.GetOk("policy"); ok {
 		s, ok := d.Get("policy").(string)
 		if !ok {
 			return logical.ErrorResponse(fmt.Sprintf("cannot parse policy. raw: %q, err: %s", policyRaw.(string), err)), nil
 		}
 
-		err := json.Unmarshal([]byte(s), &policy)
+		s, err = resolvePolicyVariables(ctx, c, s)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		// Patches merge the given policy into the stored one as a JSON merge
+		// patch (RFC 7396) rather than replacing it
+		if req.Operation == logical.PatchOperation {
+			base, err := accessPolicyBody(entry.Policy)
+			if err != nil {
+				return nil, err
+			}
+			baseJSON, err := json.Marshal(base)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal policy: %w", err)
+			}
+			merged, err := jsonpatch.MergePatch(baseJSON, []byte(s))
+			if err != nil {
+				return logical.ErrorResponse("cannot merge policy patch. raw: %q, err: %s", policyRaw.(string), err), nil
+			}
+			s = string(merged)
+		}
+
+		err = json.Unmarshal([]byte(s), &policy)
 		if err != nil {
 			return logical.ErrorResponse(fmt.Sprintf("cannot unmarshall policy. raw: %q, err: %s", policyRaw.(string), err)), nil
 		}
+		if req.Operation == logical.PatchOperation {
+			// The stored name may predate a rename and is replaced below
+			delete(policy, "name")
+		}
+		if err := validatePolicyBody(name, policy); err != nil {
+			return logical.ErrorResponse("invalid policy: %s", err), nil
+		}
+	} else if entry.Policy.ID != "" {
+		policy, err = accessPolicyBody(entry.Policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if displayName, ok := d.GetOk("display_name"); ok {
+		policy["displayName"] = displayName.(string)
+	}
+	if scopes, ok := d.GetOk("scopes"); ok {
+		policy["scopes"] = scopes.([]string)
+	}
+	if realmsRaw, ok := d.GetOk("realms"); ok {
+		realmsList := make([]string, 0, len(realmsRaw.([]string)))
+		for _, realm := range realmsRaw.([]string) {
+			realm, err := resolvePolicyVariables(ctx, c, realm)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			realmsList = append(realmsList, realm)
+		}
+		realms, err := parseRealms(realmsList)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		policy["realms"] = realms
 	}
+	if labelPoliciesRaw, ok := d.GetOk("label_policies"); ok {
+		labelPolicies := make([]map[string]interface{}, 0, len(labelPoliciesRaw.([]string)))
+		for _, selector := range labelPoliciesRaw.([]string) {
+			if err := validateLabelSelector(selector); err != nil {
+				return logical.ErrorResponse("invalid label policy '%s': %s", selector, err), nil
+			}
+			labelPolicies = append(labelPolicies, map[string]interface{}{
+				"selector": selector,
+			})
+		}
 
-	c, err := b.client(ctx, req.Storage)
+		realms, err := policyBodyRealms(policy)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if len(realms) == 0 {
+			return logical.ErrorResponse("label_policies requires at least one realm"), nil
+		}
+		for _, realm := range realms {
+			realm["labelPolicies"] = labelPolicies
+		}
+		policy["realms"] = realms
+	}
+	if conditions, ok := d.GetOk("conditions"); ok {
+		if err := validatePolicyConditions(conditions); err != nil {
+			return logical.ErrorResponse("invalid conditions: %s", err), nil
+		}
+		policy["conditions"] = conditions
+	}
+	if subnets, ok := d.GetOk("allowed_subnets"); ok {
+		conditions, _ := policy["conditions"].(map[string]interface{})
+		if conditions == nil {
+			conditions = map[string]interface{}{}
+		}
+		conditions["allowedSubnets"] = subnets.([]string)
+		policy["conditions"] = conditions
+	}
+	if conditions, ok := policy["conditions"].(map[string]interface{}); ok {
+		var subnets []string
+		switch rawSubnets := conditions["allowedSubnets"].(type) {
+		case []string:
+			subnets = rawSubnets
+		case []interface{}:
+			for _, rawSubnet := range rawSubnets {
+				subnet, _ := rawSubnet.(string)
+				subnets = append(subnets, subnet)
+			}
+		}
+		for _, subnet := range subnets {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				return logical.ErrorResponse("invalid allowed_subnets entry '%s': %s", subnet, err), nil
+			}
+		}
+	}
+	realms, err := policyBodyRealms(policy)
 	if err != nil {
-		return nil, err
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := resolveRealmStacks(ctx, c, realms); err != nil {
+		return logical.ErrorResponse("invalid realms: %s", err), nil
+	}
+	if realms != nil {
+		policy["realms"] = realms
+	}
+	if len(policyBodyScopes(policy)) == 0 {
+		return logical.ErrorResponse("access policy '%s' must grant at least one scope, set policy or scopes", name), nil
+	}
+	for _, scope := range unknownScopes(policyBodyScopes(policy)) {
+		resp.AddWarning(fmt.Sprintf("scope '%s' is not a known Grafana Cloud scope", scope))
+	}
+
+	if d.Get("update_remote").(bool) {
+		policy["status"] = entry.Status
 	}
 
+	updateBody := make(map[string]interface{}, len(policy))
+	for key, value := range policy {
+		updateBody[key] = value
+	}
+	delete(updateBody, "name")
 	policy["name"] = name
-	accessPolicy, err := c.CreateAccessPolicy(policy)
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to create policy '%s' in grafana cloud: %s", name, err)), nil
+
+	if d.Get("dry_run").(bool) {
+		resp.Data = map[string]interface{}{
+			"operation": "create",
+			"payload":   policy,
+		}
+		if entry.Policy.ID != "" {
+			resp.Data["operation"] = "update"
+			resp.Data["id"] = entry.Policy.ID
+			resp.Data["payload"] = updateBody
+		}
+
+		return &resp, nil
+	}
+
+	// Rewrites update the existing policy so tokens issued for it stay valid
+	// and no duplicate is left behind in grafana cloud
+	var accessPolicy *gcom.AccessPolicy
+	if entry.Policy.ID != "" {
+		accessPolicy, err = c.UpdateAccessPolicy(ctx, entry.Policy.ID, updateBody)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to update policy '%s' in grafana cloud: %s", name, err)), nil
+		}
+	}
+	var walID string
+	if accessPolicy == nil {
+		// Rolled back if the policy is not stored, so it is not left behind
+		// in grafana cloud without vault knowing about it
+		accessPolicy, walID, err = createAccessPolicyWithWAL(ctx, req.Storage, c, entry.Config, name, policy)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to create policy '%s' in grafana cloud: %s", name, err)), nil
+		}
 	}
 
 	entry.Policy = *accessPolicy
+	if err := entry.markSynced(); err != nil {
+		return nil, err
+	}
 
 	storageEntry, err := logical.StorageEntryJSON("access_policies/"+name, *entry)
 	if err != nil {
@@ -184,6 +667,18 @@ func (b *backend) pathAccessPoliciesWrite(ctx context.Context, req *logical.Requ
 	if err := req.Storage.Put(ctx, storageEntry); err != nil {
 		return nil, err
 	}
+	b.evictCredsLimiter("access_policies/" + name)
+	if walID != "" {
+		if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+			return nil, fmt.Errorf("error deleting WAL entry: %w", err)
+		}
+
+		// Pooled tokens left behind by an earlier policy of that name are
+		// never handed out for the new one
+		if err := b.drainTokenPool(ctx, req.Storage, c, name); err != nil {
+			b.Logger().Error("failed to drain token pool of replaced access policy", "policy", name, "error", err)
+		}
+	}
 
 	var respData map[string]interface{}
 	in, err := json.Marshal(accessPolicy)
@@ -192,6 +687,8 @@ func (b *backend) pathAccessPoliciesWrite(ctx context.Context, req *logical.Requ
 	}
 
 	err = json.NewDecoder(bytes.NewBuffer(in)).Decode(&respData)
+	respData["checksum"] = entry.Checksum
+	respData["last_synced_at"] = entry.LastSyncedAt
 	resp.Data = respData
 
 	return &resp, nil
@@ -216,8 +713,183 @@ func (b *backend) accessPoliciesRead(ctx context.Context, s logical.Storage, nam
 	return nil, nil
 }
 
+// recreateAccessPolicy creates the stored access policy again in grafana cloud
+// after it was deleted there, updating the stored entry with its new ID
+func (b *backend) recreateAccessPolicy(ctx context.Context, s logical.Storage, c GrafanaClient, name string, entry *accessPolicyEntry) (*accessPolicyEntry, error) {
+	body, err := accessPolicyBody(entry.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	accessPolicy, err := c.CreateAccessPolicy(ctx, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recreate policy '%s' in grafana cloud: %w", name, err)
+	}
+
+	recreated := *entry
+	recreated.Policy = *accessPolicy
+	if err := recreated.markSynced(); err != nil {
+		return nil, err
+	}
+	storageEntry, err := logical.StorageEntryJSON("access_policies/"+name, recreated)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, storageEntry); err != nil {
+		return nil, err
+	}
+
+	// Tokens of the deleted policy were deleted along with it
+	if err := b.drainTokenPool(ctx, s, c, name); err != nil {
+		b.Logger().Error("failed to drain token pool of recreated access policy", "policy", name, "error", err)
+	}
+
+	return &recreated, nil
+}
+
+// accessPolicyBody returns the request body that creates the access policy,
+// without the fields set by grafana cloud
+func accessPolicyBody(policy gcom.AccessPolicy) (map[string]interface{}, error) {
+	in, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal policy: %w", err)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(in, &body); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal policy: %w", err)
+	}
+	for _, key := range []string{"id", "orgId", "createdAt", "updatedAt"} {
+		delete(body, key)
+	}
+
+	return body, nil
+}
+
+const (
+	accessPolicyStatusActive   = "active"
+	accessPolicyStatusInactive = "inactive"
+)
+
 type accessPolicyEntry struct {
-	Policy AccessPolicy
+	Policy    gcom.AccessPolicy
+	Config    string `json:"config"`
+	TTLJitter int    `json:"ttl_jitter"`
+	PoolSize  int    `json:"pool_size"`
+	RateLimit int    `json:"rate_limit"`
+	Status    string `json:"status"`
+
+	// Role is the name of the role the policy was generated for from its
+	// products, empty for policies managed under access_policies/
+	Role string `json:"role,omitempty"`
+
+	// Checksum is the sha256 of the policy definition last written to
+	// grafana cloud, at LastSyncedAt
+	Checksum     string    `json:"checksum"`
+	LastSyncedAt time.Time `json:"last_synced_at"`
+}
+
+// markSynced records that the policy definition was just written to grafana
+// cloud
+func (e *accessPolicyEntry) markSynced() error {
+	checksum, err := accessPolicyChecksum(e.Policy)
+	if err != nil {
+		return err
+	}
+	e.Checksum = checksum
+	e.LastSyncedAt = time.Now().UTC()
+
+	return nil
+}
+
+// accessPolicyChecksum hashes the definition of the access policy, leaving out
+// the fields set by grafana cloud so it only changes when the definition does
+func accessPolicyChecksum(policy gcom.AccessPolicy) (string, error) {
+	body, err := accessPolicyBody(policy)
+	if err != nil {
+		return "", err
+	}
+	// Maps are marshalled with sorted keys, keeping the checksum stable
+	in, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal policy: %w", err)
+	}
+	sum := sha256.Sum256(in)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// inactive reports whether credentials must not be issued for the access
+// policy. Entries written before status existed are active.
+func (e *accessPolicyEntry) inactive() bool {
+	return e.Status == accessPolicyStatusInactive
+}
+
+// policyBodyRealms returns the realms of an access policy request body
+func policyBodyRealms(policy map[string]interface{}) ([]map[string]interface{}, error) {
+	switch realms := policy["realms"].(type) {
+	case nil:
+		return nil, nil
+	case []map[string]interface{}:
+		return realms, nil
+	case []interface{}:
+		converted := make([]map[string]interface{}, 0, len(realms))
+		for _, rawRealm := range realms {
+			realm, ok := rawRealm.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid realm %v", rawRealm)
+			}
+			converted = append(converted, realm)
+		}
+		return converted, nil
+	default:
+		return nil, fmt.Errorf("invalid realms %v", realms)
+	}
+}
+
+// parseRealms converts type:identifier pairs to the realms of an access policy
+func parseRealms(pairs []string) ([]map[string]interface{}, error) {
+	realms := make([]map[string]interface{}, 0, len(pairs))
+	for _, pair := range pairs {
+		realmType, identifier, ok := strings.Cut(pair, ":")
+		if !ok || identifier == "" {
+			return nil, fmt.Errorf("invalid realm '%s', must be type:identifier", pair)
+		}
+		if realmType != "org" && realmType != "stack" {
+			return nil, fmt.Errorf("invalid realm type '%s', must be org or stack", realmType)
+		}
+		realms = append(realms, map[string]interface{}{
+			"type":       realmType,
+			"identifier": identifier,
+		})
+	}
+
+	return realms, nil
+}
+
+// resolveRealmStacks replaces the slugs used as the identifier of stack
+// realms with the ID of the stack, failing for stacks that do not exist
+func resolveRealmStacks(ctx context.Context, c GrafanaClient, realms []map[string]interface{}) error {
+	for _, realm := range realms {
+		if realm["type"] != "stack" {
+			continue
+		}
+		identifier, ok := realm["identifier"].(string)
+		if !ok {
+			continue
+		}
+		if _, err := strconv.Atoi(identifier); err == nil {
+			continue
+		}
+
+		id, err := c.StackID(ctx, identifier)
+		if err != nil {
+			return err
+		}
+		realm["identifier"] = strconv.Itoa(id)
+	}
+
+	return nil
 }
 
 func compactJSON(input string) (string, error) {
@@ -228,7 +900,9 @@ func compactJSON(input string) (string, error) {
 
 const pathListAccessPoliciesHelpSyn = `List the existing access policies in this backend`
 
-const pathListAccessPoliciesHelpDesc = `Access policies will be listed by the name.`
+const pathListAccessPoliciesHelpDesc = `Access policies will be listed by the name.
+Use 'limit' to page through them, passing the last name returned as 'after'
+to continue from it.`
 
 const pathAccessPoliciesHelpSyn = `
 Read, write and reference access policy token can be made for.
@@ -236,4 +910,36 @@ Read, write and reference access policy token can be made for.
 
 const pathAccessPoliciesHelpDesc = `
 This path allows you to read and write policy that are used to
-create access policy tokens.`
+create access policy tokens.
+
+Reads compare the stored access policy with the one in Grafana Cloud and
+return it under 'remote', with 'drifted' set and the differing fields listed
+in 'drifted_fields' when it was edited outside of Vault. 'checksum' is a hash
+of the access policy definition and 'last_synced_at' the time it was last
+written to Grafana Cloud, so pipelines can tell whether they need to reapply
+it without comparing whole documents.
+
+Instead of a JSON 'policy', the access policy can be described with the
+'display_name', 'scopes', 'realms', 'label_policies', 'conditions' and
+'allowed_subnets' fields, which take precedence over the matching keys of
+'policy'.
+
+The 'policy' and 'realms' may reference {{org_id}}, the id of the organization
+of the configured token, and {{stack_id:"<slug>"}}, the id of the stack with
+the given slug. They are resolved when the access policy is written so the
+same definition can be used across organizations and stacks.
+
+Setting 'status' to inactive stops credentials from being issued for the access
+policy without deleting anything. With 'update_remote' the status is also set
+in Grafana Cloud, which disables the tokens already issued for it.
+
+With 'dry_run' the access policy is validated and its template variables are
+resolved, but instead of being written the payload that would be sent to
+Grafana Cloud is returned.
+
+Patching the access policy only changes the given fields. A 'policy' given
+to a patch is merged into the stored definition as a JSON merge patch, so
+'{"scopes": ["metrics:read"]}' replaces the scopes and leaves the rest as is.
+
+Deleting the access policy also deletes it in Grafana Cloud unless
+'keep_remote' is set, in which case Vault only stops managing it.`
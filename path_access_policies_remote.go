@@ -0,0 +1,194 @@
+package grafanacloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// remoteAccessPoliciesName is the access policy name taken by the paths of
+// the access policies in grafana cloud
+const remoteAccessPoliciesName = "remote"
+
+func pathListRemoteAccessPolicies(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "access_policies/" + remoteAccessPoliciesName + "/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"page_size": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of access policies to return",
+			},
+			"page_cursor": {
+				Type:        framework.TypeString,
+				Description: "Cursor returned by a previous list to continue from",
+			},
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ whose organization is listed. Uses config/token when empty",
+			},
+			"unmanaged": {
+				Type:        framework.TypeBool,
+				Description: "Only list access policies not managed by this mount",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRemoteAccessPolicyList,
+		},
+
+		HelpSynopsis:    pathListRemoteAccessPoliciesHelpSyn,
+		HelpDescription: pathListRemoteAccessPoliciesHelpDesc,
+	}
+}
+
+func pathRemoteAccessPolicies(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "access_policies/" + remoteAccessPoliciesName + "/" + framework.GenericNameRegex("id"),
+		Fields: map[string]*framework.FieldSchema{
+			"id": {
+				Type:        framework.TypeString,
+				Description: "Grafana Cloud ID of the access policy",
+			},
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ whose organization the policy is read from. Uses config/token when empty",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRemoteAccessPoliciesRead,
+		},
+
+		HelpSynopsis:    pathRemoteAccessPoliciesHelpSyn,
+		HelpDescription: pathRemoteAccessPoliciesHelpDesc,
+	}
+}
+
+func (b *backend) pathRemoteAccessPolicyList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	c, err := b.configClient(ctx, req.Storage, d.Get("config").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := c.ListAccessPolicies(ctx, d.Get("page_size").(int), d.Get("page_cursor").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to list access policies in grafana cloud: %s", err)), nil
+	}
+	nextCursor, err := policies.NextPageCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	managed, err := b.managedAccessPolicies(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	unmanagedOnly := d.Get("unmanaged").(bool)
+	keys := make([]string, 0, len(policies.Items))
+	keyInfo := make(map[string]interface{}, len(policies.Items))
+	for _, policy := range policies.Items {
+		vaultName, isManaged := managed[policy.ID]
+		if unmanagedOnly && isManaged {
+			continue
+		}
+		keys = append(keys, policy.ID)
+		keyInfo[policy.ID] = map[string]interface{}{
+			"name":         policy.Name,
+			"display_name": policy.DisplayName,
+			"managed":      isManaged,
+			"vault_name":   vaultName,
+		}
+	}
+
+	resp := logical.ListResponseWithInfo(keys, keyInfo)
+	if nextCursor != "" {
+		resp.Data["next_page_cursor"] = nextCursor
+	}
+
+	return resp, nil
+}
+
+func (b *backend) pathRemoteAccessPoliciesRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	id := d.Get("id").(string)
+	if id == "" {
+		return logical.ErrorResponse("missing access policy id"), nil
+	}
+
+	c, err := b.configClient(ctx, req.Storage, d.Get("config").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := c.GetAccessPolicy(ctx, id)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to read access policy '%s' from grafana cloud: %s", id, err)), nil
+	}
+	if policy == nil {
+		return nil, nil
+	}
+
+	managed, err := b.managedAccessPolicies(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var respData map[string]interface{}
+	in, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal resp: %w", err)
+	}
+	if err := json.Unmarshal(in, &respData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal resp: %w", err)
+	}
+	vaultName, isManaged := managed[policy.ID]
+	respData["managed"] = isManaged
+	respData["vault_name"] = vaultName
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+// managedAccessPolicies maps the grafana cloud ID of every access policy
+// stored in this mount to the name it is stored under
+func (b *backend) managedAccessPolicies(ctx context.Context, s logical.Storage) (map[string]string, error) {
+	names, err := s.List(ctx, "access_policies/")
+	if err != nil {
+		return nil, err
+	}
+
+	managed := make(map[string]string, len(names))
+	for _, name := range names {
+		entry, err := b.accessPoliciesRead(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		managed[entry.Policy.ID] = name
+	}
+
+	return managed, nil
+}
+
+const pathListRemoteAccessPoliciesHelpSyn = `List the access policies that exist in Grafana Cloud`
+
+const pathListRemoteAccessPoliciesHelpDesc = `
+Lists every access policy in the organization directly from the Grafana Cloud
+API, including the ones not managed by this mount. Policies are listed by their
+Grafana Cloud ID and marked as managed when stored under access_policies/.
+Use 'page_size' and the returned 'next_page_cursor' to page through results,
+and 'unmanaged' to only list the policies this mount does not manage.
+`
+
+const pathRemoteAccessPoliciesHelpSyn = `Read an access policy directly from Grafana Cloud`
+
+const pathRemoteAccessPoliciesHelpDesc = `
+Reads the access policy with the given Grafana Cloud ID from the API, whether
+or not it is managed by this mount.
+`
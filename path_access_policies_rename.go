@@ -0,0 +1,195 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathAccessPolicyRename(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "access_policies/" + framework.GenericNameWithAtRegex("name") + "/rename",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the access policy",
+			},
+			"new_name": {
+				Type:        framework.TypeString,
+				Description: "Name to store the access policy under",
+			},
+			"display_name": {
+				Type:        framework.TypeString,
+				Description: "Display name of the access policy in Grafana Cloud. Kept unless it is the old name, in which case it becomes new_name",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathAccessPolicyRenameUpdate,
+		},
+
+		HelpSynopsis:    pathAccessPolicyRenameHelpSyn,
+		HelpDescription: pathAccessPolicyRenameHelpDesc,
+	}
+}
+
+func (b *backend) pathAccessPolicyRenameUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	newName := d.Get("new_name").(string)
+	if newName == "" {
+		return logical.ErrorResponse("missing new_name"), nil
+	}
+	if newName == name {
+		return logical.ErrorResponse("new_name must differ from the current name"), nil
+	}
+	if newName == remoteAccessPoliciesName {
+		return logical.ErrorResponse("'%s' can not be used as an access policy name, access_policies/%s lists the access policies in grafana cloud", newName, newName), nil
+	}
+
+	for _, lock := range locksutil.LocksForKeys(b.policyLocks, []string{name, newName}) {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	entry, err := b.accessPoliciesRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("access policy '%s' does not exist", name), nil
+	}
+	if entry.Role != "" {
+		return logical.ErrorResponse("access policy '%s' is generated for role '%s' and can not be renamed", name, entry.Role), nil
+	}
+
+	// Tokens issued by creds/:name without a role of that name are tracked
+	// under the name, which their leases still refer to
+	for _, prefix := range []string{issuedTokensPath(name), reusedTokensPath(name)} {
+		outstanding, err := req.Storage.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if len(outstanding) > 0 {
+			return logical.ErrorResponse("access policy '%s' has tokens issued by creds/%s, revoke them before renaming it", name, name), nil
+		}
+	}
+	existing, err := b.accessPoliciesRead(ctx, req.Storage, newName)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return logical.ErrorResponse("access policy '%s' already exists", newName), nil
+	}
+
+	displayName := entry.Policy.DisplayName
+	if displayNameRaw, ok := d.GetOk("display_name"); ok {
+		displayName = displayNameRaw.(string)
+	} else if displayName == name {
+		displayName = newName
+	}
+
+	if displayName != entry.Policy.DisplayName {
+		c, err := b.configClient(ctx, req.Storage, entry.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := accessPolicyBody(entry.Policy)
+		if err != nil {
+			return nil, err
+		}
+		delete(body, "name")
+		body["displayName"] = displayName
+
+		updated, err := c.UpdateAccessPolicy(ctx, entry.Policy.ID, body)
+		if err != nil {
+			return logical.ErrorResponse("failed to update policy '%s' in grafana cloud: %s", name, err), nil
+		}
+		if updated == nil {
+			return logical.ErrorResponse("access policy '%s' does not exist in grafana cloud, sync it before renaming", entry.Policy.ID), nil
+		}
+		entry.Policy = *updated
+		if err := entry.markSynced(); err != nil {
+			return nil, err
+		}
+	}
+
+	storageEntry, err := logical.StorageEntryJSON("access_policies/"+newName, entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, storageEntry); err != nil {
+		return nil, err
+	}
+
+	if err := b.moveTokenPool(ctx, req.Storage, name, newName); err != nil {
+		return nil, err
+	}
+
+	roles, err := b.repointRoles(ctx, req.Storage, name, newName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Delete(ctx, "access_policies/"+name); err != nil {
+		return nil, err
+	}
+	b.evictCredsLimiter("access_policies/" + name)
+	b.evictCredsLimiter("access_policies/" + newName)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":         newName,
+			"id":           entry.Policy.ID,
+			"display_name": entry.Policy.DisplayName,
+			"roles":        roles,
+		},
+	}, nil
+}
+
+// repointRoles changes the access_policy of every role referencing from to
+// to, returning the names of the changed roles
+func (b *backend) repointRoles(ctx context.Context, s logical.Storage, from string, to string) ([]string, error) {
+	names, err := s.List(ctx, rolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	repointed := []string{}
+	for _, name := range names {
+		role, err := b.roleRead(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil || role.AccessPolicy != from {
+			continue
+		}
+
+		role.AccessPolicy = to
+		entry, err := logical.StorageEntryJSON(rolesPrefix+name, role)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update role '%s': %w", name, err)
+		}
+		if err := s.Put(ctx, entry); err != nil {
+			return nil, fmt.Errorf("failed to update role '%s': %w", name, err)
+		}
+		repointed = append(repointed, name)
+	}
+
+	return repointed, nil
+}
+
+const pathAccessPolicyRenameHelpSyn = `Rename an access policy`
+
+const pathAccessPolicyRenameHelpDesc = `
+Moves the access policy to access_policies/:new_name and points every role
+referencing it at the new name. The policy keeps its Grafana Cloud ID, so
+tokens issued for it stay valid. Its display name in Grafana Cloud is updated
+to 'display_name', or to the new name when it was the old name. The name of
+the policy in Grafana Cloud cannot be changed and is left as is. Policies
+generated for a role, and policies with outstanding tokens issued by
+creds/:name without a role of that name, can not be renamed.
+`
@@ -0,0 +1,177 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathAccessPolicySync(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "access_policies/" + framework.GenericNameWithAtRegex("name") + "/sync",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the access policy",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathAccessPolicySyncUpdate,
+		},
+
+		HelpSynopsis:    pathAccessPolicySyncHelpSyn,
+		HelpDescription: pathAccessPolicySyncHelpDesc,
+	}
+}
+
+func pathAccessPoliciesSync(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sync/access_policies",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathAccessPoliciesSyncUpdate,
+		},
+
+		HelpSynopsis:    pathAccessPoliciesSyncHelpSyn,
+		HelpDescription: pathAccessPoliciesSyncHelpDesc,
+	}
+}
+
+func (b *backend) pathAccessPolicySyncUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	lock := locksutil.LockForKey(b.policyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := b.accessPoliciesRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("access policy '%s' does not exist", name), nil
+	}
+
+	synced, action, err := b.syncAccessPolicy(ctx, req.Storage, name, entry)
+	if err != nil {
+		return logical.ErrorResponse("failed to sync access policy '%s': %s", name, err), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":     synced.Policy.ID,
+			"action": action,
+		},
+	}, nil
+}
+
+func (b *backend) pathAccessPoliciesSyncUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	names, err := req.Storage.List(ctx, "access_policies/")
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		result, err := b.syncStoredAccessPolicy(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			results[name] = result
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"access_policies": results,
+		},
+	}, nil
+}
+
+// syncStoredAccessPolicy syncs the access policy name under its policy lock
+// and returns the outcome, or nil when it was deleted in the meantime
+func (b *backend) syncStoredAccessPolicy(ctx context.Context, s logical.Storage, name string) (map[string]interface{}, error) {
+	lock := locksutil.LockForKey(b.policyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := b.accessPoliciesRead(ctx, s, name)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	synced, action, err := b.syncAccessPolicy(ctx, s, name, entry)
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
+		}, nil
+	}
+
+	return map[string]interface{}{
+		"id":     synced.Policy.ID,
+		"action": action,
+	}, nil
+}
+
+// syncAccessPolicy pushes the stored access policy to grafana cloud, updating
+// the remote policy or recreating it when it was deleted. Returns the updated
+// entry and whether it was "updated" or "recreated".
+func (b *backend) syncAccessPolicy(ctx context.Context, s logical.Storage, name string, entry *accessPolicyEntry) (*accessPolicyEntry, string, error) {
+	c, err := b.configClient(ctx, s, entry.Config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body, err := accessPolicyBody(entry.Policy)
+	if err != nil {
+		return nil, "", err
+	}
+	delete(body, "name")
+
+	updated, err := c.UpdateAccessPolicy(ctx, entry.Policy.ID, body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to update policy in grafana cloud: %w", err)
+	}
+	if updated == nil {
+		recreated, err := b.recreateAccessPolicy(ctx, s, c, name, entry)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return recreated, "recreated", nil
+	}
+
+	synced := *entry
+	synced.Policy = *updated
+	if err := synced.markSynced(); err != nil {
+		return nil, "", err
+	}
+	storageEntry, err := logical.StorageEntryJSON("access_policies/"+name, synced)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.Put(ctx, storageEntry); err != nil {
+		return nil, "", err
+	}
+
+	return &synced, "updated", nil
+}
+
+const pathAccessPolicySyncHelpSyn = `Push the stored access policy to Grafana Cloud`
+
+const pathAccessPolicySyncHelpDesc = `
+Reverts edits made to the access policy outside of Vault by writing the stored
+definition back to Grafana Cloud. The access policy is recreated when it was
+deleted there.
+`
+
+const pathAccessPoliciesSyncHelpSyn = `Push every stored access policy to Grafana Cloud`
+
+const pathAccessPoliciesSyncHelpDesc = `
+Syncs every access policy managed by this mount like
+access_policies/:name/sync and reports the outcome per access policy.
+`
@@ -0,0 +1,548 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+// testAccessPolicy creates the access policy name with a metrics:read policy
+func testAccessPolicy(t *testing.T, b *backend, s logical.Storage, name string, data map[string]interface{}) {
+	t.Helper()
+
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data["policy"]; !ok {
+		data["policy"] = `{"displayName": "Readers", "scopes": ["metrics:read"], "realms": [{"type": "org", "identifier": "1"}]}`
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/" + name,
+		Storage:   s,
+		Data:      data,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write access policy %s: resp: %#v err: %v", name, resp, err)
+	}
+}
+
+func TestBackend_access_policy_remote_name_fake(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	// access_policies/remote lists the access policies in grafana cloud, so
+	// a policy of that name could never be read or written
+	testAccessPolicy(t, b, s, "readers", nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "access_policies/readers/rename",
+		Storage:   s,
+		Data:      map[string]interface{}{"new_name": "remote"},
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Contains(t, resp.Error().Error(), "'remote' can not be used as an access policy name")
+	}
+
+	entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+	assert.NoError(t, err)
+	assert.NotNil(t, entry)
+}
+
+func TestBackend_access_policy_rename_outstanding_fake(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	testAccessPolicy(t, b, s, "readers", nil)
+	creds, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/readers",
+		Storage:   s,
+	})
+	if err != nil || creds.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", creds, err)
+	}
+
+	rename := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "access_policies/readers/rename",
+		Storage:   s,
+		Data:      map[string]interface{}{"new_name": "viewers"},
+	}
+	resp, err := b.HandleRequest(context.Background(), rename)
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Contains(t, resp.Error().Error(), "revoke them before renaming it")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    creds.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), rename)
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to rename: resp: %#v err: %v", resp, err)
+	}
+	entry, err := b.accessPoliciesRead(context.Background(), s, "viewers")
+	assert.NoError(t, err)
+	assert.NotNil(t, entry)
+}
+
+func TestBackend_access_policy_conditions_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/readers",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"scopes":     "metrics:read",
+			"realms":     "org:1",
+			"conditions": map[string]interface{}{"allowedSubnets": []interface{}{"10.0.0.0/8"}},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write access policy: resp: %#v err: %v", resp, err)
+	}
+	policy := fake.policies[firstKey(fake.policies)]
+	assert.Equal(t, []string{"10.0.0.0/8"}, policy.Conditions.AllowedSubnets)
+
+	for _, testCase := range []struct {
+		conditions map[string]interface{}
+		error      string
+	}{
+		{map[string]interface{}{"allowedIPs": []interface{}{"10.0.0.0/8"}}, "invalid conditions: conditions has unknown key 'allowedIPs', must be allowedSubnets"},
+		{map[string]interface{}{"allowedSubnets": "10.0.0.0/8"}, "invalid conditions: conditions.allowedSubnets must be a list of strings"},
+		{map[string]interface{}{"allowedSubnets": []interface{}{"10.0.0.0"}}, "invalid allowed_subnets entry '10.0.0.0': invalid CIDR address: 10.0.0.0"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "access_policies/readers",
+			Storage:   s,
+			Data:      map[string]interface{}{"conditions": testCase.conditions},
+		})
+		assert.NoError(t, err)
+		if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+			assert.Equal(t, testCase.error, resp.Error().Error())
+		}
+	}
+	assert.Equal(t, []string{"10.0.0.0/8"}, policy.Conditions.AllowedSubnets)
+}
+
+func TestBackend_access_policy_rename_fake(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+		data   map[string]interface{}
+		// deleteRemote deletes the access policy in grafana cloud before
+		// renaming it
+		deleteRemote bool
+		displayName  string
+		error        string
+	}{
+		{"requiresANewName", "readers", map[string]interface{}{}, false, "", "missing new_name"},
+		{"requiresADifferentName", "readers", map[string]interface{}{"new_name": "readers"}, false, "", "new_name must differ from the current name"},
+		{"failsForMissingPolicies", "missing", map[string]interface{}{"new_name": "viewers"}, false, "", "access policy 'missing' does not exist"},
+		{"refusesTakenNames", "readers", map[string]interface{}{"new_name": "writers"}, false, "", "access policy 'writers' already exists"},
+		{"refusesGeneratedPolicies", "generated", map[string]interface{}{"new_name": "viewers"}, false, "", "access policy 'generated' is generated for role 'fleet' and can not be renamed"},
+		{"requiresTheRemotePolicyForDisplayNames", "readers", map[string]interface{}{"new_name": "viewers", "display_name": "Viewers"}, true, "", "does not exist in grafana cloud, sync it before renaming"},
+		{"keepsTheDisplayName", "readers", map[string]interface{}{"new_name": "viewers"}, false, "Readers", ""},
+		{"pushesDisplayNameChanges", "readers", map[string]interface{}{"new_name": "viewers", "display_name": "Viewers"}, false, "Viewers", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", nil)
+			testAccessPolicy(t, b, s, "writers", nil)
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "roles/fleet",
+				Storage:   s,
+				Data:      map[string]interface{}{"access_policy": "readers"},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("failed to write role: resp: %#v err: %v", resp, err)
+			}
+			generated, err := logical.StorageEntryJSON("access_policies/generated", accessPolicyEntry{Role: "fleet"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put(context.Background(), generated); err != nil {
+				t.Fatal(err)
+			}
+			entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if testCase.deleteRemote {
+				delete(fake.policies, entry.Policy.ID)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "access_policies/" + testCase.policy + "/rename",
+				Storage:   s,
+				Data:      testCase.data,
+			})
+			if testCase.error != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+					assert.Contains(t, resp.Error().Error(), testCase.error)
+				}
+				kept, err := b.accessPoliciesRead(context.Background(), s, "readers")
+				assert.NoError(t, err)
+				assert.NotNil(t, kept)
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("failed to rename: resp: %#v err: %v", resp, err)
+			}
+
+			old, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			assert.NoError(t, err)
+			assert.Nil(t, old)
+			renamed, err := b.accessPoliciesRead(context.Background(), s, "viewers")
+			if assert.NoError(t, err) && assert.NotNil(t, renamed) {
+				assert.Equal(t, entry.Policy.ID, renamed.Policy.ID)
+				assert.Equal(t, testCase.displayName, renamed.Policy.DisplayName)
+			}
+			assert.Equal(t, testCase.displayName, fake.policies[entry.Policy.ID].DisplayName)
+
+			// Roles follow the access policy to its new name
+			role, err := b.roleRead(context.Background(), s, "fleet")
+			if assert.NoError(t, err) && assert.NotNil(t, role) {
+				assert.Equal(t, "viewers", role.AccessPolicy)
+			}
+		})
+	}
+}
+
+func TestBackend_access_policy_patch_fake(t *testing.T) {
+	testCases := []struct {
+		name        string
+		path        string
+		data        map[string]interface{}
+		displayName string
+		scopes      []string
+		error       string
+	}{
+		{"failsForMissingPolicies", "access_policies/writers", map[string]interface{}{"display_name": "Writers"}, "", nil, "access policy 'writers' does not exist"},
+		{"rejectsBrokenPatches", "access_policies/readers", map[string]interface{}{"policy": `{"scopes": `}, "", nil, `cannot merge policy patch. raw: "{\"scopes\": ", err: `},
+		{"rejectsInvalidPolicies", "access_policies/readers", map[string]interface{}{"policy": `{"scopes": "metrics:read"}`}, "", nil, "invalid policy: scopes must be a list of strings"},
+		{"requiresAScope", "access_policies/readers", map[string]interface{}{"policy": `{"scopes": null}`}, "", nil, "access policy 'readers' must grant at least one scope, set policy or scopes"},
+		// Fields that are not given keep their stored values
+		{"changesOnlyTheGivenFields", "access_policies/readers", map[string]interface{}{"display_name": "Metric Readers"}, "Metric Readers", []string{"metrics:read"}, ""},
+		{"mergesThePolicy", "access_policies/readers", map[string]interface{}{"policy": `{"scopes": ["metrics:read", "logs:read"]}`}, "Readers", []string{"metrics:read", "logs:read"}, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", nil)
+			entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.PatchOperation,
+				Path:      testCase.path,
+				Storage:   s,
+				Data:      testCase.data,
+			})
+			if testCase.error != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+					assert.Contains(t, resp.Error().Error(), testCase.error)
+				}
+				assert.Equal(t, []string{"metrics:read"}, fake.policies[entry.Policy.ID].Scopes)
+				return
+			}
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("failed to patch access policy: resp: %#v err: %v", resp, err)
+			}
+
+			remote := fake.policies[entry.Policy.ID]
+			assert.Equal(t, testCase.displayName, remote.DisplayName)
+			assert.Equal(t, testCase.scopes, remote.Scopes)
+			if assert.Len(t, remote.Realms, 1) {
+				assert.Equal(t, "1", remote.Realms[0].Identifier)
+			}
+		})
+	}
+}
+
+func TestBackend_access_policy_dry_run_fake(t *testing.T) {
+	testCases := []struct {
+		name        string
+		operation   logical.Operation
+		path        string
+		data        map[string]interface{}
+		dryRun      string
+		displayName string
+		// update is whether the payload is the body of an update of the
+		// existing readers policy
+		update bool
+	}{
+		{"returnsTheCreatePayload", logical.CreateOperation, "access_policies/writers", map[string]interface{}{"policy": `{"displayName": "Writers", "scopes": ["metrics:write"], "realms": [{"type": "org", "identifier": "1"}]}`}, "create", "Writers", false},
+		{"returnsTheUpdatePayload", logical.UpdateOperation, "access_policies/readers", map[string]interface{}{"display_name": "Metric Readers"}, "update", "Metric Readers", true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", nil)
+			entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			testCase.data["dry_run"] = true
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: testCase.operation,
+				Path:      testCase.path,
+				Storage:   s,
+				Data:      testCase.data,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("failed to write access policy: resp: %#v err: %v", resp, err)
+			}
+
+			assert.Equal(t, testCase.dryRun, resp.Data["operation"])
+			payload := resp.Data["payload"].(map[string]interface{})
+			assert.Equal(t, testCase.displayName, payload["displayName"])
+			if testCase.update {
+				assert.Equal(t, entry.Policy.ID, resp.Data["id"])
+				assert.NotContains(t, payload, "name")
+			} else {
+				assert.NotContains(t, resp.Data, "id")
+				assert.Equal(t, "writers", payload["name"])
+			}
+
+			// Nothing is written to grafana cloud or storage
+			assert.Len(t, fake.policies, 1)
+			assert.Equal(t, "Readers", fake.policies[entry.Policy.ID].DisplayName)
+			stored, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, entry, stored)
+			writers, err := b.accessPoliciesRead(context.Background(), s, "writers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Nil(t, writers)
+		})
+	}
+}
+
+func TestBackend_access_policy_sync_fake(t *testing.T) {
+	testCases := []struct {
+		name   string
+		path   string
+		edit   func(fake *fakeClient, id string)
+		action string
+		error  string
+	}{
+		{"failsForMissingPolicies", "access_policies/writers/sync", nil, "", "access policy 'writers' does not exist"},
+		{"failsForBrokenPolicies", "access_policies/broken/sync", nil, "", "failed to sync access policy 'broken'"},
+		{"overwritesRemoteEdits", "access_policies/readers/sync", func(fake *fakeClient, id string) {
+			fake.policies[id].Scopes = []string{"metrics:write"}
+		}, "updated", ""},
+		{"recreatesDeletedPolicies", "access_policies/readers/sync", func(fake *fakeClient, id string) {
+			delete(fake.policies, id)
+		}, "recreated", ""},
+		{"syncsEveryPolicy", "sync/access_policies", func(fake *fakeClient, id string) {
+			fake.policies[id].Scopes = []string{"metrics:write"}
+		}, "updated", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", nil)
+			entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			broken, err := logical.StorageEntryJSON("access_policies/broken", accessPolicyEntry{Config: "missing"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put(context.Background(), broken); err != nil {
+				t.Fatal(err)
+			}
+			if testCase.edit != nil {
+				testCase.edit(fake, entry.Policy.ID)
+			}
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      testCase.path,
+				Storage:   s,
+			})
+			if testCase.error != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+					assert.Contains(t, resp.Error().Error(), testCase.error)
+				}
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("failed to sync: resp: %#v err: %v", resp, err)
+			}
+
+			result := resp.Data
+			if results, ok := resp.Data["access_policies"].(map[string]interface{}); ok {
+				// Failures of single policies are reported without failing
+				// the others
+				if assert.Contains(t, results, "broken") {
+					assert.Contains(t, results["broken"].(map[string]interface{}), "error")
+				}
+				result = results["readers"].(map[string]interface{})
+			}
+			assert.Equal(t, testCase.action, result["action"])
+
+			synced, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, synced.Policy.ID, result["id"])
+			if testCase.action == "recreated" {
+				assert.NotEqual(t, entry.Policy.ID, synced.Policy.ID)
+			} else {
+				assert.Equal(t, entry.Policy.ID, synced.Policy.ID)
+			}
+			assert.Len(t, fake.policies, 1)
+			assert.Equal(t, []string{"metrics:read"}, fake.policies[synced.Policy.ID].Scopes)
+		})
+	}
+}
+
+func TestBackend_access_policy_drift_fake(t *testing.T) {
+	testCases := []struct {
+		name    string
+		edit    func(fake *fakeClient, id string)
+		drifted bool
+		fields  []string
+		warning string
+	}{
+		{"reportsNoDrift", func(fake *fakeClient, id string) {}, false, []string{}, ""},
+		{"ignoresTheOrderOfScopes", func(fake *fakeClient, id string) {
+			fake.policies[id].Scopes = []string{"logs:read", "metrics:read"}
+		}, false, []string{}, ""},
+		{"reportsEditedFields", func(fake *fakeClient, id string) {
+			fake.policies[id].DisplayName = "Edited"
+		}, true, []string{"displayName"}, ""},
+		{"reportsDeletedPolicies", func(fake *fakeClient, id string) {
+			delete(fake.policies, id)
+		}, true, []string{}, "does not exist in grafana cloud"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", map[string]interface{}{
+				"policy": `{"displayName": "Readers", "scopes": ["metrics:read", "logs:read"], "realms": [{"type": "org", "identifier": "1"}]}`,
+			})
+			entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			testCase.edit(fake, entry.Policy.ID)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "access_policies/readers",
+				Storage:   s,
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("failed to read access policy: resp: %#v err: %v", resp, err)
+			}
+			assert.Equal(t, testCase.drifted, resp.Data["drifted"])
+			assert.Equal(t, testCase.fields, resp.Data["drifted_fields"])
+			if testCase.warning != "" {
+				assert.Nil(t, resp.Data["remote"])
+				if assert.Len(t, resp.Warnings, 1) {
+					assert.Contains(t, resp.Warnings[0], testCase.warning)
+				}
+			} else {
+				assert.Empty(t, resp.Warnings)
+				assert.Equal(t, fake.policies[entry.Policy.ID].DisplayName, resp.Data["remote"].(map[string]interface{})["displayName"])
+			}
+		})
+	}
+}
+
+func TestBackend_access_policy_status_fake(t *testing.T) {
+	testCases := []struct {
+		name   string
+		data   map[string]interface{}
+		remote string
+		// credsError is the error of reading creds after the update
+		credsError string
+		error      string
+	}{
+		{"rejectsUnknownStatuses", map[string]interface{}{"status": "disabled"}, "", "", "status must be 'active' or 'inactive', got 'disabled'"},
+		// Inactive policies stop issuing credentials but are left untouched
+		// in grafana cloud unless update_remote is set
+		{"stopsIssuingCredentialsWhenInactive", map[string]interface{}{"status": accessPolicyStatusInactive}, "", "access policy of 'readers' is inactive", ""},
+		{"setsTheRemoteStatusWithUpdateRemote", map[string]interface{}{"status": accessPolicyStatusInactive, "update_remote": true}, accessPolicyStatusInactive, "access policy of 'readers' is inactive", ""},
+		{"issuesCredentialsWhenActive", map[string]interface{}{"status": accessPolicyStatusActive, "update_remote": true}, accessPolicyStatusActive, "", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", nil)
+			entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, accessPolicyStatusActive, entry.Status)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "access_policies/readers",
+				Storage:   s,
+				Data:      testCase.data,
+			})
+			if testCase.error != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+					assert.Equal(t, testCase.error, resp.Error().Error())
+				}
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("failed to update access policy: resp: %#v err: %v", resp, err)
+			}
+			assert.Equal(t, testCase.remote, fake.policies[entry.Policy.ID].Status)
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "creds/readers",
+				Storage:   s,
+			})
+			if testCase.credsError != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+					assert.Equal(t, testCase.credsError, resp.Error().Error())
+				}
+				assert.Len(t, fake.tokens, 0)
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+			}
+			assert.Len(t, fake.tokens, 1)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package grafanacloud
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const featuresConfigKey = "config/features"
+
+func pathConfigFeatures(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/features",
+		Fields: map[string]*framework.FieldSchema{
+			"ephemeral_policies": {
+				Type:        framework.TypeBool,
+				Description: "Enable creating access policies per issued credential",
+			},
+			"periodic_sweeps": {
+				Type:        framework.TypeBool,
+				Description: "Enable background sweeps run by the periodic function",
+			},
+			"webhooks": {
+				Type:        framework.TypeBool,
+				Description: "Enable outbound webhook notifications",
+			},
+			"legacy_api_keys": {
+				Type:        framework.TypeBool,
+				Description: "Enable issuing legacy Grafana Cloud API keys",
+			},
+			"stack_management": {
+				Type:        framework.TypeBool,
+				Description: "Enable creating and deleting stacks through stacks/:slug",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathFeaturesRead,
+			logical.UpdateOperation: b.pathFeaturesUpdate,
+			logical.DeleteOperation: b.pathFeaturesDelete,
+		},
+
+		HelpSynopsis:    pathConfigFeaturesHelpSyn,
+		HelpDescription: pathConfigFeaturesHelpDesc,
+	}
+}
+
+// Sets the feature flags, leaving flags that are not part of the request
+// untouched
+func (b *backend) pathFeaturesUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	features, err := b.FeaturesConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := d.GetOk("ephemeral_policies"); ok {
+		features.EphemeralPolicies = v.(bool)
+	}
+	if v, ok := d.GetOk("periodic_sweeps"); ok {
+		features.PeriodicSweeps = v.(bool)
+	}
+	if v, ok := d.GetOk("webhooks"); ok {
+		features.Webhooks = v.(bool)
+	}
+	if v, ok := d.GetOk("legacy_api_keys"); ok {
+		features.LegacyAPIKeys = v.(bool)
+	}
+	if v, ok := d.GetOk("stack_management"); ok {
+		features.StackManagement = v.(bool)
+	}
+
+	entry, err := logical.StorageEntryJSON(featuresConfigKey, features)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info("updated feature flags", "features", features.toMap())
+
+	return nil, nil
+}
+
+func (b *backend) pathFeaturesDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, featuresConfigKey); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Returns the feature flags
+func (b *backend) pathFeaturesRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	features, err := b.FeaturesConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: features.toMap(),
+	}, nil
+}
+
+// FeaturesConfig returns the feature flags for the mount. All features are
+// disabled when nothing has been configured.
+func (b *backend) FeaturesConfig(ctx context.Context, s logical.Storage) (*configFeatures, error) {
+	entry, err := s.Get(ctx, featuresConfigKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var result configFeatures
+	if entry == nil {
+		return &result, nil
+	}
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Optional subsystems that operators must opt into per mount
+type configFeatures struct {
+	EphemeralPolicies bool `json:"ephemeral_policies"`
+	PeriodicSweeps    bool `json:"periodic_sweeps"`
+	Webhooks          bool `json:"webhooks"`
+	LegacyAPIKeys     bool `json:"legacy_api_keys"`
+	StackManagement   bool `json:"stack_management"`
+}
+
+func (f *configFeatures) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"ephemeral_policies": f.EphemeralPolicies,
+		"periodic_sweeps":    f.PeriodicSweeps,
+		"webhooks":           f.Webhooks,
+		"legacy_api_keys":    f.LegacyAPIKeys,
+		"stack_management":   f.StackManagement,
+	}
+}
+
+var pathConfigFeaturesHelpSyn = "Enable or disable optional subsystems of this backend"
+
+var pathConfigFeaturesHelpDesc = `
+Toggles optional, potentially risky, behaviors of this mount so they can be
+rolled out gradually. Every feature is disabled by default. Only the flags
+included in a write are changed; deleting the path disables all features.
+`
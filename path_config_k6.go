@@ -0,0 +1,153 @@
+package grafanacloud
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const k6ConfigKey = "config/k6"
+
+func pathConfigK6(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/k6",
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: "k6 API token of an organization admin, used to create and delete the tokens issued by k6_token roles",
+			},
+			"organization_id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the k6 organization tokens are issued in",
+			},
+			"api_url": {
+				Type:        framework.TypeString,
+				Description: "Base URL of the k6 API. Defaults to " + gcom.DefaultK6APIURL,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigK6Read,
+			logical.UpdateOperation: b.pathConfigK6Write,
+			logical.DeleteOperation: b.pathConfigK6Delete,
+		},
+
+		HelpSynopsis:    pathConfigK6HelpSyn,
+		HelpDescription: pathConfigK6HelpDesc,
+	}
+}
+
+func (b *backend) pathConfigK6Write(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.readK6Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		conf = &k6Config{}
+	}
+
+	if token, ok := d.GetOk("token"); ok {
+		conf.Token = token.(string)
+	}
+	if orgID, ok := d.GetOk("organization_id"); ok {
+		conf.OrganizationID = orgID.(int)
+	}
+	if apiURL, ok := d.GetOk("api_url"); ok {
+		conf.APIURL = apiURL.(string)
+	}
+
+	if conf.Token == "" {
+		return logical.ErrorResponse("missing token"), nil
+	}
+	if conf.OrganizationID <= 0 {
+		return logical.ErrorResponse("organization_id must be a positive k6 organization id"), nil
+	}
+	if conf.APIURL != "" {
+		if _, err := url.ParseRequestURI(conf.APIURL); err != nil {
+			return logical.ErrorResponse("invalid api_url: %s", err), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(k6ConfigKey, conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathConfigK6Read(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.readK6Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		return nil, nil
+	}
+
+	// The admin token is never returned
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"organization_id": conf.OrganizationID,
+			"api_url":         conf.APIURL,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigK6Delete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, k6ConfigKey); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// readK6Config returns the k6 configuration, or nil when it has not been
+// written
+func (b *backend) readK6Config(ctx context.Context, s logical.Storage) (*k6Config, error) {
+	entry, err := s.Get(ctx, k6ConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var conf k6Config
+	if err := entry.DecodeJSON(&conf); err != nil {
+		return nil, err
+	}
+
+	return &conf, nil
+}
+
+// k6Config is the k6 organization k6_token roles issue tokens in
+type k6Config struct {
+	Token          string `json:"token"`
+	OrganizationID int    `json:"organization_id"`
+	APIURL         string `json:"api_url"`
+}
+
+// apiURL returns the url of the k6 api
+func (c *k6Config) apiURL() string {
+	if c.APIURL == "" {
+		return gcom.DefaultK6APIURL
+	}
+
+	return c.APIURL
+}
+
+const pathConfigK6HelpSyn = `Configure the k6 organization k6_token roles issue tokens in`
+
+const pathConfigK6HelpDesc = `
+k6 API tokens are managed with a token of an admin of the k6 organization
+rather than the Grafana Cloud token in config/token. The admin token is only
+used to create and delete the tokens issued from creds/k6/<role name> and is
+never returned when reading this path.
+`
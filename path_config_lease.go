@@ -2,6 +2,7 @@ package grafanacloud
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
@@ -22,6 +23,10 @@ func pathConfigLease(b *backend) *framework.Path {
 				Type:        framework.TypeDurationSecond,
 				Description: `Duration after which the issued token should not be allowed to be renewed`,
 			},
+			"min_ttl": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Description: "Shortest duration tokens may be requested for, so short lived tokens do not flood the Grafana Cloud API with creations and deletions",
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -35,12 +40,46 @@ func pathConfigLease(b *backend) *framework.Path {
 	}
 }
 
-// Sets the lease configuration parameters
+// Sets the lease configuration parameters. Parameters that are not given
+// keep their stored value.
 func (b *backend) pathLeaseUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	entry, err := logical.StorageEntryJSON("config/lease", &configLease{
-		TTL:    time.Second * time.Duration(d.Get("ttl").(int)),
-		MaxTTL: time.Second * time.Duration(d.Get("max_ttl").(int)),
-	})
+	lease, err := b.LeaseConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		lease = &configLease{}
+	}
+
+	if ttl, ok := d.GetOk("ttl"); ok {
+		lease.TTL = time.Second * time.Duration(ttl.(int))
+	}
+	if maxTTL, ok := d.GetOk("max_ttl"); ok {
+		lease.MaxTTL = time.Second * time.Duration(maxTTL.(int))
+	}
+	if minTTL, ok := d.GetOk("min_ttl"); ok {
+		lease.MinTTL = time.Second * time.Duration(minTTL.(int))
+	}
+	if lease.TTL < 0 {
+		return logical.ErrorResponse("ttl must not be negative"), nil
+	}
+	if lease.MaxTTL < 0 {
+		return logical.ErrorResponse("max_ttl must not be negative"), nil
+	}
+	if lease.MinTTL < 0 {
+		return logical.ErrorResponse("min_ttl must not be negative"), nil
+	}
+	if lease.MaxTTL > 0 && lease.TTL > lease.MaxTTL {
+		return logical.ErrorResponse("ttl of %s cannot be greater than max_ttl of %s", lease.TTL, lease.MaxTTL), nil
+	}
+	if lease.TTL > 0 && lease.MinTTL > lease.TTL {
+		return logical.ErrorResponse("min_ttl of %s cannot be greater than ttl of %s", lease.MinTTL, lease.TTL), nil
+	}
+	if lease.MaxTTL > 0 && lease.MinTTL > lease.MaxTTL {
+		return logical.ErrorResponse("min_ttl of %s cannot be greater than max_ttl of %s", lease.MinTTL, lease.MaxTTL), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(leaseConfigKey, lease)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +87,13 @@ func (b *backend) pathLeaseUpdate(ctx context.Context, req *logical.Request, d *
 		return nil, err
 	}
 
-	return nil, nil
+	var resp *logical.Response
+	if systemMaxTTL := b.System().MaxLeaseTTL(); lease.MaxTTL > systemMaxTTL {
+		resp = &logical.Response{}
+		resp.AddWarning(fmt.Sprintf("max_ttl of %s is greater than the max lease ttl of the mount, leases are capped at %s", lease.MaxTTL, systemMaxTTL))
+	}
+
+	return resp, nil
 }
 
 func (b *backend) pathLeaseDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -59,24 +104,70 @@ func (b *backend) pathLeaseDelete(ctx context.Context, req *logical.Request, d *
 	return nil, nil
 }
 
-// Returns the lease configuration parameters
+// Returns the lease configuration parameters, along with the ttls leases get
+// once the defaults of the mount are applied
 func (b *backend) pathLeaseRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	lease, err := b.LeaseConfig(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
 	if lease == nil {
-		return nil, nil
+		lease = &configLease{}
 	}
 
+	effectiveTTL, effectiveMaxTTL := b.effectiveLease(lease)
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"ttl":     int64(lease.TTL.Seconds()),
-			"max_ttl": int64(lease.MaxTTL.Seconds()),
+			"ttl":               int64(lease.TTL.Seconds()),
+			"max_ttl":           int64(lease.MaxTTL.Seconds()),
+			"min_ttl":           int64(lease.MinTTL.Seconds()),
+			"effective_ttl":     int64(effectiveTTL.Seconds()),
+			"effective_max_ttl": int64(effectiveMaxTTL.Seconds()),
 		},
 	}, nil
 }
 
+// effectiveLease returns the ttl and max ttl of leases issued with lease,
+// falling back to the defaults of the mount and capped at its max lease ttl
+func (b *backend) effectiveLease(lease *configLease) (time.Duration, time.Duration) {
+	ttl, maxTTL := b.System().DefaultLeaseTTL(), b.System().MaxLeaseTTL()
+	if lease.MaxTTL > 0 && lease.MaxTTL < maxTTL {
+		maxTTL = lease.MaxTTL
+	}
+	if lease.TTL > 0 {
+		ttl = lease.TTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	return ttl, maxTTL
+}
+
+// minLeaseTTL returns the shortest ttl credentials of the role may be issued
+// for, the greater of the min_ttl of the lease configuration and of the role
+func minLeaseTTL(lease *configLease, role *roleEntry) time.Duration {
+	if role.MinTTL > lease.MinTTL {
+		return role.MinTTL
+	}
+
+	return lease.MinTTL
+}
+
+// enforceMinTTL rejects a requested ttl below minTTL, and raises a ttl
+// shortened below it, e.g. by jitter, back to it
+func enforceMinTTL(requestedTTL time.Duration, ttl time.Duration, minTTL time.Duration) (time.Duration, error) {
+	if requestedTTL > 0 && requestedTTL < minTTL {
+		return 0, fmt.Errorf("ttl of %s is shorter than the min_ttl of %s", requestedTTL, minTTL)
+	}
+	if ttl < minTTL {
+		return minTTL, nil
+	}
+
+	return ttl, nil
+}
+
 // Lease returns the lease information
 func (b *backend) LeaseConfig(ctx context.Context, s logical.Storage) (*configLease, error) {
 	entry, err := s.Get(ctx, leaseConfigKey)
@@ -99,6 +190,7 @@ func (b *backend) LeaseConfig(ctx context.Context, s logical.Storage) (*configLe
 type configLease struct {
 	TTL    time.Duration `json:"ttl" mapstructure:"ttl"`
 	MaxTTL time.Duration `json:"max_ttl" mapstructure:"max_ttl"`
+	MinTTL time.Duration `json:"min_ttl" mapstructure:"min_ttl"`
 }
 
 var pathConfigLeaseHelpSyn = "Configure the lease parameters for generated tokens"
@@ -106,5 +198,10 @@ var pathConfigLeaseHelpSyn = "Configure the lease parameters for generated token
 var pathConfigLeaseHelpDesc = `
 Sets the ttl and max_ttl values for the secrets to be issued by this backend.
 Both ttl and max_ttl takes in an integer number of seconds as input as well as
-inputs like "1h".
+inputs like "1h". ttl can not be greater than max_ttl. Requests for tokens with
+a ttl shorter than min_ttl are rejected.
+
+Reads also return effective_ttl and effective_max_ttl, the values leases get
+once the defaults of the mount are applied. Deleting the configuration resets
+both to those defaults.
 `
@@ -0,0 +1,100 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_config_lease_fake(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/lease",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"ttl":     "2h",
+			"max_ttl": "1h",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected ttl greater than max_ttl to be rejected: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/lease",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"ttl": "30m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write lease config: resp: %#v err: %v", resp, err)
+	}
+
+	readLease := func() map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config/lease",
+			Storage:   s,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("failed to read lease config: resp: %#v err: %v", resp, err)
+		}
+		return resp.Data
+	}
+	lease := readLease()
+	assert.Equal(t, int64(1800), lease["ttl"])
+	assert.Equal(t, int64(1800), lease["effective_ttl"])
+	assert.Equal(t, int64(b.System().MaxLeaseTTL().Seconds()), lease["effective_max_ttl"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/lease",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"min_ttl": "10m",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write lease config: resp: %#v err: %v", resp, err)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/readers",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"scopes": "metrics:read",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create access policy: resp: %#v err: %v", resp, err)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/readers",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"ttl": "1m",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected ttl below min_ttl to be rejected: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/lease",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete lease config: resp: %#v err: %v", resp, err)
+	}
+	lease = readLease()
+	assert.Equal(t, int64(0), lease["ttl"])
+	assert.Equal(t, int64(b.System().DefaultLeaseTTL().Seconds()), lease["effective_ttl"])
+}
@@ -3,15 +3,39 @@ package grafanacloud
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+// defaultRootTokenTTL is the lifetime of rotated tokens when neither the
+// request nor the configuration sets one
+const defaultRootTokenTTL = time.Hour * 24 * 90
+
 func pathConfigRotateRoot(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "config/rotate-root",
+		Fields: map[string]*framework.FieldSchema{
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ to rotate. Rotates config/token when empty",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the new token. Defaults to root_token_ttl of the configuration, or 90 days",
+			},
+			"token": {
+				Type:        framework.TypeString,
+				Description: "Existing token to replace the configured token with instead of creating one",
+			},
+			"scoped": {
+				Type:        framework.TypeBool,
+				Description: "Create the new token under a new access policy limited to the scopes this backend needs instead of the current access policy",
+			},
+		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: b.pathConfigRotateRootUpdate,
@@ -26,7 +50,8 @@ func pathConfigRotateRoot(b *backend) *framework.Path {
 func (b *backend) pathConfigRotateRootUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	b.Logger().Debug("rotating root token")
 	// have to get the client config first because that takes out a read lock
-	client, err := b.client(ctx, req.Storage)
+	configName := data.Get("config").(string)
+	client, err := b.configClient(ctx, req.Storage, configName)
 	if err != nil {
 		return nil, err
 	}
@@ -34,12 +59,15 @@ func (b *backend) pathConfigRotateRootUpdate(ctx context.Context, req *logical.R
 		return nil, fmt.Errorf("nil client")
 	}
 
-	currentToken, err := req.Storage.Get(ctx, configTokenKey)
+	b.rotateLock.Lock()
+	defer b.rotateLock.Unlock()
+
+	currentToken, err := req.Storage.Get(ctx, configTokenStorageKey(configName))
 	if err != nil {
 		return nil, err
 	}
 	if currentToken == nil {
-		return nil, fmt.Errorf("no configuration found for config/token")
+		return nil, fmt.Errorf("no configuration found for %s", configTokenStorageKey(configName))
 	}
 	var currentConfig accessTokenConfig
 	if err := currentToken.DecodeJSON(&currentConfig); err != nil {
@@ -50,44 +78,396 @@ func (b *backend) pathConfigRotateRootUpdate(ctx context.Context, req *logical.R
 		return logical.ErrorResponse("Cannot call config/rotate-root when either accessPolicyID or token is empty"), nil
 	}
 
+	ttl := defaultRootTokenTTL
+	if currentConfig.RootTokenTTL > 0 {
+		ttl = currentConfig.RootTokenTTL
+	}
+	if ttlRaw, ok := data.GetOk("ttl"); ok {
+		ttl = time.Duration(ttlRaw.(int)) * time.Second
+		if ttl <= 0 {
+			return logical.ErrorResponse("ttl must be positive"), nil
+		}
+	}
+
+	token, hasToken := data.GetOk("token")
+	scoped := data.Get("scoped").(bool)
+	if hasToken && scoped {
+		return logical.ErrorResponse("token and scoped are mutually exclusive"), nil
+	}
+
+	var newConfig *accessTokenConfig
+	switch {
+	case hasToken:
+		newConfig, err = b.swapRootToken(ctx, req.Storage, client, configName, currentConfig, token.(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	case scoped:
+		scopes, err := b.rootTokenScopes(ctx, req.Storage, configName)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := createRootAccessPolicy(ctx, client, currentConfig.AccessPolicyID, scopes)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to create scoped access policy: %s", err)), nil
+		}
+
+		scopedConfig := currentConfig
+		scopedConfig.AccessPolicyID = policy.ID
+		scopedConfig.ScopedAccessPolicy = true
+		newConfig, err = b.rotateRootToken(ctx, req.Storage, client, configName, scopedConfig, ttl)
+		if err != nil {
+			b.deleteUnusedRootAccessPolicy(ctx, req.Storage, client, configName, policy.ID)
+			return nil, err
+		}
+	default:
+		newConfig, err = b.rotateRootToken(ctx, req.Storage, client, configName, currentConfig, ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":            newConfig.TokenID,
+			"accesPolicyID": newConfig.AccessPolicyID,
+		},
+	}, nil
+}
+
+// rotateRootToken replaces the token of the named configuration with a new
+// token of the same access policy that is valid for ttl. The old token is
+// deleted, or retired when the configuration has a grace period. The caller
+// must hold rotateLock.
+func (b *backend) rotateRootToken(ctx context.Context, s logical.Storage, client GrafanaClient, configName string, currentConfig accessTokenConfig, ttl time.Duration) (_ *accessTokenConfig, err error) {
+	defer func() {
+		recordRootRotation(configName, err)
+	}()
+
 	name := fmt.Sprintf("vault-mount-config-%d", time.Now().UnixNano())
-	createTokenRequest := CreateTokenRequest{
+
+	// Rolled back if vault stops before the new token is stored or deleted
+	walID, err := framework.PutWAL(ctx, s, walRootTokenKind, &walRootToken{
+		Config:    configName,
+		TokenName: name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	createTokenRequest := gcom.CreateTokenRequest{
 		AccessPolicyID: currentConfig.AccessPolicyID,
 		Name:           name,
 		DisplayName:    "grafana cloud vault mount",
-		ExpiresAt:      time.Now().UTC().Add(time.Hour * 24 * 90),
+		ExpiresAt:      time.Now().UTC().Add(ttl),
 	}
-	newToken, err := client.CreateToken(createTokenRequest)
+	newToken, err := client.CreateToken(ctx, createTokenRequest)
 	if err != nil {
 		return nil, err
 	}
-	b.Logger().Info("token", "newToken", newToken)
 
-	newConfig := accessTokenConfig{
-		TokenID:        newToken.ID,
-		Token:          newToken.Token,
-		AccessPolicyID: newToken.AccessPolicyID,
+	newConfig := currentConfig
+	newConfig.TokenID = newToken.ID
+	newConfig.TokenName = newToken.Name
+	newConfig.ExpiresAt = newToken.ExpiresAt
+	newConfig.Token = newToken.Token
+	newConfig.AccessPolicyID = newToken.AccessPolicyID
+	newConfig.LastRotatedAt = time.Now().UTC()
+
+	// Make sure the new token works before the old one is replaced, otherwise
+	// the mount would be left without a usable token
+	if err := b.verifyRootToken(ctx, configName, newConfig); err != nil {
+		if deleteErr := client.DeleteToken(ctx, newToken.ID); deleteErr != nil {
+			b.Logger().Error("failed to delete unverified root token", "id", newToken.ID, "error", deleteErr)
+		} else if walErr := framework.DeleteWAL(ctx, s, walID); walErr != nil {
+			b.Logger().Error("failed to delete WAL entry", "id", walID, "error", walErr)
+		}
+		return nil, fmt.Errorf("new root token failed verification: %w", err)
+	}
+
+	if err := b.replaceRootToken(ctx, s, client, configName, currentConfig, newConfig); err != nil {
+		return nil, err
+	}
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		return nil, fmt.Errorf("error deleting WAL entry: %w", err)
 	}
 
-	newEntry, err := logical.StorageEntryJSON(configTokenKey, newConfig)
+	return &newConfig, nil
+}
+
+// swapRootToken replaces the token of the named configuration with a token
+// minted outside of vault, after checking that it authenticates. The caller
+// must hold rotateLock.
+func (b *backend) swapRootToken(ctx context.Context, s logical.Storage, client GrafanaClient, configName string, currentConfig accessTokenConfig, token string) (*accessTokenConfig, error) {
+	newConfig := currentConfig
+	newConfig.Token = token
+
+	newClient, err := b.newClient(configName, &newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	decodedToken, err := gcom.DecodeToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
+	}
+	newToken, err := newClient.GetTokenByName(ctx, decodedToken.TokenName)
 	if err != nil {
-		return nil, fmt.Errorf("error generating new config/root JSON: %w", err)
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+	if newToken.ID == currentConfig.TokenID {
+		return nil, fmt.Errorf("token '%s' is already configured", newToken.Name)
 	}
-	if err := req.Storage.Put(ctx, newEntry); err != nil {
-		return nil, fmt.Errorf("error saving new config/root: %w", err)
+
+	newConfig.TokenID = newToken.ID
+	newConfig.TokenName = newToken.Name
+	newConfig.ExpiresAt = newToken.ExpiresAt
+	newConfig.AccessPolicyID = newToken.AccessPolicyID
+	newConfig.ScopedAccessPolicy = currentConfig.ScopedAccessPolicy && newToken.AccessPolicyID == currentConfig.AccessPolicyID
+	newConfig.LastRotatedAt = time.Now().UTC()
+
+	if err := b.replaceRootToken(ctx, s, client, configName, currentConfig, newConfig); err != nil {
+		return nil, err
 	}
 
-	err = client.DeleteToken(currentConfig.TokenID)
+	return &newConfig, nil
+}
+
+// replaceRootToken stores newConfig as the named configuration and deletes,
+// or retires, the token of currentConfig. The access policy of currentConfig
+// goes along with it when it was created by a scoped rotation and is no longer
+// used.
+func (b *backend) replaceRootToken(ctx context.Context, s logical.Storage, client GrafanaClient, configName string, currentConfig accessTokenConfig, newConfig accessTokenConfig) error {
+	newEntry, err := logical.StorageEntryJSON(configTokenStorageKey(configName), newConfig)
 	if err != nil {
-		return nil, fmt.Errorf("error deleting old access key: %w", err)
+		return fmt.Errorf("error generating new config/root JSON: %w", err)
+	}
+	if err := s.Put(ctx, newEntry); err != nil {
+		return fmt.Errorf("error saving new config/root: %w", err)
 	}
+	b.invalidateClient(configName)
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"id":            newConfig.TokenID,
-			"accesPolicyID": newConfig.AccessPolicyID,
-		},
-	}, nil
+	var unusedPolicyID string
+	if currentConfig.ScopedAccessPolicy && currentConfig.AccessPolicyID != newConfig.AccessPolicyID {
+		unusedPolicyID = currentConfig.AccessPolicyID
+	}
+
+	if currentConfig.RootTokenGracePeriod > 0 {
+		if err := b.retireRootToken(ctx, s, configName, currentConfig.TokenID, unusedPolicyID, currentConfig.RootTokenGracePeriod); err != nil {
+			return fmt.Errorf("error retiring old access key: %w", err)
+		}
+		return nil
+	}
+
+	err = client.DeleteToken(ctx, currentConfig.TokenID)
+	if err != nil {
+		return fmt.Errorf("error deleting old access key: %w", err)
+	}
+	if unusedPolicyID != "" {
+		if _, err := client.DeleteAccessPolicy(ctx, unusedPolicyID); err != nil {
+			return fmt.Errorf("error deleting old scoped access policy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteUnusedRootAccessPolicy deletes the access policy created for a scoped
+// rotation that failed, unless the rotation got as far as storing a token of
+// it as the root token
+func (b *backend) deleteUnusedRootAccessPolicy(ctx context.Context, s logical.Storage, client GrafanaClient, configName string, policyID string) {
+	conf, err := b.readConfigToken(ctx, s, configName)
+	if err != nil {
+		b.Logger().Error("failed to read configuration after rotation failed", "config", configTokenStorageKey(configName), "error", err)
+		return
+	}
+	if conf != nil && conf.AccessPolicyID == policyID {
+		return
+	}
+
+	if _, err := client.DeleteAccessPolicy(ctx, policyID); err != nil {
+		b.Logger().Error("failed to delete scoped access policy after rotation failed", "id", policyID, "error", err)
+	}
+}
+
+// baseRootTokenScopes are the scopes the backend always needs, to manage
+// access policies and tokens and to resolve stacks
+var baseRootTokenScopes = []string{
+	"accesspolicies:read",
+	"accesspolicies:write",
+	"accesspolicies:delete",
+	"tokens:read",
+	"tokens:write",
+	"tokens:delete",
+	"stacks:read",
+}
+
+// credentialTypeRootTokenScopes are the scopes the backend needs to issue
+// each credential type, on top of baseRootTokenScopes. k6 tokens are issued
+// with the token in config/k6.
+var credentialTypeRootTokenScopes = map[string][]string{
+	credentialTypeStackAPIKey: {
+		"stack-api-keys:read",
+		"stack-api-keys:write",
+		"stack-api-keys:delete",
+	},
+	credentialTypeStackServiceAccount: {
+		"stack-service-accounts:write",
+	},
+	credentialTypeOrgAPIKey: {
+		"api-keys:read",
+		"api-keys:write",
+		"api-keys:delete",
+	},
+	credentialTypeSyntheticMonitoring: {
+		"metrics:write",
+		"logs:write",
+		"traces:write",
+	},
+}
+
+// rootTokenScopes returns the scopes the token of the named configuration
+// needs for the credential types of the roles and static roles using it and
+// for the enabled features
+func (b *backend) rootTokenScopes(ctx context.Context, s logical.Storage, configName string) ([]string, error) {
+	scopes := slices.Clone(baseRootTokenScopes)
+
+	features, err := b.FeaturesConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if features.StackManagement {
+		scopes = append(scopes, "stacks:write", "stacks:delete")
+	}
+
+	roles, err := s.List(ctx, rolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range roles {
+		role, err := b.roleRead(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil || role.Config != configName {
+			continue
+		}
+		scopes = append(scopes, credentialTypeRootTokenScopes[role.CredentialType]...)
+	}
+
+	staticRoles, err := s.List(ctx, staticRolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range staticRoles {
+		role, err := b.staticRoleRead(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		roleConfig, err := b.staticRoleConfig(ctx, s, role)
+		if err != nil {
+			return nil, err
+		}
+		if roleConfig != configName {
+			continue
+		}
+		scopes = append(scopes, credentialTypeRootTokenScopes[role.CredentialType]...)
+	}
+
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// createRootAccessPolicy creates an access policy in the realms of the given
+// access policy that only grants scopes
+func createRootAccessPolicy(ctx context.Context, client GrafanaClient, currentPolicyID string, scopes []string) (*gcom.AccessPolicy, error) {
+	current, err := client.GetAccessPolicy(ctx, currentPolicyID)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("access policy '%s' does not exist", currentPolicyID)
+	}
+
+	name := fmt.Sprintf("vault-mount-config-%d", time.Now().UnixNano())
+	return client.CreateAccessPolicy(ctx, map[string]interface{}{
+		"name":        name,
+		"displayName": "grafana cloud vault mount",
+		"scopes":      scopes,
+		"realms":      current.Realms,
+	})
+}
+
+// verifyRootToken checks that the token of the configuration authenticates
+// by reading itself
+func (b *backend) verifyRootToken(ctx context.Context, configName string, conf accessTokenConfig) error {
+	client, err := b.newClient(configName, &conf)
+	if err != nil {
+		return err
+	}
+
+	token, err := client.GetToken(ctx, conf.TokenID)
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return fmt.Errorf("token '%s' does not exist", conf.TokenID)
+	}
+
+	return nil
+}
+
+// rotateRootTokens rotates the token of every configuration whose
+// rotation_period has passed since it was last rotated
+func (b *backend) rotateRootTokens(ctx context.Context, s logical.Storage) error {
+	names, err := s.List(ctx, configTokensPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range append([]string{""}, names...) {
+		if err := b.rotateRootTokenIfDue(ctx, s, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotateRootTokenIfDue rotates the token of the named configuration if its
+// rotation_period has passed. The configuration is read under rotateLock so a
+// manual rotation in between is not undone.
+func (b *backend) rotateRootTokenIfDue(ctx context.Context, s logical.Storage, name string) error {
+	b.rotateLock.Lock()
+	defer b.rotateLock.Unlock()
+
+	conf, err := b.readConfigToken(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	if conf == nil || conf.RotationPeriod <= 0 || time.Since(conf.LastRotatedAt) < conf.RotationPeriod {
+		return nil
+	}
+
+	client, err := conf.client()
+	if err != nil {
+		b.Logger().Error("failed to rotate root token", "config", configTokenStorageKey(name), "error", err)
+		return nil
+	}
+
+	ttl := defaultRootTokenTTL
+	if conf.RootTokenTTL > 0 {
+		ttl = conf.RootTokenTTL
+	}
+	if _, err := b.rotateRootToken(ctx, s, client, name, *conf, ttl); err != nil {
+		b.Logger().Error("failed to rotate root token", "config", configTokenStorageKey(name), "error", err)
+		return nil
+	}
+	b.Logger().Info("rotated root token", "config", configTokenStorageKey(name))
+
+	return nil
 }
 
 const pathConfigRotateRootHelpSyn = `
@@ -98,4 +478,17 @@ const pathConfigRotateRootHelpDesc = `
 This path attempts to rotate the Grafana Cloud credentials used by Vault for this mount.
 It is only valid if Vault has been configured to use Admin Grafana CLoud token via the
 config/token endpoint.
+
+When 'token' is set, the given token, minted outside of Vault, is verified and
+stored in place of creating a new one. The previous token is handled the same
+way in both cases.
+
+With 'scoped=true' the new token is created under a new access policy in the
+realms of the current access policy. It only grants the scopes Vault needs to
+manage access policies and tokens, resolve stacks, and issue the credential
+types of the roles and static roles using the configuration, along with the
+stack management scopes when that feature is enabled. Later rotations keep
+using that policy. Rotate with 'scoped=true' again after adding roles of other
+credential types. A policy created by an earlier scoped rotation is deleted
+along with the token it replaces.
 `
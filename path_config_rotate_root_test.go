@@ -0,0 +1,162 @@
+package grafanacloud
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTokenSecret returns a token secret that decodes to name in the test
+// organization
+func fakeTokenSecret(name string) string {
+	decoded, _ := json.Marshal(gcom.GrafanaToken{
+		Organization: "test-org",
+		TokenName:    name,
+		Metadata:     gcom.Metadata{Region: "prod-test-0"},
+	})
+
+	return "glc_" + base64.StdEncoding.EncodeToString(decoded)
+}
+
+// unverifiedClient is a client of a token that does not authenticate
+type unverifiedClient struct {
+	*fakeClient
+}
+
+func (c unverifiedClient) GetToken(ctx context.Context, id string) (*gcom.TokenResponse, error) {
+	return nil, nil
+}
+
+// testRootToken makes the token of config/token a token of the fake grafana
+// cloud API and returns it
+func testRootToken(t *testing.T, b *backend, s logical.Storage, fake *fakeClient) *gcom.TokenResponse {
+	t.Helper()
+
+	policy := &gcom.AccessPolicy{ID: fake.id(), Name: "vault-mount"}
+	if err := applyPolicyBody(policy, map[string]interface{}{
+		"scopes": []string{"accesspolicies:read", "accesspolicies:write", "tokens:read", "tokens:write"},
+		"realms": []map[string]interface{}{{"type": "org", "identifier": "1"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fake.policies[policy.ID] = policy
+	token := &gcom.TokenResponse{
+		ID:             fake.id(),
+		AccessPolicyID: policy.ID,
+		Name:           "vault-test",
+		Token:          fakeTokenSecret("vault-test"),
+	}
+	fake.tokens[token.ID] = token
+
+	conf, err := b.readConfigToken(context.Background(), s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Token = token.Token
+	conf.TokenID = token.ID
+	conf.TokenName = token.Name
+	conf.AccessPolicyID = policy.ID
+	entry, err := logical.StorageEntryJSON(configTokenKey, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestBackend_rotate_root_fake(t *testing.T) {
+	testCases := []struct {
+		name string
+		data map[string]interface{}
+		// mint is the name of a token to create in the access policy of
+		// the root token before rotating
+		mint string
+		// unverified makes every token but the root token fail to
+		// authenticate
+		unverified bool
+		error      string
+	}{
+		{"replacesTheTokenWithAVerifiedOne", map[string]interface{}{}, "", false, ""},
+		{"keepsTheTokenWhenTheNewOneFailsVerification", map[string]interface{}{}, "", true, "new root token failed verification"},
+		{"swapsInAnExternalToken", map[string]interface{}{"token": fakeTokenSecret("external")}, "external", false, ""},
+		{"rejectsTheConfiguredToken", map[string]interface{}{"token": fakeTokenSecret("vault-test")}, "", false, "token 'vault-test' is already configured"},
+		{"createsAScopedAccessPolicy", map[string]interface{}{"scoped": true}, "", false, ""},
+		{"refusesTokenAndScoped", map[string]interface{}{"token": fakeTokenSecret("external"), "scoped": true}, "external", false, "token and scoped are mutually exclusive"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			root := testRootToken(t, b, s, fake)
+			if testCase.mint != "" {
+				minted := &gcom.TokenResponse{
+					ID:             fake.id(),
+					AccessPolicyID: root.AccessPolicyID,
+					Name:           testCase.mint,
+					Token:          fakeTokenSecret(testCase.mint),
+				}
+				fake.tokens[minted.ID] = minted
+			}
+			if testCase.unverified {
+				b.newClient = func(name string, conf *accessTokenConfig) (GrafanaClient, error) {
+					if conf.TokenID != root.ID {
+						return unverifiedClient{fake}, nil
+					}
+					return fake, nil
+				}
+			}
+			tokens := len(fake.tokens)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "config/rotate-root",
+				Storage:   s,
+				Data:      testCase.data,
+			})
+			if err == nil && resp != nil && resp.IsError() {
+				err = resp.Error()
+			}
+			conf, confErr := b.readConfigToken(context.Background(), s, "")
+			if confErr != nil {
+				t.Fatal(confErr)
+			}
+
+			if testCase.error != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), testCase.error)
+				}
+				assert.Equal(t, root.ID, conf.TokenID)
+				assert.Equal(t, root.Token, conf.Token)
+				assert.Len(t, fake.tokens, tokens)
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to rotate root token: %v", err)
+			}
+			assert.Equal(t, conf.TokenID, resp.Data["id"])
+			assert.NotEqual(t, root.ID, conf.TokenID)
+			assert.NotEqual(t, root.Token, conf.Token)
+			assert.NotContains(t, fake.tokens, root.ID)
+			assert.Contains(t, fake.tokens, conf.TokenID)
+			if testCase.mint != "" {
+				assert.Equal(t, testCase.mint, conf.TokenName)
+			}
+			if testCase.data["scoped"] == true {
+				assert.NotEqual(t, root.AccessPolicyID, conf.AccessPolicyID)
+				if assert.Contains(t, fake.policies, conf.AccessPolicyID) {
+					assert.ElementsMatch(t, baseRootTokenScopes, fake.policies[conf.AccessPolicyID].Scopes)
+				}
+			} else {
+				assert.Equal(t, root.AccessPolicyID, conf.AccessPolicyID)
+			}
+		})
+	}
+}
@@ -3,24 +3,154 @@ package grafanacloud
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const configTokenKey = "config/token"
 
+// configTokensPrefix is where named configurations are stored in addition to
+// the default configuration at configTokenKey
+const configTokensPrefix = "config/tokens/"
+
+// configTokenStorageKey returns the storage key of the named configuration.
+// The empty name refers to the default configuration.
+func configTokenStorageKey(name string) string {
+	if name == "" {
+		return configTokenKey
+	}
+
+	return configTokensPrefix + name
+}
+
+// configTokenName returns the name of the configuration addressed by the
+// request, which is empty for config/token
+func configTokenName(d *framework.FieldData) string {
+	if _, ok := d.Schema["name"]; !ok {
+		return ""
+	}
+
+	return d.Get("name").(string)
+}
+
+func configTokenFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"token": {
+			Type:        framework.TypeString,
+			Description: "Token for API calls. Required when creating the configuration. A token that does not embed its region and organization requires region and org_slug, and can not be rotated",
+		},
+		"api_url": {
+			Type:        framework.TypeString,
+			Description: "Base URL of the Grafana Cloud API. Defaults to the host of the region in region_hosts, or " + gcom.DefaultAPIURL,
+		},
+		"region_hosts": {
+			Type:        framework.TypeKVPairs,
+			Description: "API host per region, as a host name or a full url. Regions without a host use " + gcom.DefaultAPIURL,
+		},
+		"region": {
+			Type:        framework.TypeString,
+			Description: "Region sent with API requests. Overrides the region decoded from the token",
+		},
+		"org_slug": {
+			Type:        framework.TypeString,
+			Description: "Slug of the organization. Overrides the organization decoded from the token",
+		},
+		"proxy_url": {
+			Type:        framework.TypeString,
+			Description: "URL of the HTTP(S) proxy API requests are sent through. Uses the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables when empty",
+		},
+		"ca_cert": {
+			Type:        framework.TypeString,
+			Description: "PEM encoded CA bundle used to verify the Grafana Cloud API. Uses the system roots when empty",
+		},
+		"client_cert": {
+			Type:        framework.TypeString,
+			Description: "PEM encoded client certificate presented to the Grafana Cloud API. Requires client_key",
+		},
+		"client_key": {
+			Type:        framework.TypeString,
+			Description: "PEM encoded private key of client_cert",
+		},
+		"tls_skip_verify": {
+			Type:        framework.TypeBool,
+			Description: "Skip verifying the certificate of the Grafana Cloud API. Not recommended",
+		},
+		"api_rate_limit": {
+			Type:        framework.TypeInt,
+			Description: "Maximum number of Grafana Cloud API requests per second made with this configuration on each node. Unlimited when 0",
+		},
+		"root_token_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Lifetime of tokens created by config/rotate-root. Defaults to 90 days",
+		},
+		"root_token_grace_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How long the previous token stays valid after a rotation. Deleted immediately when 0",
+		},
+		"rotation_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Rotate the token automatically once this long has passed since it was configured or last rotated. Must be shorter than root_token_ttl. Disabled when 0",
+		},
+		"revoke": {
+			Type:        framework.TypeBool,
+			Description: "When deleting the configuration, also delete the token in Grafana Cloud",
+		},
+		"disable_token_read": {
+			Type:        framework.TypeBool,
+			Description: "Omit the token when reading the configuration. Defaults to true",
+			Default:     true,
+		},
+	}
+}
+
 func pathConfigToken(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "config/token",
-		Fields: map[string]*framework.FieldSchema{
-			"token": {
-				Type:        framework.TypeString,
-				Description: "Token for API calls",
-			},
+		Fields:  configTokenFields(),
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigTokenRead,
+			logical.CreateOperation: b.pathConfigTokenWrite,
+			logical.UpdateOperation: b.pathConfigTokenWrite,
+			logical.DeleteOperation: b.pathConfigTokenDelete,
 		},
 
+		ExistenceCheck: b.configTokenExistenceCheck,
+
+		HelpSynopsis:    pathConfigTokenHelpSyn,
+		HelpDescription: pathConfigTokenHelpDesc,
+	}
+}
+
+func pathListConfigTokens(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/tokens/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathConfigTokensList,
+		},
+
+		HelpSynopsis:    pathListConfigTokensHelpSyn,
+		HelpDescription: pathListConfigTokensHelpDesc,
+	}
+}
+
+func pathConfigTokens(b *backend) *framework.Path {
+	fields := configTokenFields()
+	fields["name"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Name of the configuration",
+	}
+
+	return &framework.Path{
+		Pattern: "config/tokens/" + framework.GenericNameRegex("name"),
+		Fields:  fields,
+
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ReadOperation:   b.pathConfigTokenRead,
 			logical.CreateOperation: b.pathConfigTokenWrite,
@@ -29,11 +159,23 @@ func pathConfigToken(b *backend) *framework.Path {
 		},
 
 		ExistenceCheck: b.configTokenExistenceCheck,
+
+		HelpSynopsis:    pathConfigTokenHelpSyn,
+		HelpDescription: pathConfigTokenHelpDesc,
 	}
 }
 
+func (b *backend) pathConfigTokensList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, configTokensPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
 func (b *backend) configTokenExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
-	entry, err := b.readConfigToken(ctx, req.Storage)
+	entry, err := b.readConfigToken(ctx, req.Storage, configTokenName(data))
 	if err != nil {
 		return false, err
 	}
@@ -41,8 +183,8 @@ func (b *backend) configTokenExistenceCheck(ctx context.Context, req *logical.Re
 	return entry != nil, nil
 }
 
-func (b *backend) readConfigToken(ctx context.Context, storage logical.Storage) (*accessTokenConfig, error) {
-	entry, err := storage.Get(ctx, configTokenKey)
+func (b *backend) readConfigToken(ctx context.Context, storage logical.Storage, name string) (*accessTokenConfig, error) {
+	entry, err := storage.Get(ctx, configTokenStorageKey(name))
 	if err != nil {
 		return nil, err
 	}
@@ -59,84 +201,342 @@ func (b *backend) readConfigToken(ctx context.Context, storage logical.Storage)
 }
 
 func (b *backend) pathConfigTokenRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	conf, err := b.readConfigToken(ctx, req.Storage)
+	conf, err := b.readConfigToken(ctx, req.Storage, configTokenName(data))
 	if err != nil {
 		return nil, err
 	}
 	if conf == nil {
-		return logical.ErrorResponse("configuration does not exist. did you configure 'config/token'?"), nil
+		return logical.ErrorResponse("configuration does not exist. did you configure '%s'?", configTokenStorageKey(configTokenName(data))), nil
+	}
+
+	// Both are informational so a token that does not decode is still readable
+	decodedToken, _ := gcom.DecodeToken(conf.Token)
+
+	respData := map[string]interface{}{
+		"id":                      conf.TokenID,
+		"name":                    conf.TokenName,
+		"expires_at":              conf.ExpiresAt,
+		"decoded_organization":    decodedToken.Organization,
+		"decoded_region":          decodedToken.Metadata.Region,
+		"accessPolicyID":          conf.AccessPolicyID,
+		"api_url":                 conf.APIURL,
+		"region":                  conf.Region,
+		"region_hosts":            conf.RegionHosts,
+		"org_slug":                conf.OrgSlug,
+		"proxy_url":               conf.ProxyURL,
+		"ca_cert":                 conf.CACert,
+		"client_cert":             conf.ClientCert,
+		"tls_skip_verify":         conf.TLSSkipVerify,
+		"api_rate_limit":          conf.APIRateLimit,
+		"root_token_ttl":          int64(conf.RootTokenTTL.Seconds()),
+		"rotation_period":         int64(conf.RotationPeriod.Seconds()),
+		"root_token_grace_period": int64(conf.RootTokenGracePeriod.Seconds()),
+		"disable_token_read":      !conf.AllowTokenRead,
+	}
+	if conf.AllowTokenRead {
+		respData["token"] = conf.Token
 	}
 
 	return &logical.Response{
-		Data: map[string]interface{}{
-			"token":          conf.Token,
-			"id":             conf.TokenID,
-			"accessPolicyID": conf.AccessPolicyID,
-		},
+		Data: respData,
 	}, nil
 }
 
 func (b *backend) pathConfigTokenWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	conf, err := b.readConfigToken(ctx, req.Storage)
+	// A rotation must not overwrite the token written here, nor this write
+	// the token of a rotation
+	b.rotateLock.Lock()
+	defer b.rotateLock.Unlock()
+
+	name := configTokenName(data)
+	conf, err := b.readConfigToken(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
-	if conf == nil {
+	created := conf == nil
+	if created {
 		conf = &accessTokenConfig{}
 	}
+	previousToken := conf.Token
 
 	var missingOptions []string
 	token, ok := data.GetOk("token")
-	if !ok {
-		missingOptions = append(missingOptions, "token")
-	} else {
+	if ok {
 		conf.Token = token.(string)
+	} else if created {
+		missingOptions = append(missingOptions, "token")
 	}
 	if len(missingOptions) > 0 {
 		return logical.ErrorResponse("Missing %s in configuration request", strings.Join(missingOptions, ",")), nil
 	}
 
-	client, err := createClient(conf.Token)
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to create client: %s", err)), nil
+	if apiURL, ok := data.GetOk("api_url"); ok {
+		conf.APIURL = strings.TrimSuffix(apiURL.(string), "/")
+		if conf.APIURL != "" {
+			parsed, err := url.Parse(conf.APIURL)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				return logical.ErrorResponse("invalid api_url '%s': must be an absolute url", conf.APIURL), nil
+			}
+		}
 	}
 
-	decodedToken, err := DecodeToken(conf.Token)
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to decode token: %s", err)), nil
+	if region, ok := data.GetOk("region"); ok {
+		conf.Region = region.(string)
+	}
+	if regionHosts, ok := data.GetOk("region_hosts"); ok {
+		conf.RegionHosts = regionHosts.(map[string]string)
+		for region, host := range conf.RegionHosts {
+			parsed, err := url.Parse(gcom.HostAPIURL(host))
+			if err != nil || parsed.Host == "" {
+				return logical.ErrorResponse("invalid host '%s' for region '%s' in region_hosts", host, region), nil
+			}
+		}
+	}
+	if orgSlug, ok := data.GetOk("org_slug"); ok {
+		conf.OrgSlug = orgSlug.(string)
+	}
+	if proxyURL, ok := data.GetOk("proxy_url"); ok {
+		conf.ProxyURL = proxyURL.(string)
+		if conf.ProxyURL != "" {
+			parsed, err := url.Parse(conf.ProxyURL)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				return logical.ErrorResponse("invalid proxy_url '%s': must be an absolute url", conf.ProxyURL), nil
+			}
+		}
+	}
+	if caCert, ok := data.GetOk("ca_cert"); ok {
+		conf.CACert = caCert.(string)
+	}
+	if clientCert, ok := data.GetOk("client_cert"); ok {
+		conf.ClientCert = clientCert.(string)
+	}
+	if clientKey, ok := data.GetOk("client_key"); ok {
+		conf.ClientKey = clientKey.(string)
+	}
+	if (conf.ClientCert == "") != (conf.ClientKey == "") {
+		return logical.ErrorResponse("client_cert and client_key must be set together"), nil
+	}
+	if tlsSkipVerify, ok := data.GetOk("tls_skip_verify"); ok {
+		conf.TLSSkipVerify = tlsSkipVerify.(bool)
+	}
+	if apiRateLimit, ok := data.GetOk("api_rate_limit"); ok {
+		conf.APIRateLimit = apiRateLimit.(int)
+		if conf.APIRateLimit < 0 {
+			return logical.ErrorResponse("api_rate_limit must not be negative"), nil
+		}
+	}
+	if rootTokenTTL, ok := data.GetOk("root_token_ttl"); ok {
+		conf.RootTokenTTL = time.Duration(rootTokenTTL.(int)) * time.Second
+		if conf.RootTokenTTL < 0 {
+			return logical.ErrorResponse("root_token_ttl must not be negative"), nil
+		}
+	}
+	if gracePeriod, ok := data.GetOk("root_token_grace_period"); ok {
+		conf.RootTokenGracePeriod = time.Duration(gracePeriod.(int)) * time.Second
+		if conf.RootTokenGracePeriod < 0 {
+			return logical.ErrorResponse("root_token_grace_period must not be negative"), nil
+		}
+	}
+	if rotationPeriod, ok := data.GetOk("rotation_period"); ok {
+		conf.RotationPeriod = time.Duration(rotationPeriod.(int)) * time.Second
+		if conf.RotationPeriod < 0 {
+			return logical.ErrorResponse("rotation_period must not be negative"), nil
+		}
+	}
+	if conf.RotationPeriod > 0 {
+		rootTokenTTL := defaultRootTokenTTL
+		if conf.RootTokenTTL > 0 {
+			rootTokenTTL = conf.RootTokenTTL
+		}
+		// Rotating after the token expired would leave the mount without a
+		// working token in between
+		if conf.RotationPeriod >= rootTokenTTL {
+			return logical.ErrorResponse("rotation_period must be shorter than root_token_ttl (%s)", rootTokenTTL), nil
+		}
+	}
+	if disableTokenRead, ok := data.GetOk("disable_token_read"); ok {
+		conf.AllowTokenRead = !disableTokenRead.(bool)
 	}
 
-	resp, err := client.GetTokenByName(decodedToken.TokenName)
+	decodedToken, decodeErr := gcom.DecodeToken(conf.Token)
+	if decodeErr != nil && (conf.Region == "" || conf.OrgSlug == "") {
+		return logical.ErrorResponse(fmt.Sprintf("failed to decode token, set region and org_slug to use a token that does not embed them: %s", decodeErr)), nil
+	}
+
+	client, err := conf.client()
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to get token: %s", err)), nil
+		return logical.ErrorResponse(fmt.Sprintf("failed to create client: %s", err)), nil
 	}
-	conf.AccessPolicyID = resp.AccessPolicyID
-	conf.TokenID = resp.ID
 
-	entry, err := logical.StorageEntryJSON(configTokenKey, conf)
+	if decodeErr == nil {
+		resp, err := client.GetTokenByName(ctx, decodedToken.TokenName)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to get token: %s", err)), nil
+		}
+		if resp.AccessPolicyID != conf.AccessPolicyID {
+			conf.ScopedAccessPolicy = false
+		}
+		conf.AccessPolicyID = resp.AccessPolicyID
+		conf.TokenID = resp.ID
+		conf.TokenName = resp.Name
+		conf.ExpiresAt = resp.ExpiresAt
+	} else {
+		// The name of the token is unknown, so it can not be looked up and
+		// config/rotate-root refuses to rotate it
+		conf.ScopedAccessPolicy = false
+		conf.AccessPolicyID = ""
+		conf.TokenID = ""
+		conf.TokenName = ""
+		conf.ExpiresAt = time.Time{}
+	}
+	if conf.Token != previousToken {
+		conf.LastRotatedAt = time.Now().UTC()
+	}
+
+	entry, err := logical.StorageEntryJSON(configTokenStorageKey(name), conf)
 	if err != nil {
 		return nil, err
 	}
 	if err := req.Storage.Put(ctx, entry); err != nil {
 		return nil, err
 	}
+	b.invalidateClient(name)
 
 	return nil, nil
 }
 
 func (b *backend) pathConfigTokenDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	if err := req.Storage.Delete(ctx, configTokenKey); err != nil {
+	name := configTokenName(data)
+
+	// Named configurations are only used by what references them, which
+	// would fall back to config/token if they were deleted
+	if name != "" {
+		references, err := b.configReferences(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(references) > 0 {
+			return logical.ErrorResponse("%s is still used by %s", configTokenStorageKey(name), strings.Join(references, ", ")), nil
+		}
+	}
+
+	if data.Get("revoke").(bool) {
+		conf, err := b.readConfigToken(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if conf != nil && conf.TokenID != "" {
+			client, err := conf.client()
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("failed to create client: %s", err)), nil
+			}
+			if err := client.DeleteToken(ctx, conf.TokenID); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("failed to revoke token '%s': %s", conf.TokenID, err)), nil
+			}
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, configTokenStorageKey(name)); err != nil {
 		return nil, err
 	}
+	b.invalidateClient(name)
+
 	return nil, nil
 }
 
+// configReferences returns the storage keys of the access policies, roles and
+// static roles using the named configuration
+func (b *backend) configReferences(ctx context.Context, s logical.Storage, name string) ([]string, error) {
+	var references []string
+
+	policies, err := s.List(ctx, "access_policies/")
+	if err != nil {
+		return nil, err
+	}
+	for _, policyName := range policies {
+		entry, err := b.accessPoliciesRead(ctx, s, policyName)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && entry.Config == name {
+			references = append(references, "access_policies/"+policyName)
+		}
+	}
+
+	roles, err := s.List(ctx, rolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleName := range roles {
+		role, err := b.roleRead(ctx, s, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.Config == name {
+			references = append(references, rolesPrefix+roleName)
+		}
+	}
+
+	staticRoles, err := s.List(ctx, staticRolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleName := range staticRoles {
+		role, err := b.staticRoleRead(ctx, s, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+		roleConfig, err := b.staticRoleConfig(ctx, s, role)
+		if err != nil {
+			return nil, err
+		}
+		if roleConfig == name {
+			references = append(references, staticRolesPrefix+roleName)
+		}
+	}
+
+	return references, nil
+}
+
 type accessTokenConfig struct {
-	TokenID        string `json:"id"`
-	Token          string `json:"token"`
-	AccessPolicyID string `json:"access_policy_id"`
+	TokenID              string            `json:"id"`
+	TokenName            string            `json:"name"`
+	Token                string            `json:"token"`
+	AccessPolicyID       string            `json:"access_policy_id"`
+	ExpiresAt            time.Time         `json:"expires_at"`
+	APIURL               string            `json:"api_url"`
+	Region               string            `json:"region"`
+	RegionHosts          map[string]string `json:"region_hosts"`
+	OrgSlug              string            `json:"org_slug"`
+	ProxyURL             string            `json:"proxy_url"`
+	CACert               string            `json:"ca_cert"`
+	ClientCert           string            `json:"client_cert"`
+	ClientKey            string            `json:"client_key"`
+	TLSSkipVerify        bool              `json:"tls_skip_verify"`
+	APIRateLimit         int               `json:"api_rate_limit"`
+	RootTokenTTL         time.Duration     `json:"root_token_ttl"`
+	RotationPeriod       time.Duration     `json:"rotation_period"`
+	RootTokenGracePeriod time.Duration     `json:"root_token_grace_period"`
+	LastRotatedAt        time.Time         `json:"last_rotated_at"`
+	// Stored inverted so configurations written before disable_token_read
+	// existed are redacted as well
+	AllowTokenRead bool `json:"allow_token_read"`
+	// ScopedAccessPolicy is set when the access policy was created by a
+	// scoped rotation, so it is deleted once no longer used
+	ScopedAccessPolicy bool `json:"scoped_access_policy"`
 }
 
+const pathListConfigTokensHelpSyn = `List the named Grafana Cloud configurations of this mount`
+
+const pathListConfigTokensHelpDesc = `
+Named configurations allow a single mount to manage several Grafana Cloud
+organizations. Access policies and roles select one with their 'config' field
+and use config/token when it is empty.
+`
+
 const pathConfigTokenHelpSyn = `
 Configure Grafana Cloud token and options used by vault
 `
@@ -151,4 +551,29 @@ and token name, see their documentation at
 https://grafana.com/docs/grafana-cloud/cloud-portal/create-api-key/. The
 organization slug can be found by logging into your stack and looking at the
 url, e.g. https://grafana.com/orgs/{orgSlug}.
+
+Set 'api_url' to send requests to a regional endpoint, a proxy, or a test
+server instead of https://grafana.com/api/v1. Without it requests go to the
+API host of the region, which 'region_hosts' sets per region, e.g.
+'region_hosts=prod-eu-west-0=grafana-eu.example.com'. 'region' and 'org_slug'
+take precedence over the metadata decoded from the token, for tokens that do
+not embed it.
+
+'ca_cert', 'client_cert', 'client_key' and 'tls_skip_verify' configure TLS for
+environments that route API traffic through an intercepting proxy, and
+'proxy_url' sends requests through an explicit HTTP(S) proxy. The standard
+proxy environment variables are honored otherwise.
+
+'api_rate_limit' caps the requests per second sent to the Grafana Cloud API
+with the configuration, so bursts of credential reads wait for their turn
+instead of getting the organization throttled.
+
+The token is not returned when reading the configuration unless
+'disable_token_read' is set to false. Deleting the configuration with
+'revoke=true' also deletes the token in Grafana Cloud.
+
+Setting 'rotation_period' rotates the token in the background, like
+config/rotate-root, once that long has passed since it was last rotated.
+With 'root_token_grace_period' the replaced token is only deleted once the
+grace period has passed.
 `
@@ -0,0 +1,188 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_config_token_rotation_period(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	testCases := []struct {
+		data  map[string]interface{}
+		error string
+	}{
+		{
+			data:  map[string]interface{}{"rotation_period": "2h", "root_token_ttl": "1h"},
+			error: "rotation_period must be shorter than root_token_ttl (1h0m0s)",
+		},
+		{
+			data:  map[string]interface{}{"rotation_period": "1h", "root_token_ttl": "1h"},
+			error: "rotation_period must be shorter than root_token_ttl (1h0m0s)",
+		},
+		{
+			data:  map[string]interface{}{"rotation_period": "2160h"},
+			error: "rotation_period must be shorter than root_token_ttl (2160h0m0s)",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase.data["token"] = "fake"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/token",
+			Storage:   s,
+			Data:      testCase.data,
+		})
+		assert.NoError(t, err)
+		if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+			assert.Equal(t, testCase.error, resp.Error().Error())
+		}
+	}
+}
+
+func TestBackend_config_token_opaque_token(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/token",
+		Storage:   s,
+		Data:      map[string]interface{}{"token": "opaque"},
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Contains(t, resp.Error().Error(), "set region and org_slug")
+	}
+
+	// A token that does not decode is accepted once it needs no decoding
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/token",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"token":    "opaque",
+			"region":   "prod-us-east-0",
+			"org_slug": "myorg",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write config: resp: %#v err: %v", resp, err)
+	}
+
+	conf, err := b.readConfigToken(context.Background(), s, "")
+	if assert.NoError(t, err) && assert.NotNil(t, conf) {
+		assert.Equal(t, "opaque", conf.Token)
+		assert.Empty(t, conf.TokenID)
+		assert.Empty(t, conf.AccessPolicyID)
+	}
+}
+
+func TestBackend_config_token_delete_referenced(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/tokens/other",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"token":    "opaque",
+			"region":   "prod-us-east-0",
+			"org_slug": "otherorg",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write config: resp: %#v err: %v", resp, err)
+	}
+
+	testAccessPolicy(t, b, s, "test", map[string]interface{}{
+		"config": "other",
+	})
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/tokens/other",
+		Storage:   s,
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Equal(t, "config/tokens/other is still used by access_policies/test", resp.Error().Error())
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "access_policies/test",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete access policy: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config/tokens/other",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete config: resp: %#v err: %v", resp, err)
+	}
+}
+
+func TestBackend_config_token_update_without_token(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	writeConfig := func(path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   s,
+			Data:      data,
+		})
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp := writeConfig("config/token", map[string]interface{}{
+		"token":    "opaque",
+		"region":   "prod-us-east-0",
+		"org_slug": "myorg",
+	})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to write config: resp: %#v", resp)
+	}
+	written, err := b.readConfigToken(context.Background(), s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updates keep the token, and only a new token counts as a rotation
+	resp = writeConfig("config/token", map[string]interface{}{"api_rate_limit": 5})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to update config: resp: %#v", resp)
+	}
+	conf, err := b.readConfigToken(context.Background(), s, "")
+	if assert.NoError(t, err) && assert.NotNil(t, conf) {
+		assert.Equal(t, "opaque", conf.Token)
+		assert.Equal(t, 5, conf.APIRateLimit)
+		assert.Equal(t, written.LastRotatedAt, conf.LastRotatedAt)
+	}
+
+	resp = writeConfig("config/token", map[string]interface{}{"token": "rotated"})
+	if resp != nil && resp.IsError() {
+		t.Fatalf("failed to update config: resp: %#v", resp)
+	}
+	conf, err = b.readConfigToken(context.Background(), s, "")
+	if assert.NoError(t, err) && assert.NotNil(t, conf) {
+		assert.Equal(t, "rotated", conf.Token)
+		assert.True(t, conf.LastRotatedAt.After(written.LastRotatedAt))
+	}
+
+	resp = writeConfig("config/tokens/other", map[string]interface{}{"api_rate_limit": 5})
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Equal(t, "Missing token in configuration request", resp.Error().Error())
+	}
+}
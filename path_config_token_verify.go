@@ -0,0 +1,115 @@
+package grafanacloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfigTokenVerify(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/token/verify",
+		Fields: map[string]*framework.FieldSchema{
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ to verify. Verifies config/token when empty",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathConfigTokenVerifyRead,
+		},
+
+		HelpSynopsis:    pathConfigTokenVerifyHelpSyn,
+		HelpDescription: pathConfigTokenVerifyHelpDesc,
+	}
+}
+
+// Checks the stored token against the grafana cloud api without changing
+// anything. Failures are reported in the response rather than as errors so
+// they can be inspected.
+func (b *backend) pathConfigTokenVerifyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	configName := data.Get("config").(string)
+	conf, err := b.readConfigToken(ctx, req.Storage, configName)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		return logical.ErrorResponse("configuration does not exist. did you configure '%s'?", configTokenStorageKey(configName)), nil
+	}
+
+	// The feature flags of the mount are reported with the result as they
+	// change what the token is used for
+	features, err := b.FeaturesConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := func(reason string) (*logical.Response, error) {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"valid":    false,
+				"error":    reason,
+				"features": features.toMap(),
+			},
+		}, nil
+	}
+
+	c, err := conf.client()
+	if err != nil {
+		return invalid(fmt.Sprintf("failed to create client: %s", err))
+	}
+
+	tokenName := conf.TokenName
+	if tokenName == "" {
+		decodedToken, err := gcom.DecodeToken(conf.Token)
+		if err != nil {
+			return invalid(fmt.Sprintf("failed to decode token: %s", err))
+		}
+		tokenName = decodedToken.TokenName
+	}
+
+	token, err := c.GetTokenByName(ctx, tokenName)
+	if errors.Is(err, gcom.ErrUnauthorized) {
+		return invalid(fmt.Sprintf("token was rejected by grafana cloud, it may be expired, deleted or lack the tokens:read scope: %s", err))
+	}
+	if err != nil {
+		return invalid(fmt.Sprintf("failed to get token: %s", err))
+	}
+	if token.ID != conf.TokenID {
+		return invalid(fmt.Sprintf("token '%s' has id '%s' but '%s' is configured", tokenName, token.ID, conf.TokenID))
+	}
+
+	policy, err := c.GetAccessPolicy(ctx, token.AccessPolicyID)
+	if err != nil {
+		return invalid(fmt.Sprintf("failed to read access policy '%s': %s", token.AccessPolicyID, err))
+	}
+	if policy == nil {
+		return invalid(fmt.Sprintf("access policy '%s' of the token does not exist", token.AccessPolicyID))
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"valid":            true,
+			"id":               token.ID,
+			"name":             token.Name,
+			"access_policy_id": token.AccessPolicyID,
+			"scopes":           policy.Scopes,
+			"expires_at":       token.ExpiresAt,
+			"features":         features.toMap(),
+		},
+	}, nil
+}
+
+const pathConfigTokenVerifyHelpSyn = `Check that the configured Grafana Cloud token works`
+
+const pathConfigTokenVerifyHelpDesc = `
+Looks up the configured token and its access policy in Grafana Cloud and
+reports whether the token is valid, its scopes, and when it expires, along
+with the feature flags of the mount. Nothing is modified, so this can be used
+to debug the mount before issuing credentials starts failing.
+`
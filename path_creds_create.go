@@ -2,10 +2,15 @@ package grafanacloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strings"
 	"time"
 
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -13,18 +18,52 @@ import (
 // token
 const maxTokenNameLength = 256
 
+// maxCreateTokenAttempts is the number of times creating a token is attempted
+// when its name is already taken
+const maxCreateTokenAttempts = 3
+
+// maxCredsCount is the maximum number of tokens issued by a single creds
+// request
+const maxCredsCount = 100
+
 func pathCredCreate(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "creds/" + framework.GenericNameRegex("name"),
 		Fields: map[string]*framework.FieldSchema{
 			"name": &framework.FieldSchema{
 				Type:        framework.TypeString,
-				Description: "Name of the access policy to generate a key for",
+				Description: "Name of the role or access policy to generate a key for",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the token. Defaults to the ttl of the role or the mount and is capped at their max_ttl",
+			},
+			"display_name": {
+				Type:        framework.TypeString,
+				Description: "Display name of the token. Overrides the display_name of the role",
+			},
+			"count": {
+				Type:        framework.TypeInt,
+				Description: "Number of tokens to issue under a single lease",
+				Default:     1,
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Metadata added to the metadata of the role. Keys set by the role can not be overridden",
+			},
+			"region": {
+				Type:        framework.TypeString,
+				Description: "Grafana Cloud region to issue the token in. Overrides the region of the role",
+			},
+			"idempotency_key": {
+				Type:        framework.TypeString,
+				Description: "Identifies the request across retries. Tokens are named after it, so a token left behind by an earlier attempt whose lease was never returned is replaced, while a key whose token was issued can not be used again",
 			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation: b.pathCredRead,
+			logical.ReadOperation:   b.pathCredRead,
+			logical.UpdateOperation: b.pathCredRead,
 		},
 	}
 }
@@ -32,12 +71,6 @@ func pathCredCreate(b *backend) *framework.Path {
 func (b *backend) pathCredRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	name := d.Get("name").(string)
 
-	// Get the http client
-	c, err := b.client(ctx, req.Storage)
-	if err != nil {
-		return nil, err
-	}
-
 	lease, err := b.LeaseConfig(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -46,47 +79,663 @@ func (b *backend) pathCredRead(ctx context.Context, req *logical.Request, d *fra
 		lease = &configLease{}
 	}
 
-	policy, err := b.accessPoliciesRead(ctx, req.Storage, name)
+	role, policy, err := b.credsRole(ctx, req.Storage, name)
+	if errors.Is(err, errGeneratedAccessPolicy) {
+		return logical.ErrorResponse(err.Error()), nil
+	}
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("failed to read access policy '%s': %s", name, err)), nil
+		return logical.ErrorResponse(fmt.Sprintf("failed to read role '%s': %s", name, err)), nil
 	}
-	if policy == nil {
-		return logical.ErrorResponse(fmt.Sprintf("did not file access policy '%s'", name)), nil
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("did not find role or access policy '%s'", name)), nil
+	}
+	if policy != nil && policy.inactive() {
+		return logical.ErrorResponse("access policy of '%s' is inactive", name), nil
 	}
 
-	ttl, _, err := framework.CalculateTTL(b.System(), 0, lease.TTL, 0, lease.MaxTTL, 0, time.Time{})
+	count := d.Get("count").(int)
+	if count < 1 || count > maxCredsCount {
+		return logical.ErrorResponse("count must be between 1 and %d", maxCredsCount), nil
+	}
+
+	custom := false
+	if displayName, ok := d.GetOk("display_name"); ok {
+		roleCopy := *role
+		roleCopy.DisplayName = displayName.(string)
+		role = &roleCopy
+		custom = true
+	}
+	if metadata, ok := d.GetOk("metadata"); ok && len(metadata.(map[string]string)) > 0 {
+		merged := make(map[string]string, len(role.Metadata))
+		for key, value := range metadata.(map[string]string) {
+			merged[key] = value
+		}
+		for key, value := range role.Metadata {
+			merged[key] = value
+		}
+		roleCopy := *role
+		roleCopy.Metadata = merged
+		role = &roleCopy
+		custom = true
+	}
+
+	if region, ok := d.GetOk("region"); ok && region.(string) != role.Region {
+		if err := role.validateRegion(region.(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		roleCopy := *role
+		roleCopy.Region = region.(string)
+		role = &roleCopy
+		custom = true
+	}
+
+	configName := role.Config
+	if policy != nil {
+		configName = policy.Config
+	}
+
+	// Get the http client
+	c, err := b.regionClient(ctx, req.Storage, configName, role.Region)
 	if err != nil {
-		return logical.ErrorResponse("failed to calculate ttl. err: %w", err), nil
+		return nil, err
 	}
 
-	// Create it
-	b.Logger().Info(fmt.Sprintf("creating grafana-cloud token (policy: %s)...", name))
-	tokenName := createTokenName(name)
-	token, err := c.CreateToken(CreateTokenRequest{
-		AccessPolicyID: policy.Policy.ID,
-		Name:           tokenName,
-		DisplayName:    tokenName,
-		ExpiresAt:      time.Now().UTC().Add(ttl),
-	})
+	backendTTL, backendMaxTTL := lease.TTL, lease.MaxTTL
+	if role.TTL > 0 {
+		backendTTL = role.TTL
+	}
+	if role.MaxTTL > 0 {
+		backendMaxTTL = role.MaxTTL
+	}
+
+	// A requested ttl is treated like a renewal increment so it is capped at
+	// the max ttl
+	requestedTTL := time.Duration(d.Get("ttl").(int)) * time.Second
+	if requestedTTL < 0 {
+		return logical.ErrorResponse("ttl must not be negative"), nil
+	}
+
+	ttl, _, err := framework.CalculateTTL(b.System(), requestedTTL, backendTTL, 0, backendMaxTTL, 0, time.Time{})
+	if err != nil {
+		return logical.ErrorResponse("failed to calculate ttl. err: %w", err), nil
+	}
+	desiredTTL := backendTTL
+	if requestedTTL > 0 {
+		desiredTTL = requestedTTL
+	}
+	var ttlWarnings []string
+	if ttl < desiredTTL {
+		ttlWarnings = append(ttlWarnings, fmt.Sprintf("ttl of %s is greater than the max_ttl, the token expires after %s", desiredTTL, ttl))
+	}
+	if requestedTTL == 0 {
+		ttl = applyTTLJitter(ttl, role.TTLJitter)
+	}
+	ttl, err = enforceMinTTL(requestedTTL, ttl, minLeaseTTL(lease, role))
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("err while creating token with role '%s' from grafana cloud. err: %s", name, err)), nil
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Only requests that would be served count against the limit
+	if !b.allowCreds(role.limiterKey, role.RateLimit) {
+		return nil, fmt.Errorf("too many credentials requested for '%s', limit is %d per minute: %w", name, role.RateLimit, logical.ErrRateLimitQuotaExceeded)
+	}
+
+	issue := &credsIssue{
+		name:           name,
+		role:           role,
+		policy:         policy,
+		config:         configName,
+		region:         role.Region,
+		ttl:            ttl,
+		maxTTL:         backendMaxTTL,
+		custom:         custom || requestedTTL > 0,
+		idempotencyKey: d.Get("idempotency_key").(string),
+	}
+
+	if role.MaxTokens > 0 || role.ReuseWindow > 0 {
+		lock := locksutil.LockForKey(b.issueLocks, name)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	reuse := role.ReuseWindow > 0 && count == 1 && !issue.custom
+	if reuse {
+		resp, err := b.reuseToken(ctx, req.Storage, issue)
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil {
+			return resp, nil
+		}
+	}
+
+	if role.MaxTokens > 0 {
+		active, err := b.countIssuedTokens(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if active+count > role.MaxTokens {
+			return logical.ErrorResponse("role '%s' has %d active tokens, issuing %d more would exceed the maximum of %d", name, active, count, role.MaxTokens), nil
+		}
+	}
+
+	responses := make([]*logical.Response, 0, count)
+	for i := 0; i < count; i++ {
+		issue.seq = i
+		resp, err := b.issueCreds(ctx, req, c, issue)
+		if err != nil || resp == nil || resp.Secret == nil {
+			// Do not leave the tokens of a partially issued batch behind
+			for _, issued := range responses {
+				if revokeErr := b.revokeToken(ctx, req.Storage, c, issued.Secret.InternalData); revokeErr != nil {
+					b.Logger().Error("failed to revoke token of partially issued batch", "id", issued.Secret.InternalData["id"], "error", revokeErr)
+				}
+			}
+			return resp, err
+		}
+
+		responses = append(responses, resp)
+		if id, ok := resp.Secret.InternalData["id"].(string); ok {
+			// None of the leases of the batch are returned when recording
+			// fails, so every token issued so far is revoked
+			err := b.recordIssuedCredential(ctx, req.Storage, name, id, func() error {
+				var revokeErr error
+				for _, issued := range responses {
+					if err := b.revokeToken(ctx, req.Storage, c, issued.Secret.InternalData); err != nil {
+						revokeErr = errors.Join(revokeErr, err)
+					}
+				}
+				return revokeErr
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	recordTokens("issued", name, count)
+
+	resp := responses[0]
+	if count > 1 {
+		resp = b.batchCredsResponse(issue, responses)
+	}
+	if reuse {
+		if err := b.rememberReusedToken(ctx, req.Storage, name, resp); err != nil {
+			return nil, err
+		}
+	}
+	for _, warning := range ttlWarnings {
+		resp.AddWarning(warning)
+	}
+
+	return resp, nil
+}
+
+// credsIssue holds the resolved settings of a single creds request
+type credsIssue struct {
+	name   string
+	role   *roleEntry
+	policy *accessPolicyEntry
+	config string
+	// region tokens are issued in, the region of the config when empty
+	region string
+	ttl    time.Duration
+	maxTTL time.Duration
+	// custom is set when the request overrides the ttl, display name,
+	// metadata or region of the role
+	custom bool
+	// idempotencyKey is the key given by the caller to recognize the tokens of
+	// earlier attempts of the request, if any
+	idempotencyKey string
+	// seq is the index of the token being issued within the request
+	seq int
+}
+
+// issueCreds issues a single credential of the credential type of the role
+func (b *backend) issueCreds(ctx context.Context, req *logical.Request, c GrafanaClient, issue *credsIssue) (*logical.Response, error) {
+	switch issue.role.CredentialType {
+	case "", credentialTypeAccessPolicyToken:
+		return b.issueAccessPolicyToken(ctx, req, c, issue)
+	case credentialTypeStackAPIKey:
+		return logical.ErrorResponse("role '%s' issues stack API keys, read creds/stack-apikey/%s instead", issue.name, issue.name), nil
+	case credentialTypeStackServiceAccount:
+		return logical.ErrorResponse("role '%s' issues stack service accounts, read creds/stack-service-account/%s instead", issue.name, issue.name), nil
+	case credentialTypeOrgAPIKey:
+		return logical.ErrorResponse("role '%s' issues org API keys, read creds/org-apikey/%s instead", issue.name, issue.name), nil
+	case credentialTypeSyntheticMonitoring:
+		return logical.ErrorResponse("role '%s' issues Synthetic Monitoring tokens, read creds/synthetic-monitoring/%s instead", issue.name, issue.name), nil
+	case credentialTypeK6Token:
+		return logical.ErrorResponse("role '%s' issues k6 tokens, read creds/k6/%s instead", issue.name, issue.name), nil
+	default:
+		return logical.ErrorResponse("role '%s' has an unsupported credential_type '%s'", issue.name, issue.role.CredentialType), nil
+	}
+}
+
+// batchCredsResponse combines the responses of a creds request with a count
+// into a single lease that revokes every token
+func (b *backend) batchCredsResponse(issue *credsIssue, responses []*logical.Response) *logical.Response {
+	tokens := make([]map[string]interface{}, 0, len(responses))
+	internalTokens := make([]interface{}, 0, len(responses))
+	ttl := responses[0].Secret.TTL
+	for _, issued := range responses {
+		tokens = append(tokens, issued.Data)
+		internalTokens = append(internalTokens, issued.Secret.InternalData)
+		if issued.Secret.TTL < ttl {
+			ttl = issued.Secret.TTL
+		}
 	}
 
-	// Use the helper to create the secret
 	resp := b.Secret(SecretTokenType).Response(map[string]interface{}{
-		"id":               token.ID,
-		"access_policy_id": token.AccessPolicyID,
-		"token":            token.Token,
-		"name":             token.Name,
+		"tokens": tokens,
 	}, map[string]interface{}{
+		"role":   issue.name,
+		"config": issue.config,
+		"region": issue.region,
+		"tokens": internalTokens,
+	})
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = issue.maxTTL
+	resp.Secret.Renewable = false
+
+	return resp
+}
+
+// issueAccessPolicyToken issues a token for the access policy of the role
+func (b *backend) issueAccessPolicyToken(ctx context.Context, req *logical.Request, c GrafanaClient, issue *credsIssue) (*logical.Response, error) {
+	name, role, policy, ttl := issue.name, issue.role, issue.policy, issue.ttl
+
+	accessPolicyID := role.AccessPolicyID
+	if policy != nil {
+		accessPolicyID = policy.Policy.ID
+	}
+
+	var bundleStack *gcom.Stack
+	if role.Bundle != "" {
+		stack, err := c.CachedStack(ctx, role.StackSlug)
+		if err != nil {
+			return logical.ErrorResponse("failed to read stack '%s' from grafana cloud: %s", role.StackSlug, err), nil
+		}
+		if stack == nil {
+			return logical.ErrorResponse("stack '%s' of the %s bundle does not exist", role.StackSlug, role.Bundle), nil
+		}
+		bundleStack = stack
+	}
+
+	if len(role.AllowedScopes) > 0 && role.AccessPolicyTemplate == "" {
+		var scopes []string
+		if policy != nil {
+			scopes = policy.Policy.Scopes
+		} else {
+			remotePolicy, err := c.GetAccessPolicy(ctx, accessPolicyID)
+			if err != nil {
+				return logical.ErrorResponse("failed to read access policy '%s' from grafana cloud: %s", accessPolicyID, err), nil
+			}
+			if remotePolicy == nil {
+				return logical.ErrorResponse("access policy '%s' does not exist in grafana cloud", accessPolicyID), nil
+			}
+			scopes = remotePolicy.Scopes
+		}
+
+		if disallowed := disallowedScopes(role.AllowedScopes, scopes); len(disallowed) > 0 {
+			return logical.ErrorResponse("access policy of role '%s' grants scopes outside of allowed_scopes: %s", name, strings.Join(disallowed, ", ")), nil
+		}
+	}
+
+	var token *gcom.TokenResponse
+	// Pooled tokens are created with the mount's lease so they can only be
+	// handed out to roles and requests that do not customize them
+	if policy != nil && policy.PoolSize > 0 && role.TTL == 0 && !role.NoExpiration && !issue.custom {
+		pooled, err := b.popPooledToken(ctx, req.Storage, role.AccessPolicy, policy.Policy.ID, ttl/2)
+		if err != nil {
+			return nil, err
+		}
+		if pooled != nil {
+			b.Logger().Debug(fmt.Sprintf("using pooled grafana-cloud token (policy: %s)", role.AccessPolicy))
+			token = &gcom.TokenResponse{
+				ID:             pooled.ID,
+				AccessPolicyID: pooled.AccessPolicyID,
+				Name:           pooled.Name,
+				Token:          pooled.Token,
+				ExpiresAt:      pooled.ExpiresAt,
+			}
+			ttl = time.Until(pooled.ExpiresAt)
+		}
+	}
+
+	var ephemeralPolicyID, walID string
+	if token == nil {
+		tokenName, displayName, err := b.tokenNames(req, name, role)
+		if issue.idempotencyKey != "" {
+			tokenName, displayName, err = b.idempotentTokenNames(req, name, role, issue.idempotencyKey, issue.seq)
+		}
+		if err == nil {
+			err = validateTokenName(tokenName)
+		}
+		if err != nil {
+			return logical.ErrorResponse("failed to generate token name for role '%s': %s", name, err), nil
+		}
+		if issue.idempotencyKey != "" {
+			if errResp, err := b.deleteUnclaimedToken(ctx, req.Storage, c, name, tokenName); errResp != nil || err != nil {
+				return errResp, err
+			}
+		}
+
+		// Rolled back if vault stops before the lease is returned, which
+		// deletes the token and the access policy created for it
+		walEntry := &walIssuedToken{
+			Role:      name,
+			Config:    issue.config,
+			Region:    issue.region,
+			TokenName: tokenName,
+		}
+		if role.AccessPolicyTemplate != "" {
+			walEntry.EphemeralPolicyName = tokenName
+		}
+
+		if role.AccessPolicyTemplate != "" {
+			features, err := b.FeaturesConfig(ctx, req.Storage)
+			if err != nil {
+				return nil, err
+			}
+			if !features.EphemeralPolicies {
+				return logical.ErrorResponse("role '%s' uses an access_policy_template which requires the 'ephemeral_policies' feature in config/features", name), nil
+			}
+
+			policyBody, err := b.renderAccessPolicyTemplate(req, role.AccessPolicyTemplate)
+			if err != nil {
+				return logical.ErrorResponse("failed to render access policy template of role '%s': %s", name, err), nil
+			}
+			policyBody["name"] = tokenName
+			if len(role.BoundCIDRs) > 0 {
+				conditions, _ := policyBody["conditions"].(map[string]interface{})
+				if conditions == nil {
+					conditions = map[string]interface{}{}
+				}
+				conditions["allowedSubnets"] = role.BoundCIDRs
+				policyBody["conditions"] = conditions
+			}
+
+			if disallowed := disallowedScopes(role.AllowedScopes, policyBodyScopes(policyBody)); len(disallowed) > 0 {
+				return logical.ErrorResponse("access policy of role '%s' grants scopes outside of allowed_scopes: %s", name, strings.Join(disallowed, ", ")), nil
+			}
+
+			walID, err = framework.PutWAL(ctx, req.Storage, walIssuedTokenKind, walEntry)
+			if err != nil {
+				return nil, fmt.Errorf("error writing WAL entry: %w", err)
+			}
+
+			b.Logger().Info(fmt.Sprintf("creating grafana-cloud access policy (role: %s)...", name))
+			ephemeralPolicy, err := c.CreateAccessPolicy(ctx, policyBody)
+			if err != nil {
+				if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+					return nil, fmt.Errorf("error deleting WAL entry: %w", err)
+				}
+				return logical.ErrorResponse(fmt.Sprintf("failed to create access policy for role '%s' in grafana cloud: %s", name, err)), nil
+			}
+			ephemeralPolicyID = ephemeralPolicy.ID
+			accessPolicyID = ephemeralPolicy.ID
+		}
+
+		// Create it
+		b.Logger().Info(fmt.Sprintf("creating grafana-cloud token (role: %s)...", name))
+		tokenReq := gcom.CreateTokenRequest{
+			AccessPolicyID: accessPolicyID,
+			Name:           tokenName,
+			DisplayName:    displayName,
+			ExpiresAt:      time.Now().UTC().Add(ttl),
+		}
+		if role.NoExpiration {
+			// The lease alone decides when the token is deleted
+			tokenReq.ExpiresAt = time.Time{}
+		}
+
+		if walID == "" {
+			walID, err = framework.PutWAL(ctx, req.Storage, walIssuedTokenKind, walEntry)
+			if err != nil {
+				return nil, fmt.Errorf("error writing WAL entry: %w", err)
+			}
+		}
+
+		token, err = c.CreateToken(ctx, tokenReq)
+		// Names are generated from the current time so another request, or
+		// vault node, may have taken the name. Names of idempotent requests
+		// are only taken by a concurrent attempt of the same request.
+		for attempt := 1; errors.Is(err, gcom.ErrConflict) && issue.idempotencyKey == "" && attempt < maxCreateTokenAttempts; attempt++ {
+			b.Logger().Debug("token name is taken, retrying with a new name", "name", tokenReq.Name)
+			tokenReq.Name, tokenReq.DisplayName, err = b.tokenNames(req, name, role)
+			if err != nil {
+				break
+			}
+			if walID, err = b.renameIssuedTokenWAL(ctx, req.Storage, walID, walEntry, tokenReq.Name); err != nil {
+				break
+			}
+			token, err = c.CreateToken(ctx, tokenReq)
+		}
+		if err != nil && policy != nil && ephemeralPolicyID == "" {
+			token, err = b.retryWithRecreatedAccessPolicy(ctx, req.Storage, c, role.AccessPolicy, err, tokenReq)
+		}
+		if err != nil {
+			cleanedUp := true
+			if ephemeralPolicyID != "" {
+				if _, deleteErr := c.DeleteAccessPolicy(ctx, ephemeralPolicyID); deleteErr != nil {
+					b.Logger().Error("failed to delete access policy after token creation failed", "id", ephemeralPolicyID, "error", deleteErr)
+					cleanedUp = false
+				}
+			}
+			// The WAL is left for rollback to delete what could not be
+			// deleted here
+			if cleanedUp && walID != "" {
+				if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+					return nil, fmt.Errorf("error deleting WAL entry: %w", err)
+				}
+			}
+			return logical.ErrorResponse(fmt.Sprintf("err while creating token with role '%s' from grafana cloud. err: %s", name, err)), nil
+		}
+	}
+
+	// Use the helper to create the secret
+	data := map[string]interface{}{
 		"id":               token.ID,
 		"access_policy_id": token.AccessPolicyID,
 		"token":            token.Token,
 		"name":             token.Name,
+		"expires_at":       token.ExpiresAt,
+		"access_policy":    role.AccessPolicy,
+		"region":           c.Region(),
+	}
+	if len(role.Metadata) > 0 {
+		data["metadata"] = role.Metadata
+	}
+	if role.StackSlug != "" {
+		data["stack_slug"] = role.StackSlug
+		data["grafana_url"] = stackGrafanaURL(role.StackSlug)
+	}
+	for key, value := range credentialBundle(role.Bundle, bundleStack, token.Token) {
+		data[key] = value
+	}
+	resp := b.Secret(SecretTokenType).Response(data, map[string]interface{}{
+		"id":                         token.ID,
+		"access_policy_id":           token.AccessPolicyID,
+		"token":                      token.Token,
+		"name":                       token.Name,
+		"role":                       name,
+		"config":                     issue.config,
+		"region":                     issue.region,
+		"ephemeral_access_policy_id": ephemeralPolicyID,
 	})
 	resp.Secret.TTL = ttl
-	resp.Secret.MaxTTL = lease.MaxTTL
+	resp.Secret.MaxTTL = issue.maxTTL
 	resp.Secret.Renewable = false
 
+	if walID != "" {
+		if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+			return nil, fmt.Errorf("error deleting WAL entry: %w", err)
+		}
+	}
+
 	return resp, nil
 }
+
+// deleteUnclaimedToken deletes the token named after the idempotency key of a
+// request, along with its ephemeral access policy, when an earlier attempt of
+// the request created it but never returned its lease. Its secret can not be
+// read again so it is replaced. A token whose lease was returned is not.
+func (b *backend) deleteUnclaimedToken(ctx context.Context, s logical.Storage, c GrafanaClient, roleName string, tokenName string) (*logical.Response, error) {
+	token, err := c.GetTokenByName(ctx, tokenName)
+	if err != nil && !errors.Is(err, gcom.ErrNotFound) {
+		return nil, err
+	}
+	if token != nil {
+		issued, err := s.Get(ctx, issuedTokensPath(roleName)+token.ID)
+		if err != nil {
+			return nil, err
+		}
+		if issued != nil {
+			return logical.ErrorResponse("idempotency_key was already used to issue token '%s'", tokenName), nil
+		}
+
+		b.Logger().Warn("deleting token left behind by an earlier attempt of the request", "name", tokenName)
+		if err := c.DeleteToken(ctx, token.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	policy, err := c.GetAccessPolicyByName(ctx, tokenName)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	b.Logger().Warn("deleting access policy left behind by an earlier attempt of the request", "name", tokenName)
+	if _, err := c.DeleteAccessPolicy(ctx, policy.ID); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// renameIssuedTokenWAL replaces the WAL entry of a token being issued once the
+// token is created under a new name, and returns the ID of the new entry
+func (b *backend) renameIssuedTokenWAL(ctx context.Context, s logical.Storage, walID string, walEntry *walIssuedToken, tokenName string) (string, error) {
+	walEntry.TokenName = tokenName
+	newWALID, err := framework.PutWAL(ctx, s, walIssuedTokenKind, walEntry)
+	if err != nil {
+		return walID, fmt.Errorf("error writing WAL entry: %w", err)
+	}
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		return newWALID, fmt.Errorf("error deleting WAL entry: %w", err)
+	}
+
+	return newWALID, nil
+}
+
+// errGeneratedAccessPolicy is returned by credsRole for access policies
+// generated for a role, whose credentials are only issued through the role
+var errGeneratedAccessPolicy = errors.New("access policy is generated for a role")
+
+// credsRole returns the role used to issue credentials for name along with
+// the stored access policy it references, if any. Access policies without a
+// role of the same name are issued with the default settings, unless they
+// were generated for a role.
+func (b *backend) credsRole(ctx context.Context, s logical.Storage, name string) (*roleEntry, *accessPolicyEntry, error) {
+	role, err := b.roleRead(ctx, s, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if role == nil {
+		policy, err := b.accessPoliciesRead(ctx, s, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if policy == nil {
+			return nil, nil, nil
+		}
+		if policy.Role != "" {
+			return nil, nil, fmt.Errorf("%w, read creds/%s instead of creds/%s", errGeneratedAccessPolicy, policy.Role, name)
+		}
+
+		return &roleEntry{
+			AccessPolicy: name,
+			TTLJitter:    policy.TTLJitter,
+			RateLimit:    policy.RateLimit,
+			limiterKey:   "access_policies/" + name,
+		}, policy, nil
+	}
+
+	if role.AccessPolicy == "" {
+		return role, nil, nil
+	}
+
+	policy, err := b.accessPoliciesRead(ctx, s, role.AccessPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if policy == nil {
+		return nil, nil, fmt.Errorf("access policy '%s' referenced by the role does not exist", role.AccessPolicy)
+	}
+
+	return role, policy, nil
+}
+
+// retryWithRecreatedAccessPolicy creates the token again after recreating the
+// stored access policy when creating it failed because the policy was deleted
+// in grafana cloud. Returns createErr when it failed for another reason or the
+// policy still exists.
+func (b *backend) retryWithRecreatedAccessPolicy(ctx context.Context, s logical.Storage, c GrafanaClient, policyName string, createErr error, tokenReq gcom.CreateTokenRequest) (*gcom.TokenResponse, error) {
+	if !errors.Is(createErr, gcom.ErrNotFound) {
+		return nil, createErr
+	}
+
+	lock := locksutil.LockForKey(b.policyLocks, policyName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Another request may have recreated, changed or deleted the policy
+	// while the token was created
+	policy, err := b.accessPoliciesRead(ctx, s, policyName)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, createErr
+	}
+	if policy.Policy.ID != tokenReq.AccessPolicyID {
+		tokenReq.AccessPolicyID = policy.Policy.ID
+		return c.CreateToken(ctx, tokenReq)
+	}
+
+	remotePolicy, err := c.GetAccessPolicy(ctx, policy.Policy.ID)
+	if err != nil || remotePolicy != nil {
+		return nil, createErr
+	}
+
+	b.Logger().Warn("access policy was deleted in grafana cloud, recreating it", "policy", policyName, "id", policy.Policy.ID)
+	recreated, err := b.recreateAccessPolicy(ctx, s, c, policyName, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenReq.AccessPolicyID = recreated.Policy.ID
+	return c.CreateToken(ctx, tokenReq)
+}
+
+// maxTTLJitter is the highest ttl_jitter, so a jittered token lives at least
+// half of its ttl
+const maxTTLJitter = 50
+
+// applyTTLJitter randomly shortens ttl by up to percent percent, capped at
+// maxTTLJitter. The ttl is only ever shortened so the result still honors the
+// max ttl.
+func applyTTLJitter(ttl time.Duration, percent int) time.Duration {
+	if percent <= 0 || ttl <= 0 {
+		return ttl
+	}
+	if percent > maxTTLJitter {
+		percent = maxTTLJitter
+	}
+
+	maxJitter := int64(ttl) * int64(percent) / 100
+	if maxJitter <= 0 {
+		return ttl
+	}
+
+	return ttl - time.Duration(rand.Int63n(maxJitter))
+}
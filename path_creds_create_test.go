@@ -0,0 +1,540 @@
+package grafanacloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_creds_idempotency_key_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/readers",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"policy": `{"displayName": "Readers", "scopes": ["metrics:read"], "realms": [{"type": "org", "identifier": "1"}]}`,
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create access policy: resp: %#v err: %v", resp, err)
+	}
+
+	// Left behind by an earlier attempt whose lease was never returned
+	tokenName := idempotentTokenName("readers", "deploy-42", 0)
+	leaked, err := fake.CreateToken(context.Background(), gcom.CreateTokenRequest{
+		AccessPolicyID: fake.policies[firstKey(fake.policies)].ID,
+		Name:           tokenName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credsRequest := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/readers",
+		Storage:   s,
+		Data:      map[string]interface{}{"idempotency_key": "deploy-42"},
+	}
+	resp, err = b.HandleRequest(context.Background(), credsRequest)
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Equal(t, tokenName, resp.Data["name"])
+	assert.NotEqual(t, leaked.ID, resp.Data["id"])
+	assert.Len(t, fake.tokens, 1)
+
+	// The token of the key was issued, so it is neither replaced by a retry
+	// nor deleted by the rollback of the earlier attempt
+	retry, err := b.HandleRequest(context.Background(), credsRequest)
+	assert.NoError(t, err)
+	if assert.NotNil(t, retry) && assert.True(t, retry.IsError()) {
+		assert.Contains(t, retry.Error().Error(), "idempotency_key was already used")
+	}
+
+	err = b.walRollback(context.Background(), &logical.Request{Storage: s}, walIssuedTokenKind, map[string]interface{}{
+		"role":       "readers",
+		"config":     "",
+		"token_name": tokenName,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, fake.tokens, 1)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/readers",
+		Storage:   s,
+		Data:      map[string]interface{}{"idempotency_key": "deploy-43"},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Equal(t, idempotentTokenName("readers", "deploy-43", 0), resp.Data["name"])
+	assert.Len(t, fake.tokens, 2)
+}
+
+// firstKey returns a key of the map
+func firstKey[V any](m map[string]V) string {
+	for key := range m {
+		return key
+	}
+
+	return ""
+}
+
+// failingPutStorage fails to store entries under prefix
+type failingPutStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *failingPutStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, s.prefix) {
+		return fmt.Errorf("failed to store '%s'", entry.Key)
+	}
+
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestBackend_creds_batch_max_tokens_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	// The role refers to the access policy, so it is written last
+	for _, write := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"access_policies/readers", map[string]interface{}{
+			"policy": `{"displayName": "Readers", "scopes": ["metrics:read"], "realms": [{"type": "org", "identifier": "1"}]}`,
+		}},
+		{"roles/fleet", map[string]interface{}{
+			"access_policy": "readers",
+			"max_tokens":    3,
+		}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      write.path,
+			Storage:   s,
+			Data:      write.data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to write %s: resp: %#v err: %v", write.path, resp, err)
+		}
+	}
+
+	creds := func(s logical.Storage, count int) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "creds/fleet",
+			Storage:   s,
+			Data:      map[string]interface{}{"count": count},
+		})
+	}
+
+	// Tokens that can not be recorded are revoked, as their lease is never
+	// returned
+	_, err := creds(&failingPutStorage{Storage: s, prefix: issuedTokensPrefix}, 2)
+	assert.Error(t, err)
+	assert.Len(t, fake.tokens, 0)
+
+	resp, err := creds(s, 2)
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, resp.Data["tokens"], 2)
+
+	resp, err = creds(s, 2)
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Equal(t, "role 'fleet' has 2 active tokens, issuing 2 more would exceed the maximum of 3", resp.Error().Error())
+	}
+
+	resp, err = creds(s, 1)
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.tokens, 3)
+}
+
+func TestBackend_creds_recreated_access_policy_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	testAccessPolicy(t, b, s, "readers", nil)
+	deletedID := firstKey(fake.policies)
+	delete(fake.policies, deletedID)
+
+	// The policy deleted in grafana cloud is recreated once
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/readers",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, deletedID, entry.Policy.ID)
+	assert.Len(t, fake.policies, 1)
+
+	// A request that raced the recreation uses the stored policy instead of
+	// recreating it again
+	token, err := b.retryWithRecreatedAccessPolicy(context.Background(), s, fake, "readers", fmt.Errorf("access policy '%s': %w", deletedID, gcom.ErrNotFound), gcom.CreateTokenRequest{
+		AccessPolicyID: deletedID,
+		Name:           "vault-readers-raced",
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, entry.Policy.ID, token.AccessPolicyID)
+	}
+	assert.Len(t, fake.policies, 1)
+}
+
+// failingCreateTokenClient fails to create tokens
+type failingCreateTokenClient struct {
+	*fakeClient
+}
+
+func (c failingCreateTokenClient) CreateToken(ctx context.Context, body gcom.CreateTokenRequest) (*gcom.TokenResponse, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestBackend_creds_failed_wal_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+	b.newClient = func(name string, conf *accessTokenConfig) (GrafanaClient, error) {
+		return failingCreateTokenClient{fake}, nil
+	}
+
+	testAccessPolicy(t, b, s, "readers", nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/readers",
+		Storage:   s,
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Contains(t, resp.Error().Error(), "service unavailable")
+	}
+
+	walIDs, err := framework.ListWAL(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Empty(t, walIDs)
+}
+
+func TestBackend_creds_invalid_token_name_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	testAccessPolicy(t, b, s, "readers", nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/fleet",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"access_policy":       "readers",
+			"token_name_template": "Vault_{{.RoleName}}",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to write role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/fleet",
+		Storage:   s,
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Contains(t, resp.Error().Error(), "token name 'Vault_fleet' must only contain lowercase alphanumeric characters and dashes")
+	}
+	assert.Empty(t, fake.tokens)
+}
+
+func TestBackend_creds_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/readers",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"policy": `{"displayName": "Readers", "scopes": ["metrics:read"], "realms": [{"type": "org", "identifier": "1"}]}`,
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create access policy: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/readers",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.tokens, 1)
+	assert.Equal(t, "prod-test-0", resp.Data["region"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.tokens, 0)
+}
+
+func TestBackend_creds_region_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	var regions []string
+	b.newClient = func(name string, conf *accessTokenConfig) (GrafanaClient, error) {
+		regions = append(regions, conf.Region)
+		return fake, nil
+	}
+
+	policy, err := fake.CreateAccessPolicy(context.Background(), map[string]interface{}{
+		"name":   "external",
+		"scopes": []string{"metrics:write"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/eu",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"access_policy_id": policy.ID,
+			"region":           "prod-eu-west-0",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "creds/eu",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"region": "prod-us-east-0",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Equal(t, []string{"prod-eu-west-0", "prod-us-east-0"}, regions)
+	assert.Equal(t, "prod-us-east-0", resp.Secret.InternalData["region"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/managed",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"scopes": "metrics:read",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create access policy: resp: %#v err: %v", resp, err)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/managed",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"access_policy": "managed",
+			"region":        "prod-eu-west-0",
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected region to be rejected for managed access policies: resp: %#v err: %v", resp, err)
+	}
+}
+
+func TestBackend_creds_count_fake(t *testing.T) {
+	testCases := []struct {
+		name  string
+		count int
+		error string
+	}{
+		{"zero", 0, "count must be between 1 and 100"},
+		{"negative", -1, "count must be between 1 and 100"},
+		{"aboveMax", maxCredsCount + 1, "count must be between 1 and 100"},
+		{"single", 1, ""},
+		{"batch", 3, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", nil)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "creds/readers",
+				Storage:   s,
+				Data:      map[string]interface{}{"count": testCase.count},
+			})
+			if testCase.error != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+					assert.Equal(t, testCase.error, resp.Error().Error())
+				}
+				assert.Len(t, fake.tokens, 0)
+				return
+			}
+			if err != nil || resp.IsError() {
+				t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+			}
+			if testCase.count > 1 {
+				assert.Len(t, resp.Data["tokens"], testCase.count)
+			}
+			assert.Len(t, fake.tokens, testCase.count)
+
+			// The tokens of a batch share a lease and are revoked together
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.RevokeOperation,
+				Storage:   s,
+				Secret:    resp.Secret,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+			}
+			assert.Len(t, fake.tokens, 0)
+
+			active, err := b.countIssuedTokens(context.Background(), s, "readers")
+			assert.NoError(t, err)
+			assert.Zero(t, active)
+		})
+	}
+}
+
+func TestBackend_creds_reuse_window_fake(t *testing.T) {
+	testCases := []struct {
+		name        string
+		reuseWindow interface{}
+		// second is the data of the creds request following the first one
+		second map[string]interface{}
+		shared bool
+		// recreate deletes the access policy in grafana cloud after the
+		// second creds read, so the next issued token recreates it
+		recreate bool
+		error    string
+	}{
+		{"rejectsNegativeWindows", -1, nil, false, false, "cannot provide negative value"},
+		{"sharesTokensWithinTheWindow", "1h", nil, true, false, ""},
+		{"keepsSharedTokensOfRecreatedPolicies", "1h", nil, true, true, ""},
+		{"neverSharesBatches", "1h", map[string]interface{}{"count": 2}, false, false, ""},
+		{"neverSharesCustomizedTokens", "1h", map[string]interface{}{"display_name": "ci"}, false, false, ""},
+		{"neverSharesWithoutAWindow", 0, nil, false, false, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			b, s, fake := testFakeBackend(t)
+			testAccessPolicy(t, b, s, "readers", nil)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "roles/fleet",
+				Storage:   s,
+				Data: map[string]interface{}{
+					"access_policy": "readers",
+					"reuse_window":  testCase.reuseWindow,
+				},
+			})
+			if testCase.error != "" {
+				assert.NoError(t, err)
+				if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+					assert.Contains(t, resp.Error().Error(), testCase.error)
+				}
+				return
+			}
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("failed to write role: resp: %#v err: %v", resp, err)
+			}
+
+			creds := func(data map[string]interface{}) *logical.Response {
+				t.Helper()
+
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "creds/fleet",
+					Storage:   s,
+					Data:      data,
+				})
+				if err != nil || resp.IsError() {
+					t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+				}
+				return resp
+			}
+			revoke := func(resp *logical.Response) {
+				t.Helper()
+
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.RevokeOperation,
+					Storage:   s,
+					Secret:    resp.Secret,
+				})
+				if err != nil || (resp != nil && resp.IsError()) {
+					t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+				}
+			}
+
+			first := creds(nil)
+			second := creds(testCase.second)
+			if testCase.shared {
+				assert.Equal(t, first.Data["id"], second.Data["id"])
+				assert.Len(t, fake.tokens, 1)
+			} else {
+				assert.NotEqual(t, first.Data["id"], second.Data["id"])
+			}
+
+			// Tokens of a recreated access policy are no longer handed out,
+			// but still deleted with their last lease
+			if testCase.recreate {
+				entry, err := b.accessPoliciesRead(context.Background(), s, "readers")
+				if err != nil {
+					t.Fatal(err)
+				}
+				delete(fake.policies, entry.Policy.ID)
+				recreated := creds(map[string]interface{}{"display_name": "ci"})
+				fresh := creds(nil)
+				assert.NotEqual(t, first.Data["id"], fresh.Data["id"])
+				revoke(recreated)
+				revoke(fresh)
+			}
+
+			// A shared token is only deleted with its last lease
+			revoke(first)
+			if testCase.shared {
+				assert.Contains(t, fake.tokens, first.Data["id"])
+			} else {
+				assert.NotContains(t, fake.tokens, first.Data["id"])
+			}
+			revoke(second)
+			assert.Len(t, fake.tokens, 0)
+
+			// A read after the token is gone issues a new one
+			third := creds(nil)
+			assert.NotEqual(t, first.Data["id"], third.Data["id"])
+			revoke(third)
+			assert.Len(t, fake.tokens, 0)
+		})
+	}
+}
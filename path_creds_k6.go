@@ -0,0 +1,103 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCredsK6(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "creds/k6/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the k6_token role to generate a token for",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the token. Defaults to the ttl of the role or the mount and is capped at their max_ttl",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathCredsK6Read,
+			logical.UpdateOperation: b.pathCredsK6Read,
+		},
+
+		HelpSynopsis:    pathCredsK6HelpSyn,
+		HelpDescription: pathCredsK6HelpDesc,
+	}
+}
+
+func (b *backend) pathCredsK6Read(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	k6Conf, err := b.readK6Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if k6Conf == nil {
+		return logical.ErrorResponse("k6 is not configured. did you configure '%s'?", k6ConfigKey), nil
+	}
+
+	creds, errResp, err := b.resolveCredsOfType(ctx, req, d, credentialTypeK6Token)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+	role, c := creds.role, creds.client
+	defer creds.release()
+
+	tokenName, _, err := b.tokenNames(req, name, role)
+	if err != nil {
+		return logical.ErrorResponse("failed to generate token name for role '%s': %s", name, err), nil
+	}
+
+	b.Logger().Info(fmt.Sprintf("creating k6 token (role: %s, organization: %d, project: %d)...", name, k6Conf.OrganizationID, role.K6ProjectID))
+	token, err := c.CreateK6Token(ctx, k6Conf.apiURL(), k6Conf.Token, k6Conf.OrganizationID, gcom.CreateK6TokenRequest{
+		Name:      tokenName,
+		ProjectID: role.K6ProjectID,
+	})
+	if err != nil {
+		return logical.ErrorResponse("err while creating k6 token with role '%s'. err: %s", name, err), nil
+	}
+	err = b.recordIssuedCredential(ctx, req.Storage, name, strconv.Itoa(token.ID), func() error {
+		return c.DeleteK6Token(ctx, k6Conf.apiURL(), k6Conf.Token, k6Conf.OrganizationID, token.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordTokens("issued", name, 1)
+
+	resp := b.Secret(SecretK6TokenType).Response(map[string]interface{}{
+		"id":              token.ID,
+		"name":            token.Name,
+		"token":           token.Token,
+		"organization_id": k6Conf.OrganizationID,
+		"project_id":      role.K6ProjectID,
+	}, map[string]interface{}{
+		"id":              token.ID,
+		"name":            token.Name,
+		"organization_id": k6Conf.OrganizationID,
+		"role":            name,
+		"config":          role.Config,
+	})
+	resp.Secret.TTL = creds.ttl
+	resp.Secret.MaxTTL = creds.maxTTL
+	resp.Secret.Renewable = false
+
+	return resp, nil
+}
+
+const pathCredsK6HelpSyn = `Generate a k6 API token from a role`
+
+const pathCredsK6HelpDesc = `
+Creates a k6 API token in the organization configured in config/k6 for a role
+with the k6_token credential_type, restricted to the k6_project_id of the role
+when it is set, so load-testing pipelines do not need long-lived tokens. The
+token is deleted when its lease is revoked and can not be renewed.
+`
@@ -0,0 +1,69 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_k6_token_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/loadtest",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeK6Token,
+			"k6_project_id":   42,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/k6/loadtest",
+		Storage:   s,
+	})
+	if err != nil || !resp.IsError() {
+		t.Fatalf("expected config/k6 to be required: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/k6",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"token":           "k6-admin",
+			"organization_id": 7,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to configure k6: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/k6/loadtest",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.k6Tokens[7], 1)
+	assert.Equal(t, 42, resp.Data["project_id"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.k6Tokens[7], 0)
+}
@@ -0,0 +1,113 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCredsOrgAPIKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "creds/org-apikey/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the org_api_key role to generate an API key for",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the API key. Defaults to the ttl of the role or the mount and is capped at their max_ttl",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathCredsOrgAPIKeyRead,
+			logical.UpdateOperation: b.pathCredsOrgAPIKeyRead,
+		},
+
+		HelpSynopsis:    pathCredsOrgAPIKeyHelpSyn,
+		HelpDescription: pathCredsOrgAPIKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathCredsOrgAPIKeyRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	features, err := b.FeaturesConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if !features.LegacyAPIKeys {
+		return logical.ErrorResponse("issuing org API keys requires the 'legacy_api_keys' feature in config/features"), nil
+	}
+
+	creds, errResp, err := b.resolveCredsOfType(ctx, req, d, credentialTypeOrgAPIKey)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+	role, c, ttl := creds.role, creds.client, creds.ttl
+	defer creds.release()
+
+	orgSlug := c.OrgSlug()
+	if orgSlug == "" {
+		return logical.ErrorResponse("the organization of config '%s' is unknown, set its org_slug", configTokenStorageKey(role.Config)), nil
+	}
+
+	keyName, _, err := b.tokenNames(req, name, role)
+	if err != nil {
+		return logical.ErrorResponse("failed to generate API key name for role '%s': %s", name, err), nil
+	}
+
+	keyReq := gcom.CreateOrgAPIKeyRequest{
+		Name: keyName,
+		Role: role.GrafanaRole,
+	}
+	// The key also expires in Grafana Cloud in case revoking its lease fails
+	if !role.NoExpiration {
+		keyReq.SecondsToLive = int64(ttl.Seconds())
+	}
+
+	b.Logger().Info(fmt.Sprintf("creating grafana-cloud org API key (role: %s, org: %s)...", name, orgSlug))
+	key, err := c.CreateOrgAPIKey(ctx, orgSlug, keyReq)
+	if err != nil {
+		return logical.ErrorResponse("err while creating API key with role '%s' in organization '%s'. err: %s", name, orgSlug, err), nil
+	}
+	err = b.recordIssuedCredential(ctx, req.Storage, name, key.Name, func() error {
+		return c.DeleteOrgAPIKey(ctx, orgSlug, key.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	recordTokens("issued", name, 1)
+
+	resp := b.Secret(SecretOrgAPIKeyType).Response(map[string]interface{}{
+		"id":           key.ID,
+		"name":         key.Name,
+		"token":        key.Token,
+		"org_slug":     orgSlug,
+		"grafana_role": role.GrafanaRole,
+	}, map[string]interface{}{
+		"name":     key.Name,
+		"org_slug": orgSlug,
+		"role":     name,
+		"config":   role.Config,
+	})
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = creds.maxTTL
+	resp.Secret.Renewable = false
+
+	return resp, nil
+}
+
+const pathCredsOrgAPIKeyHelpSyn = `Generate a legacy Grafana Cloud API key from a role`
+
+const pathCredsOrgAPIKeyHelpDesc = `
+Creates a legacy Grafana Cloud API key in the organization of the
+configuration of a role with the org_api_key credential_type, for the
+endpoints that still do not accept access policy tokens. The key has the
+grafana_role of the role and is deleted when its lease is revoked. Requires
+the 'legacy_api_keys' feature in config/features.
+`
@@ -0,0 +1,68 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_org_api_key_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/publisher",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeOrgAPIKey,
+			"grafana_role":    "MetricsPublisher",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/org-apikey/publisher",
+		Storage:   s,
+	})
+	if err != nil || !resp.IsError() {
+		t.Fatalf("expected the legacy_api_keys feature to be required: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/features",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"legacy_api_keys": true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to enable legacy_api_keys: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/org-apikey/publisher",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.orgKeys, 1)
+	assert.Equal(t, "MetricsPublisher", resp.Data["grafana_role"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.orgKeys, 0)
+}
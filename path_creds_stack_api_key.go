@@ -0,0 +1,215 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCredsStackAPIKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "creds/stack-apikey/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the stack_api_key role to generate an API key for",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the API key. Defaults to the ttl of the role or the mount and is capped at their max_ttl",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathCredsStackAPIKeyRead,
+			logical.UpdateOperation: b.pathCredsStackAPIKeyRead,
+		},
+
+		HelpSynopsis:    pathCredsStackAPIKeyHelpSyn,
+		HelpDescription: pathCredsStackAPIKeyHelpDesc,
+	}
+}
+
+func (b *backend) pathCredsStackAPIKeyRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	creds, errResp, err := b.resolveCredsOfType(ctx, req, d, credentialTypeStackAPIKey)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+	role, c, ttl := creds.role, creds.client, creds.ttl
+
+	defer creds.release()
+
+	keyName, _, err := b.tokenNames(req, name, role)
+	if err != nil {
+		return logical.ErrorResponse("failed to generate API key name for role '%s': %s", name, err), nil
+	}
+
+	keyReq := gcom.CreateStackAPIKeyRequest{
+		Name: keyName,
+		Role: role.GrafanaRole,
+	}
+	// The key also expires in the stack in case revoking its lease fails
+	if !role.NoExpiration {
+		keyReq.SecondsToLive = int64(ttl.Seconds())
+	}
+
+	walID, err := framework.PutWAL(ctx, req.Storage, walStackCredKind, &walStackCred{
+		Role:           name,
+		Config:         role.Config,
+		CredentialType: credentialTypeStackAPIKey,
+		StackSlug:      role.StackSlug,
+		Name:           keyName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	b.Logger().Info(fmt.Sprintf("creating grafana stack API key (role: %s, stack: %s)...", name, role.StackSlug))
+	key, err := c.CreateStackAPIKey(ctx, role.StackSlug, keyReq)
+	if err != nil {
+		if walErr := framework.DeleteWAL(ctx, req.Storage, walID); walErr != nil {
+			b.Logger().Error("failed to delete WAL entry of API key that was not created", "wal_id", walID, "error", walErr)
+		}
+		return logical.ErrorResponse("err while creating API key with role '%s' in stack '%s'. err: %s", name, role.StackSlug, err), nil
+	}
+	err = b.recordIssuedCredential(ctx, req.Storage, name, strconv.Itoa(key.ID), func() error {
+		return c.DeleteStackAPIKey(ctx, role.StackSlug, key.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		b.Logger().Warn("failed to delete WAL entry of issued API key", "wal_id", walID, "error", err)
+	}
+	recordTokens("issued", name, 1)
+
+	resp := b.Secret(SecretStackAPIKeyType).Response(map[string]interface{}{
+		"id":           key.ID,
+		"name":         key.Name,
+		"key":          key.Key,
+		"stack_slug":   role.StackSlug,
+		"grafana_url":  stackGrafanaURL(role.StackSlug),
+		"grafana_role": role.GrafanaRole,
+	}, map[string]interface{}{
+		"id":         key.ID,
+		"name":       key.Name,
+		"stack_slug": role.StackSlug,
+		"role":       name,
+		"config":     role.Config,
+	})
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = creds.maxTTL
+	resp.Secret.Renewable = false
+
+	return resp, nil
+}
+
+// typedCreds holds the resolved settings of a creds request of a role issuing
+// a credential other than access policy tokens
+type typedCreds struct {
+	role   *roleEntry
+	client GrafanaClient
+	ttl    time.Duration
+	maxTTL time.Duration
+	// release unlocks the issue lock of the role held while the credential
+	// is created, so the max_tokens of the role can not be exceeded
+	release func()
+}
+
+// resolveCredsOfType reads the role of a creds request for credentials of the
+// given credential type and computes their lease. Returns an error response
+// when the request can not be served. Otherwise the caller must call release
+// once the credential is recorded.
+func (b *backend) resolveCredsOfType(ctx context.Context, req *logical.Request, d *framework.FieldData, credentialType string) (*typedCreds, *logical.Response, error) {
+	name := d.Get("name").(string)
+
+	role, err := b.roleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if role == nil {
+		return nil, logical.ErrorResponse("did not find role '%s'", name), nil
+	}
+	if role.CredentialType != credentialType {
+		return nil, logical.ErrorResponse("role '%s' issues %s credentials, not %s", name, role.credentialType(), credentialType), nil
+	}
+
+	lease, err := b.LeaseConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lease == nil {
+		lease = &configLease{}
+	}
+
+	c, err := b.configClient(ctx, req.Storage, role.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backendTTL, backendMaxTTL := lease.TTL, lease.MaxTTL
+	if role.TTL > 0 {
+		backendTTL = role.TTL
+	}
+	if role.MaxTTL > 0 {
+		backendMaxTTL = role.MaxTTL
+	}
+	requestedTTL := time.Duration(d.Get("ttl").(int)) * time.Second
+	if requestedTTL < 0 {
+		return nil, logical.ErrorResponse("ttl must not be negative"), nil
+	}
+	ttl, _, err := framework.CalculateTTL(b.System(), requestedTTL, backendTTL, 0, backendMaxTTL, 0, time.Time{})
+	if err != nil {
+		return nil, logical.ErrorResponse("failed to calculate ttl. err: %s", err), nil
+	}
+	ttl, err = enforceMinTTL(requestedTTL, ttl, minLeaseTTL(lease, role))
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Only requests that would be served count against the limit
+	if !b.allowCreds(role.limiterKey, role.RateLimit) {
+		return nil, nil, fmt.Errorf("too many credentials requested for '%s', limit is %d per minute: %w", name, role.RateLimit, logical.ErrRateLimitQuotaExceeded)
+	}
+
+	release := func() {}
+	if role.MaxTokens > 0 {
+		lock := locksutil.LockForKey(b.issueLocks, name)
+		lock.Lock()
+		release = lock.Unlock
+
+		active, err := b.countIssuedTokens(ctx, req.Storage, name)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		if active+1 > role.MaxTokens {
+			release()
+			return nil, logical.ErrorResponse("role '%s' has %d active tokens, issuing %d more would exceed the maximum of %d", name, active, 1, role.MaxTokens), nil
+		}
+	}
+
+	return &typedCreds{
+		role:    role,
+		client:  c,
+		ttl:     ttl,
+		maxTTL:  backendMaxTTL,
+		release: release,
+	}, nil, nil
+}
+
+const pathCredsStackAPIKeyHelpSyn = `Generate a Grafana API key of a stack from a role`
+
+const pathCredsStackAPIKeyHelpDesc = `
+Creates a Grafana API key in the stack of a role with the stack_api_key
+credential_type, for automation that talks to the Grafana HTTP API of the
+stack. The key has the grafana_role of the role and is deleted when its lease
+is revoked. Keys can not be renewed.
+`
@@ -0,0 +1,99 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_stack_api_key_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/dashboards",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeStackAPIKey,
+			"stack_slug":      "mystack",
+			"grafana_role":    "Editor",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/stack-apikey/dashboards",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.stackKeys["mystack"], 1)
+	assert.Equal(t, "Editor", resp.Data["grafana_role"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.stackKeys["mystack"], 0)
+}
+
+func TestBackend_stack_api_key_max_tokens_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/dashboards",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeStackAPIKey,
+			"stack_slug":      "mystack",
+			"grafana_role":    "Viewer",
+			"max_tokens":      1,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	credsRequest := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/stack-apikey/dashboards",
+		Storage:   s,
+	}
+	issued, err := b.HandleRequest(context.Background(), credsRequest)
+	if err != nil || issued.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", issued, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), credsRequest)
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Contains(t, resp.Error().Error(), "has 1 active tokens")
+	}
+	assert.Len(t, fake.stackKeys["mystack"], 1)
+
+	// Revoking the key frees its slot
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    issued.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+	resp, err = b.HandleRequest(context.Background(), credsRequest)
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.stackKeys["mystack"], 1)
+}
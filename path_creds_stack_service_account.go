@@ -0,0 +1,148 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCredsStackServiceAccount(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "creds/stack-service-account/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the stack_service_account role to generate a service account for",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the service account. Defaults to the ttl of the role or the mount and is capped at their max_ttl",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathCredsStackServiceAccountRead,
+			logical.UpdateOperation: b.pathCredsStackServiceAccountRead,
+		},
+
+		HelpSynopsis:    pathCredsStackServiceAccountHelpSyn,
+		HelpDescription: pathCredsStackServiceAccountHelpDesc,
+	}
+}
+
+func (b *backend) pathCredsStackServiceAccountRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	creds, errResp, err := b.resolveCredsOfType(ctx, req, d, credentialTypeStackServiceAccount)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+	role, c, ttl := creds.role, creds.client, creds.ttl
+
+	defer creds.release()
+
+	accountName, _, err := b.tokenNames(req, name, role)
+	if err != nil {
+		return logical.ErrorResponse("failed to generate service account name for role '%s': %s", name, err), nil
+	}
+
+	walID, err := framework.PutWAL(ctx, req.Storage, walStackCredKind, &walStackCred{
+		Role:           name,
+		Config:         role.Config,
+		CredentialType: credentialTypeStackServiceAccount,
+		StackSlug:      role.StackSlug,
+		Name:           accountName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error writing WAL entry: %w", err)
+	}
+	// The WAL entry is deleted once the service account is either recorded
+	// or deleted again, otherwise the rollback looks it up by name
+	deleteWAL := func() {
+		if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+			b.Logger().Warn("failed to delete WAL entry of stack service account", "wal_id", walID, "error", err)
+		}
+	}
+
+	b.Logger().Info(fmt.Sprintf("creating grafana stack service account (role: %s, stack: %s)...", name, role.StackSlug))
+	account, err := c.CreateStackServiceAccount(ctx, role.StackSlug, gcom.CreateStackServiceAccountRequest{
+		Name: accountName,
+		Role: role.GrafanaRole,
+	})
+	if err != nil {
+		deleteWAL()
+		return logical.ErrorResponse("err while creating service account with role '%s' in stack '%s'. err: %s", name, role.StackSlug, err), nil
+	}
+
+	for _, roleUID := range role.GrafanaRBACRoles {
+		if err := c.AssignStackRBACRole(ctx, role.StackSlug, account.ID, roleUID); err != nil {
+			if deleteErr := c.DeleteStackServiceAccount(ctx, role.StackSlug, account.ID); deleteErr != nil {
+				b.Logger().Error("failed to delete service account after assigning its roles failed", "stack", role.StackSlug, "id", account.ID, "error", deleteErr)
+			} else {
+				deleteWAL()
+			}
+			return logical.ErrorResponse("err while granting role '%s' to service account '%s' in stack '%s'. err: %s", roleUID, account.Name, role.StackSlug, err), nil
+		}
+	}
+
+	tokenReq := gcom.CreateStackServiceAccountTokenRequest{
+		Name: accountName,
+	}
+	// The token also expires in the stack in case revoking its lease fails
+	if !role.NoExpiration {
+		tokenReq.SecondsToLive = int64(ttl.Seconds())
+	}
+	token, err := c.CreateStackServiceAccountToken(ctx, role.StackSlug, account.ID, tokenReq)
+	if err != nil {
+		if deleteErr := c.DeleteStackServiceAccount(ctx, role.StackSlug, account.ID); deleteErr != nil {
+			b.Logger().Error("failed to delete service account after token creation failed", "stack", role.StackSlug, "id", account.ID, "error", deleteErr)
+		} else {
+			deleteWAL()
+		}
+		return logical.ErrorResponse("err while creating token of service account '%s' in stack '%s'. err: %s", account.Name, role.StackSlug, err), nil
+	}
+	err = b.recordIssuedCredential(ctx, req.Storage, name, strconv.Itoa(account.ID), func() error {
+		return c.DeleteStackServiceAccount(ctx, role.StackSlug, account.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	deleteWAL()
+	recordTokens("issued", name, 1)
+
+	resp := b.Secret(SecretStackServiceAccountType).Response(map[string]interface{}{
+		"service_account_id":    account.ID,
+		"service_account_name":  account.Name,
+		"service_account_login": account.Login,
+		"token_id":              token.ID,
+		"token":                 token.Key,
+		"stack_slug":            role.StackSlug,
+		"grafana_url":           stackGrafanaURL(role.StackSlug),
+		"grafana_role":          role.GrafanaRole,
+		"grafana_rbac_roles":    role.GrafanaRBACRoles,
+	}, map[string]interface{}{
+		"service_account_id": account.ID,
+		"name":               account.Name,
+		"stack_slug":         role.StackSlug,
+		"role":               name,
+		"config":             role.Config,
+	})
+	resp.Secret.TTL = ttl
+	resp.Secret.MaxTTL = creds.maxTTL
+	resp.Secret.Renewable = false
+
+	return resp, nil
+}
+
+const pathCredsStackServiceAccountHelpSyn = `Generate a Grafana service account of a stack from a role`
+
+const pathCredsStackServiceAccountHelpDesc = `
+Creates a Grafana service account in the stack of a role with the
+stack_service_account credential_type and returns it along with a token. The
+service account has the grafana_role of the role, along with the Grafana RBAC
+roles in its grafana_rbac_roles, and is deleted, along with its token, when
+its lease is revoked. Service accounts can not be renewed.
+`
@@ -0,0 +1,50 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_stack_service_account_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/automation",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"credential_type":    credentialTypeStackServiceAccount,
+			"stack_slug":         "mystack",
+			"grafana_rbac_roles": "fixed:dashboards:reader",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/stack-service-account/automation",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.serviceAccounts["mystack"], 1)
+	assert.Equal(t, "Viewer", resp.Data["grafana_role"])
+	assert.Equal(t, "glsa_fake", resp.Data["token"])
+	assert.Equal(t, map[int][]string{resp.Data["service_account_id"].(int): {"fixed:dashboards:reader"}}, fake.rbacRoles)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.serviceAccounts["mystack"], 0)
+}
@@ -0,0 +1,169 @@
+package grafanacloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCredsSyntheticMonitoring(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "creds/synthetic-monitoring/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the synthetic_monitoring role to generate a token for",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the token. Defaults to the ttl of the role or the mount and is capped at their max_ttl",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathCredsSyntheticMonitoringRead,
+			logical.UpdateOperation: b.pathCredsSyntheticMonitoringRead,
+		},
+
+		HelpSynopsis:    pathCredsSyntheticMonitoringHelpSyn,
+		HelpDescription: pathCredsSyntheticMonitoringHelpDesc,
+	}
+}
+
+func (b *backend) pathCredsSyntheticMonitoringRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	creds, errResp, err := b.resolveCredsOfType(ctx, req, d, credentialTypeSyntheticMonitoring)
+	if errResp != nil || err != nil {
+		return errResp, err
+	}
+	role, c := creds.role, creds.client
+	defer creds.release()
+
+	b.Logger().Info(fmt.Sprintf("creating synthetic monitoring token (role: %s, stack: %s)...", name, role.StackSlug))
+	smURL, accessToken, err := installSyntheticMonitoring(ctx, c, role.StackSlug, role.SyntheticMonitoringURL)
+	if err != nil {
+		return logical.ErrorResponse("err while installing synthetic monitoring in stack '%s'. err: %s", role.StackSlug, err), nil
+	}
+	token, err := c.CreateSyntheticMonitoringToken(ctx, smURL, accessToken)
+	if err != nil {
+		return logical.ErrorResponse("err while creating synthetic monitoring token with role '%s' in stack '%s'. err: %s", name, role.StackSlug, err), nil
+	}
+
+	// Synthetic Monitoring tokens have no name to look them up by, so the
+	// WAL entry can only be written once the token exists
+	walID, err := framework.PutWAL(ctx, req.Storage, walSyntheticMonitoringKind, &walSyntheticMonitoringToken{
+		Role:      name,
+		Config:    role.Config,
+		StackSlug: role.StackSlug,
+		APIURL:    smURL,
+		Token:     token,
+	})
+	if err != nil {
+		if deleteErr := c.DeleteSyntheticMonitoringToken(ctx, smURL, accessToken, token); deleteErr != nil {
+			b.Logger().Error("failed to delete synthetic monitoring token after writing its WAL entry failed", "stack", role.StackSlug, "error", deleteErr)
+		}
+		return nil, fmt.Errorf("error writing WAL entry: %w", err)
+	}
+	err = b.recordIssuedCredential(ctx, req.Storage, name, syntheticMonitoringTokenID(token), func() error {
+		return c.DeleteSyntheticMonitoringToken(ctx, smURL, accessToken, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		b.Logger().Warn("failed to delete WAL entry of issued synthetic monitoring token", "wal_id", walID, "error", err)
+	}
+	recordTokens("issued", name, 1)
+
+	resp := b.Secret(SecretSyntheticMonitoringType).Response(map[string]interface{}{
+		"token":       token,
+		"api_url":     smURL,
+		"stack_slug":  role.StackSlug,
+		"grafana_url": stackGrafanaURL(role.StackSlug),
+	}, map[string]interface{}{
+		"token":        token,
+		"api_url":      smURL,
+		"access_token": accessToken,
+		"stack_slug":   role.StackSlug,
+		"role":         name,
+		"config":       role.Config,
+	})
+	resp.Secret.TTL = creds.ttl
+	resp.Secret.MaxTTL = creds.maxTTL
+	resp.Secret.Renewable = false
+
+	return resp, nil
+}
+
+// installSyntheticMonitoring installs Synthetic Monitoring in the stack,
+// which returns the existing tenant when it already is, and returns the url
+// of its api along with an access token to manage its tokens. The url is
+// looked up from the region of the stack unless smURL is set.
+func installSyntheticMonitoring(ctx context.Context, c GrafanaClient, stackSlug string, smURL string) (string, string, error) {
+	stack, err := c.GetStack(ctx, stackSlug)
+	if err != nil {
+		return "", "", err
+	}
+	if stack == nil {
+		return "", "", fmt.Errorf("stack '%s' does not exist", stackSlug)
+	}
+	if smURL == "" {
+		smURL = gcom.SyntheticMonitoringURL(stack.RegionSlug)
+	}
+	if smURL == "" {
+		return "", "", fmt.Errorf("the synthetic monitoring api of region '%s' is unknown, set synthetic_monitoring_url on the role", stack.RegionSlug)
+	}
+
+	installation, err := c.InstallSyntheticMonitoring(ctx, smURL, gcom.InstallSyntheticMonitoringRequest{
+		StackID:           stack.ID,
+		MetricsInstanceID: stack.HmInstancePromID,
+		LogsInstanceID:    stack.HlInstanceID,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return smURL, installation.AccessToken, nil
+}
+
+// syntheticMonitoringTokenID returns the id a Synthetic Monitoring token is
+// recorded under in the issued token index, which must not hold the token
+// itself
+func syntheticMonitoringTokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// deleteSyntheticMonitoringToken deletes a Synthetic Monitoring token with
+// the access token of the installation it was created with, installing
+// Synthetic Monitoring again only when that one is unknown or rejected
+func deleteSyntheticMonitoringToken(ctx context.Context, c GrafanaClient, stackSlug string, smURL string, accessToken string, token string) error {
+	if accessToken != "" && smURL != "" {
+		if err := c.DeleteSyntheticMonitoringToken(ctx, smURL, accessToken, token); err == nil {
+			return nil
+		}
+	}
+
+	smURL, accessToken, err := installSyntheticMonitoring(ctx, c, stackSlug, smURL)
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteSyntheticMonitoringToken(ctx, smURL, accessToken, token)
+}
+
+const pathCredsSyntheticMonitoringHelpSyn = `Generate a Synthetic Monitoring API token from a role`
+
+const pathCredsSyntheticMonitoringHelpDesc = `
+Creates a Synthetic Monitoring API token in the stack of a role with the
+synthetic_monitoring credential_type, for Synthetic Monitoring agents and
+Terraform providers. Synthetic Monitoring is installed in the stack first if
+it is not already, which requires the configured token to have the
+stacks:read, metrics:write, logs:write and traces:write scopes. The token is
+deleted when its lease is revoked and can not be renewed.
+`
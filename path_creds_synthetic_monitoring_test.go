@@ -0,0 +1,53 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_synthetic_monitoring_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/probes",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeSyntheticMonitoring,
+			"stack_slug":      "mystack",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/synthetic-monitoring/probes",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.smTokens["sm-access-1"], 1)
+	assert.Equal(t, "https://synthetic-monitoring-api.grafana.net", resp.Data["api_url"])
+	assert.Equal(t, 1, fake.smInstalls)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   s,
+		Secret:    resp.Secret,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to revoke creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.smTokens["sm-access-1"], 0)
+	// The access token of the lease is reused instead of installing again
+	assert.Equal(t, 1, fake.smInstalls)
+	count, err := b.countIssuedTokens(context.Background(), s, "probes")
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,853 @@
+package grafanacloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const rolesPrefix = "roles/"
+
+const (
+	// credentialTypeAccessPolicyToken issues access policy tokens through the
+	// Grafana Cloud API
+	credentialTypeAccessPolicyToken = "access_policy_token"
+	// credentialTypeStackAPIKey issues Grafana API keys of a stack through
+	// creds/stack-apikey/:name
+	credentialTypeStackAPIKey = "stack_api_key"
+	// credentialTypeStackServiceAccount issues Grafana service accounts of a
+	// stack along with a token through creds/stack-service-account/:name
+	credentialTypeStackServiceAccount = "stack_service_account"
+	// credentialTypeOrgAPIKey issues legacy Grafana Cloud API keys of the
+	// organization through creds/org-apikey/:name
+	credentialTypeOrgAPIKey = "org_api_key"
+	// credentialTypeSyntheticMonitoring issues Synthetic Monitoring api tokens
+	// of a stack through creds/synthetic-monitoring/:name
+	credentialTypeSyntheticMonitoring = "synthetic_monitoring"
+	// credentialTypeK6Token issues k6 api tokens of the organization in
+	// config/k6 through creds/k6/:name
+	credentialTypeK6Token = "k6_token"
+)
+
+// supportedCredentialTypes are the credential types roles can issue
+var supportedCredentialTypes = []string{
+	credentialTypeAccessPolicyToken,
+	credentialTypeStackAPIKey,
+	credentialTypeStackServiceAccount,
+	credentialTypeOrgAPIKey,
+	credentialTypeSyntheticMonitoring,
+	credentialTypeK6Token,
+}
+
+// grafanaRoles are the Grafana organization roles stack API keys and service
+// accounts can have
+var grafanaRoles = []string{"Viewer", "Editor", "Admin"}
+
+// cloudAPIKeyRoles are the roles legacy Grafana Cloud API keys can have
+var cloudAPIKeyRoles = []string{"Viewer", "MetricsPublisher", "PluginPublisher", "Editor", "Admin"}
+
+// regionRegex matches Grafana Cloud region slugs like prod-us-central-0
+var regionRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func pathListRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleList,
+		},
+
+		HelpSynopsis:    pathListRolesHelpSyn,
+		HelpDescription: pathListRolesHelpDesc,
+	}
+}
+
+func pathRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Role Name",
+				},
+			},
+			"credential_type": {
+				Type:        framework.TypeString,
+				Description: "Kind of credential issued by the role",
+				Default:     credentialTypeAccessPolicyToken,
+				AllowedValues: []interface{}{
+					credentialTypeAccessPolicyToken,
+					credentialTypeStackAPIKey,
+					credentialTypeStackServiceAccount,
+					credentialTypeOrgAPIKey,
+					credentialTypeSyntheticMonitoring,
+					credentialTypeK6Token,
+				},
+			},
+			"stack_slug": {
+				Type:        framework.TypeString,
+				Description: "Slug of the stack credentials are issued in. Required for the stack_api_key, stack_service_account and synthetic_monitoring credential_types, and for bundle and products",
+			},
+			"products": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Products (metrics, logs, traces or profiles) of the stack stack_slug issued tokens have access to. An access policy scoped to the stack is generated from them as access_policies/role-<name>. Mutually exclusive with access_policy, access_policy_id and access_policy_template",
+			},
+			"product_access": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Access (read, write) granted to the products. Defaults to write",
+			},
+			"bundle": {
+				Type:        framework.TypeString,
+				Description: "Connection details of the stack stack_slug added to issued access policy tokens: traces for the Tempo endpoint, otlp for the OTLP gateway and its basic auth header, profiles for the Pyroscope endpoint, or alerting for the ruler and alertmanager endpoints. The access policy should grant the matching write scope",
+			},
+			"region": {
+				Type:        framework.TypeString,
+				Description: "Grafana Cloud region, like prod-eu-west-0, tokens are issued in instead of the region of the configured token. Requires access_policy_id or access_policy_template, as access policies managed by this mount live in the region of their config",
+			},
+			"synthetic_monitoring_url": {
+				Type:        framework.TypeString,
+				Description: "URL of the Synthetic Monitoring API of the stack for the synthetic_monitoring credential_type. Defaults to the one serving the region of the stack",
+			},
+			"grafana_rbac_roles": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "UIDs of Grafana RBAC roles, like 'fixed:dashboards:reader', granted to the service accounts issued by the stack_service_account credential_type in addition to grafana_role",
+			},
+			"k6_project_id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the k6 project tokens of the k6_token credential_type are restricted to. Tokens have access to the whole k6 organization when unset",
+			},
+			"grafana_role": {
+				Type:        framework.TypeString,
+				Description: "Role of issued stack API keys and service accounts (Viewer, Editor or Admin) or org API keys (Viewer, MetricsPublisher, PluginPublisher, Editor or Admin). Defaults to Viewer",
+			},
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ tokens are issued with. Roles referencing an access_policy use the configuration of the policy. Uses config/token when empty",
+			},
+			"access_policy": {
+				Type:        framework.TypeString,
+				Description: "Name of an access policy managed under access_policies/ to issue tokens for",
+			},
+			"access_policy_id": {
+				Type:        framework.TypeString,
+				Description: "Grafana Cloud ID of the access policy to issue tokens for. Mutually exclusive with access_policy",
+			},
+			"access_policy_template": {
+				Type:        framework.TypeString,
+				Description: "JSON access policy with identity template placeholders such as {{identity.entity.name}}. A policy is created from it for every issued token. Requires the ephemeral_policies feature",
+			},
+			"display_name": {
+				Type:        framework.TypeString,
+				Description: "Display name of issued tokens. Defaults to the generated token name",
+			},
+			"token_name_template": {
+				Type:        framework.TypeString,
+				Description: "Template for the name of issued tokens, e.g. 'vault-{{ .RoleName }}-{{ unix_time_nano }}'. Available variables are RoleName, MountAccessor, EntityID, EntityName and DisplayName. Names of access policy tokens may only contain lowercase alphanumeric characters and dashes",
+			},
+			"display_name_template": {
+				Type:        framework.TypeString,
+				Description: "Template for the display name of issued tokens. Takes precedence over display_name and has the same variables as token_name_template",
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Static key/value metadata rendered into the display name of issued tokens and returned with the credentials, e.g. 'app=billing,env=prod'",
+			},
+			"allowed_scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Scopes the access policy of the role may grant, e.g. 'metrics:read' or 'logs:*'. Tokens are not issued when the policy grants any other scope. Unrestricted when empty",
+			},
+			"bound_cidrs": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "CIDRs issued tokens may be used from. Enforced by Grafana Cloud through the allowedSubnets condition of the access policy created from access_policy_template",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Default lease for issued tokens. Defaults to the ttl of config/lease",
+			},
+			"max_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Maximum lease for issued tokens. Defaults to the max_ttl of config/lease",
+			},
+			"min_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Shortest lease tokens may be requested for. The min_ttl of config/lease applies when it is longer",
+			},
+			"ttl_jitter": {
+				Type:        framework.TypeInt,
+				Description: "Percentage (0-50) of the TTL to randomly shave off each issued token so tokens issued together do not expire together",
+			},
+			"rate_limit": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of credentials issued per minute for this role. Unlimited when 0",
+			},
+			"max_tokens": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of tokens with an outstanding lease for this role. Unlimited when 0",
+			},
+			"reuse_window": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Return the token issued by an earlier creds read within this window instead of creating a new one. Disabled when 0",
+			},
+			"no_expiration": {
+				Type:        framework.TypeBool,
+				Description: "Issue tokens that do not expire in Grafana Cloud. They are still deleted when their lease is revoked",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathRoleRead,
+			logical.CreateOperation: b.pathRoleWrite,
+			logical.UpdateOperation: b.pathRoleWrite,
+			logical.DeleteOperation: b.pathRoleDelete,
+		},
+
+		ExistenceCheck: b.roleExistenceCheck,
+
+		HelpSynopsis:    pathRolesHelpSyn,
+		HelpDescription: pathRolesHelpDesc,
+	}
+}
+
+func (b *backend) roleExistenceCheck(ctx context.Context, req *logical.Request, d *framework.FieldData) (bool, error) {
+	role, err := b.roleRead(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return role != nil, nil
+}
+
+func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, rolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing role name"), nil
+	}
+
+	role, err := b.roleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: role.toResponseData(),
+	}, nil
+}
+
+func (b *backend) pathRoleWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing role name"), nil
+	}
+
+	role, err := b.roleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		role = &roleEntry{}
+	}
+
+	if credentialType, ok := d.GetOk("credential_type"); ok {
+		role.CredentialType = credentialType.(string)
+	} else if role.CredentialType == "" {
+		role.CredentialType = d.Get("credential_type").(string)
+	}
+	if !slices.Contains(supportedCredentialTypes, role.CredentialType) {
+		return logical.ErrorResponse("unsupported credential_type '%s', must be one of: %s", role.CredentialType, strings.Join(supportedCredentialTypes, ", ")), nil
+	}
+
+	if config, ok := d.GetOk("config"); ok {
+		role.Config = config.(string)
+	}
+	if stackSlug, ok := d.GetOk("stack_slug"); ok {
+		role.StackSlug = stackSlug.(string)
+	}
+	if region, ok := d.GetOk("region"); ok {
+		role.Region = region.(string)
+	}
+	if smURL, ok := d.GetOk("synthetic_monitoring_url"); ok {
+		role.SyntheticMonitoringURL = smURL.(string)
+	}
+	hadProducts, previousPolicy := len(role.Products) > 0, role.AccessPolicy
+	if products, ok := d.GetOk("products"); ok {
+		role.Products = products.([]string)
+	}
+	if productAccess, ok := d.GetOk("product_access"); ok {
+		role.ProductAccess = productAccess.([]string)
+	}
+	if bundle, ok := d.GetOk("bundle"); ok {
+		role.Bundle = bundle.(string)
+	}
+	if rbacRoles, ok := d.GetOk("grafana_rbac_roles"); ok {
+		role.GrafanaRBACRoles = rbacRoles.([]string)
+	}
+	if projectID, ok := d.GetOk("k6_project_id"); ok {
+		role.K6ProjectID = projectID.(int)
+	}
+	if grafanaRole, ok := d.GetOk("grafana_role"); ok {
+		role.GrafanaRole = grafanaRole.(string)
+	}
+	if accessPolicy, ok := d.GetOk("access_policy"); ok {
+		role.AccessPolicy = accessPolicy.(string)
+	}
+	if accessPolicyID, ok := d.GetOk("access_policy_id"); ok {
+		role.AccessPolicyID = accessPolicyID.(string)
+	}
+	if template, ok := d.GetOk("access_policy_template"); ok {
+		role.AccessPolicyTemplate = template.(string)
+	}
+	if displayName, ok := d.GetOk("display_name"); ok {
+		role.DisplayName = displayName.(string)
+	}
+	if tmpl, ok := d.GetOk("token_name_template"); ok {
+		role.TokenNameTemplate = tmpl.(string)
+	}
+	if tmpl, ok := d.GetOk("display_name_template"); ok {
+		role.DisplayNameTemplate = tmpl.(string)
+	}
+	if metadata, ok := d.GetOk("metadata"); ok {
+		role.Metadata = metadata.(map[string]string)
+	}
+	if allowedScopes, ok := d.GetOk("allowed_scopes"); ok {
+		role.AllowedScopes = allowedScopes.([]string)
+	}
+	if boundCIDRs, ok := d.GetOk("bound_cidrs"); ok {
+		role.BoundCIDRs = boundCIDRs.([]string)
+	}
+	if ttl, ok := d.GetOk("ttl"); ok {
+		role.TTL = time.Duration(ttl.(int)) * time.Second
+	}
+	if maxTTL, ok := d.GetOk("max_ttl"); ok {
+		role.MaxTTL = time.Duration(maxTTL.(int)) * time.Second
+	}
+	if minTTL, ok := d.GetOk("min_ttl"); ok {
+		role.MinTTL = time.Duration(minTTL.(int)) * time.Second
+	}
+	if ttlJitter, ok := d.GetOk("ttl_jitter"); ok {
+		role.TTLJitter = ttlJitter.(int)
+	}
+	if rateLimit, ok := d.GetOk("rate_limit"); ok {
+		role.RateLimit = rateLimit.(int)
+	}
+	if maxTokens, ok := d.GetOk("max_tokens"); ok {
+		role.MaxTokens = maxTokens.(int)
+	}
+	if reuseWindow, ok := d.GetOk("reuse_window"); ok {
+		role.ReuseWindow = time.Duration(reuseWindow.(int)) * time.Second
+	}
+	if noExpiration, ok := d.GetOk("no_expiration"); ok {
+		role.NoExpiration = noExpiration.(bool)
+	}
+
+	// The access policy generated from the products takes the place of
+	// access_policy, and is dropped along with them
+	_, accessPolicyChanged := d.GetOk("access_policy")
+	if len(role.Products) > 0 {
+		if accessPolicyChanged {
+			return logical.ErrorResponse("products and access_policy are mutually exclusive"), nil
+		}
+		if role.AccessPolicy == "" {
+			role.AccessPolicy = generatedAccessPolicyName(name)
+		}
+	} else if hadProducts && !accessPolicyChanged {
+		role.AccessPolicy = ""
+	}
+
+	policySources := 0
+	for _, source := range []string{role.AccessPolicy, role.AccessPolicyID, role.AccessPolicyTemplate} {
+		if source != "" {
+			policySources++
+		}
+	}
+	if role.credentialType() == credentialTypeAccessPolicyToken {
+		if policySources != 1 {
+			return logical.ErrorResponse("exactly one of products, access_policy, access_policy_id or access_policy_template is required"), nil
+		}
+		if role.Bundle != "" {
+			if !slices.Contains(supportedBundles, role.Bundle) {
+				return logical.ErrorResponse("unsupported bundle '%s', must be one of: %s", role.Bundle, strings.Join(supportedBundles, ", ")), nil
+			}
+			if role.StackSlug == "" {
+				return logical.ErrorResponse("stack_slug is required for bundle %s", role.Bundle), nil
+			}
+		}
+	} else {
+		if role.Bundle != "" {
+			return logical.ErrorResponse("bundle can only be used with credential_type %s", credentialTypeAccessPolicyToken), nil
+		}
+		if policySources > 0 {
+			return logical.ErrorResponse("products, access_policy, access_policy_id and access_policy_template can not be used with credential_type %s", role.CredentialType), nil
+		}
+		if role.issuesStackCredentials() && role.StackSlug == "" {
+			return logical.ErrorResponse("stack_slug is required for credential_type %s", role.CredentialType), nil
+		}
+
+		if allowedRoles := role.allowedGrafanaRoles(); len(allowedRoles) > 0 {
+			if role.GrafanaRole == "" {
+				role.GrafanaRole = allowedRoles[0]
+			}
+			if !slices.Contains(allowedRoles, role.GrafanaRole) {
+				return logical.ErrorResponse("grafana_role must be one of: %s", strings.Join(allowedRoles, ", ")), nil
+			}
+		} else if role.GrafanaRole != "" {
+			return logical.ErrorResponse("grafana_role can not be used with credential_type %s", role.CredentialType), nil
+		}
+	}
+	if role.Region != "" {
+		if err := role.validateRegion(role.Region); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if len(role.GrafanaRBACRoles) > 0 && role.CredentialType != credentialTypeStackServiceAccount {
+		return logical.ErrorResponse("grafana_rbac_roles can only be used with credential_type %s", credentialTypeStackServiceAccount), nil
+	}
+	for _, roleUID := range role.GrafanaRBACRoles {
+		if strings.TrimSpace(roleUID) == "" {
+			return logical.ErrorResponse("grafana_rbac_roles can not contain empty role UIDs"), nil
+		}
+	}
+	if role.K6ProjectID != 0 {
+		if role.CredentialType != credentialTypeK6Token {
+			return logical.ErrorResponse("k6_project_id can only be used with credential_type %s", credentialTypeK6Token), nil
+		}
+		if role.K6ProjectID < 0 {
+			return logical.ErrorResponse("k6_project_id must be a positive k6 project id"), nil
+		}
+	}
+	if role.SyntheticMonitoringURL != "" {
+		if role.CredentialType != credentialTypeSyntheticMonitoring {
+			return logical.ErrorResponse("synthetic_monitoring_url can only be used with credential_type %s", credentialTypeSyntheticMonitoring), nil
+		}
+		if _, err := url.ParseRequestURI(role.SyntheticMonitoringURL); err != nil {
+			return logical.ErrorResponse("invalid synthetic_monitoring_url: %s", err), nil
+		}
+	}
+	if len(role.Products) > 0 {
+		if role.StackSlug == "" {
+			return logical.ErrorResponse("stack_slug is required for products"), nil
+		}
+		if len(role.ProductAccess) == 0 {
+			role.ProductAccess = []string{"write"}
+		}
+	} else if len(role.ProductAccess) > 0 {
+		return logical.ErrorResponse("product_access can only be used with products"), nil
+	}
+	if role.AccessPolicyTemplate != "" {
+		if err := validateAccessPolicyTemplate(role.AccessPolicyTemplate); err != nil {
+			return logical.ErrorResponse("invalid access_policy_template: %s", err), nil
+		}
+	}
+	if role.TokenNameTemplate != "" {
+		if err := validateTokenNameTemplate(role.TokenNameTemplate); err != nil {
+			return logical.ErrorResponse("invalid token_name_template: %s", err), nil
+		}
+	}
+	if role.DisplayNameTemplate != "" {
+		if err := validateTokenNameTemplate(role.DisplayNameTemplate); err != nil {
+			return logical.ErrorResponse("invalid display_name_template: %s", err), nil
+		}
+	}
+	if len(role.BoundCIDRs) > 0 {
+		if role.AccessPolicyTemplate == "" {
+			return logical.ErrorResponse("bound_cidrs can only be used with an access_policy_template"), nil
+		}
+		for _, cidr := range role.BoundCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return logical.ErrorResponse("invalid bound_cidrs entry '%s': %s", cidr, err), nil
+			}
+		}
+	}
+	if role.MaxTTL > 0 && role.TTL > role.MaxTTL {
+		return logical.ErrorResponse("ttl cannot be greater than max_ttl"), nil
+	}
+	if role.MinTTL < 0 {
+		return logical.ErrorResponse("min_ttl must not be negative"), nil
+	}
+	if role.TTL > 0 && role.MinTTL > role.TTL {
+		return logical.ErrorResponse("min_ttl cannot be greater than ttl"), nil
+	}
+	if role.MaxTTL > 0 && role.MinTTL > role.MaxTTL {
+		return logical.ErrorResponse("min_ttl cannot be greater than max_ttl"), nil
+	}
+	if role.TTLJitter < 0 || role.TTLJitter > maxTTLJitter {
+		return logical.ErrorResponse("ttl_jitter must be between 0 and %d, got %d", maxTTLJitter, role.TTLJitter), nil
+	}
+	if role.RateLimit < 0 {
+		return logical.ErrorResponse("rate_limit must not be negative, got %d", role.RateLimit), nil
+	}
+	if role.ReuseWindow < 0 {
+		return logical.ErrorResponse("reuse_window must not be negative"), nil
+	}
+	if role.MaxTokens < 0 {
+		return logical.ErrorResponse("max_tokens must not be negative, got %d", role.MaxTokens), nil
+	}
+
+	var scopes []string
+	if len(role.Products) > 0 {
+		scopes, err = productScopes(role.Products, role.ProductAccess)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	} else if role.AccessPolicy != "" {
+		policy, err := b.accessPoliciesRead(ctx, req.Storage, role.AccessPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			return logical.ErrorResponse("access policy '%s' does not exist", role.AccessPolicy), nil
+		}
+		if role.Config != "" && role.Config != policy.Config {
+			return logical.ErrorResponse("config '%s' does not match the config '%s' of access policy '%s'", role.Config, policy.Config, role.AccessPolicy), nil
+		}
+		scopes = policy.Policy.Scopes
+	}
+
+	// Policies managed outside of Vault, e.g. by terraform, are only checked
+	// when the id or the allowed scopes change
+	_, idChanged := d.GetOk("access_policy_id")
+	_, scopesChanged := d.GetOk("allowed_scopes")
+	if role.AccessPolicyID != "" && (idChanged || (scopesChanged && len(role.AllowedScopes) > 0)) {
+		c, err := b.regionClient(ctx, req.Storage, role.Config, role.Region)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := c.GetAccessPolicy(ctx, role.AccessPolicyID)
+		if err != nil {
+			return logical.ErrorResponse("failed to read access policy '%s' from grafana cloud: %s", role.AccessPolicyID, err), nil
+		}
+		if policy == nil {
+			return logical.ErrorResponse("access policy '%s' does not exist in grafana cloud", role.AccessPolicyID), nil
+		}
+		scopes = policy.Scopes
+	}
+
+	if role.AccessPolicyTemplate != "" {
+		var policy map[string]interface{}
+		if err := json.Unmarshal([]byte(role.AccessPolicyTemplate), &policy); err != nil {
+			return logical.ErrorResponse("invalid access_policy_template: %s", err), nil
+		}
+		scopes = policyBodyScopes(policy)
+	}
+
+	if disallowed := disallowedScopes(role.AllowedScopes, scopes); len(disallowed) > 0 {
+		return logical.ErrorResponse("access policy grants scopes outside of allowed_scopes: %s", strings.Join(disallowed, ", ")), nil
+	}
+
+	// Other changes to the role leave the generated access policy untouched
+	_, productsChanged := d.GetOk("products")
+	_, productAccessChanged := d.GetOk("product_access")
+	_, stackChanged := d.GetOk("stack_slug")
+	if len(role.Products) > 0 && (!hadProducts || productsChanged || productAccessChanged || stackChanged) {
+		if err := b.writeRoleAccessPolicy(ctx, req.Storage, name, role, scopes); err != nil {
+			return logical.ErrorResponse("failed to generate the access policy of role '%s': %s", name, err), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(rolesPrefix+name, role)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	b.evictCredsLimiter(rolesPrefix + name)
+
+	if hadProducts && len(role.Products) == 0 {
+		if err := b.deleteRoleAccessPolicy(ctx, req.Storage, name, previousPolicy); err != nil {
+			return logical.ErrorResponse("failed to delete the generated access policy of role '%s': %s", name, err), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	if name == "" {
+		return logical.ErrorResponse("missing role name"), nil
+	}
+
+	role, err := b.roleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role != nil && len(role.Products) > 0 {
+		if err := b.deleteRoleAccessPolicy(ctx, req.Storage, name, role.AccessPolicy); err != nil {
+			return logical.ErrorResponse("failed to delete the generated access policy of role '%s': %s", name, err), nil
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, rolesPrefix+name); err != nil {
+		return nil, err
+	}
+	b.evictCredsLimiter(rolesPrefix + name)
+
+	return nil, nil
+}
+
+func (b *backend) roleRead(ctx context.Context, s logical.Storage, name string) (*roleEntry, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+
+	entry, err := s.Get(ctx, rolesPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role roleEntry
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, fmt.Errorf("error reading role '%s': %w", name, err)
+	}
+	role.limiterKey = rolesPrefix + name
+
+	return &role, nil
+}
+
+// roleEntry is the issuance configuration used by creds/:name
+type roleEntry struct {
+	CredentialType         string            `json:"credential_type"`
+	Config                 string            `json:"config"`
+	StackSlug              string            `json:"stack_slug"`
+	Region                 string            `json:"region"`
+	GrafanaRole            string            `json:"grafana_role"`
+	SyntheticMonitoringURL string            `json:"synthetic_monitoring_url"`
+	K6ProjectID            int               `json:"k6_project_id"`
+	GrafanaRBACRoles       []string          `json:"grafana_rbac_roles"`
+	Bundle                 string            `json:"bundle"`
+	Products               []string          `json:"products"`
+	ProductAccess          []string          `json:"product_access"`
+	AccessPolicy           string            `json:"access_policy"`
+	AccessPolicyID         string            `json:"access_policy_id"`
+	AccessPolicyTemplate   string            `json:"access_policy_template"`
+	DisplayName            string            `json:"display_name"`
+	TokenNameTemplate      string            `json:"token_name_template"`
+	DisplayNameTemplate    string            `json:"display_name_template"`
+	Metadata               map[string]string `json:"metadata"`
+	AllowedScopes          []string          `json:"allowed_scopes"`
+	BoundCIDRs             []string          `json:"bound_cidrs"`
+	TTL                    time.Duration     `json:"ttl"`
+	MaxTTL                 time.Duration     `json:"max_ttl"`
+	MinTTL                 time.Duration     `json:"min_ttl"`
+	TTLJitter              int               `json:"ttl_jitter"`
+	RateLimit              int               `json:"rate_limit"`
+	MaxTokens              int               `json:"max_tokens"`
+	NoExpiration           bool              `json:"no_expiration"`
+	ReuseWindow            time.Duration     `json:"reuse_window"`
+
+	// limiterKey is the storage key of the role or, for the default role of
+	// an access policy without a role of its name, of the access policy
+	limiterKey string
+}
+
+// credentialType returns the credential type of the role, which is
+// credentialTypeAccessPolicyToken for roles written before it existed
+func (r *roleEntry) credentialType() string {
+	if r.CredentialType == "" {
+		return credentialTypeAccessPolicyToken
+	}
+
+	return r.CredentialType
+}
+
+// issuesStackCredentials reports whether the role issues credentials of a
+// stack rather than access policy tokens
+func (r *roleEntry) issuesStackCredentials() bool {
+	switch r.CredentialType {
+	case credentialTypeStackAPIKey, credentialTypeStackServiceAccount, credentialTypeSyntheticMonitoring:
+		return true
+	default:
+		return false
+	}
+}
+
+// allowedGrafanaRoles returns the values grafana_role may take for the
+// credential type of the role, or nil when issued credentials have no role
+func (r *roleEntry) allowedGrafanaRoles() []string {
+	switch r.CredentialType {
+	case credentialTypeStackAPIKey, credentialTypeStackServiceAccount:
+		return grafanaRoles
+	case credentialTypeOrgAPIKey:
+		return cloudAPIKeyRoles
+	default:
+		return nil
+	}
+}
+
+// validateRegion checks that tokens of the role can be issued in region rather
+// than the region of its config
+func (r *roleEntry) validateRegion(region string) error {
+	if !regionRegex.MatchString(region) {
+		return fmt.Errorf("invalid region '%s', must be a Grafana Cloud region like prod-eu-west-0", region)
+	}
+	if r.credentialType() != credentialTypeAccessPolicyToken {
+		return fmt.Errorf("region can only be used with credential_type %s", credentialTypeAccessPolicyToken)
+	}
+	if r.AccessPolicy != "" {
+		return fmt.Errorf("region can not be used with access policies managed by this mount, they live in the region of their config")
+	}
+
+	return nil
+}
+
+func (r *roleEntry) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"credential_type":          r.CredentialType,
+		"config":                   r.Config,
+		"stack_slug":               r.StackSlug,
+		"region":                   r.Region,
+		"grafana_role":             r.GrafanaRole,
+		"synthetic_monitoring_url": r.SyntheticMonitoringURL,
+		"k6_project_id":            r.K6ProjectID,
+		"grafana_rbac_roles":       r.GrafanaRBACRoles,
+		"bundle":                   r.Bundle,
+		"products":                 r.Products,
+		"product_access":           r.ProductAccess,
+		"access_policy":            r.AccessPolicy,
+		"access_policy_id":         r.AccessPolicyID,
+		"access_policy_template":   r.AccessPolicyTemplate,
+		"display_name":             r.DisplayName,
+		"token_name_template":      r.TokenNameTemplate,
+		"display_name_template":    r.DisplayNameTemplate,
+		"metadata":                 r.Metadata,
+		"allowed_scopes":           r.AllowedScopes,
+		"bound_cidrs":              r.BoundCIDRs,
+		"ttl":                      int64(r.TTL.Seconds()),
+		"max_ttl":                  int64(r.MaxTTL.Seconds()),
+		"min_ttl":                  int64(r.MinTTL.Seconds()),
+		"ttl_jitter":               r.TTLJitter,
+		"rate_limit":               r.RateLimit,
+		"max_tokens":               r.MaxTokens,
+		"no_expiration":            r.NoExpiration,
+		"reuse_window":             int64(r.ReuseWindow.Seconds()),
+	}
+}
+
+// disallowedScopes returns the scopes that are not matched by any of the
+// allowed scopes. An allowed scope of 'logs:*' matches every logs scope and
+// '*' matches everything. Every scope is allowed when allowed is empty.
+func disallowedScopes(allowed []string, scopes []string) []string {
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	var disallowed []string
+	for _, scope := range scopes {
+		matched := false
+		for _, allowedScope := range allowed {
+			if allowedScope == "*" || allowedScope == scope {
+				matched = true
+				break
+			}
+			if prefix, ok := strings.CutSuffix(allowedScope, ":*"); ok && strings.HasPrefix(scope, prefix+":") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			disallowed = append(disallowed, scope)
+		}
+	}
+
+	return disallowed
+}
+
+// policyBodyScopes returns the scopes of an access policy request body
+func policyBodyScopes(policy map[string]interface{}) []string {
+	if scopes, ok := policy["scopes"].([]string); ok {
+		return scopes
+	}
+	rawScopes, ok := policy["scopes"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	scopes := make([]string, 0, len(rawScopes))
+	for _, rawScope := range rawScopes {
+		if scope, ok := rawScope.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+const pathListRolesHelpSyn = `List the existing roles in this backend`
+
+const pathListRolesHelpDesc = `Roles will be listed by the role name.`
+
+const pathRolesHelpSyn = `
+Manage the roles that can be used to generate Grafana Cloud tokens.
+`
+
+const pathRolesHelpDesc = `
+A role references the access policy tokens are issued for, either by the name
+it is managed under in access_policies/ or by its Grafana Cloud ID, along with
+the settings used when issuing tokens from creds/<role name>. This allows the
+lifecycle of access policies to be managed separately from issuance.
+
+Alternatively a role can define an access_policy_template. The template is
+expanded with the identity of the caller, e.g. {{identity.entity.name}} or
+{{identity.entity.metadata.team}}, and a new access policy is created for every
+issued token and deleted alongside it.
+
+Tokens are issued in the region of the configured token unless the role sets
+'region', which requires access_policy_id or access_policy_template. Creds
+requests may override the region with their own 'region' parameter.
+
+Instead of referencing an access policy, a role can list the 'products'
+(metrics, logs, traces or profiles) of the stack 'stack_slug' its tokens have
+'product_access' to. The stack is looked up to generate an access policy
+scoped to it, stored as access_policies/role-<role name>, which is updated when
+the products change and deleted along with the role.
+
+Setting a 'bundle' adds the connection details of the stack 'stack_slug' to
+issued tokens, so clients can be configured from a single read: 'traces'
+adds the Tempo endpoint and instance ID for tracing exporters, and 'otlp' adds
+the OTLP gateway endpoint along with the basic auth header of the token for
+OpenTelemetry SDKs and collectors. 'profiles' adds the Pyroscope endpoint and
+instance ID for continuous profiling agents. 'alerting' adds the Mimir and Loki
+ruler and the alertmanager endpoints along with their tenant IDs for tools
+like mimirtool and cortextool.
+
+Roles with the stack_api_key credential_type instead issue Grafana API keys of
+the stack 'stack_slug' with the 'grafana_role' from creds/stack-apikey/<role
+name>, for automation that talks to the Grafana HTTP API of a stack. Roles
+with the stack_service_account credential_type issue a service account of the
+stack with a token from creds/stack-service-account/<role name>, granted the
+Grafana RBAC roles in 'grafana_rbac_roles' on top of 'grafana_role', and roles
+with the org_api_key credential_type issue legacy Grafana Cloud API keys of
+the organization from creds/org-apikey/<role name>. Roles with the
+synthetic_monitoring credential_type issue Synthetic Monitoring API tokens of
+the stack from creds/synthetic-monitoring/<role name>, installing Synthetic
+Monitoring in the stack when needed. Roles with the k6_token credential_type
+issue k6 API tokens of the organization in config/k6, restricted to the
+project 'k6_project_id' when set, from creds/k6/<role name>.
+`
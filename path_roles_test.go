@@ -0,0 +1,261 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisallowedScopes(t *testing.T) {
+	testCases := []struct {
+		name       string
+		allowed    []string
+		scopes     []string
+		disallowed []string
+	}{
+		{
+			"unrestrictedWhenEmpty",
+			nil,
+			[]string{"metrics:read", "logs:write"},
+			nil,
+		},
+		{
+			"exactMatch",
+			[]string{"metrics:read"},
+			[]string{"metrics:read", "metrics:write"},
+			[]string{"metrics:write"},
+		},
+		{
+			"wildcardSuffix",
+			[]string{"logs:*"},
+			[]string{"logs:read", "logs:write", "metricsx:read"},
+			[]string{"metricsx:read"},
+		},
+		{
+			"wildcard",
+			[]string{"*"},
+			[]string{"accesspolicies:write"},
+			nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.disallowed, disallowedScopes(testCase.allowed, testCase.scopes))
+		})
+	}
+}
+
+func TestBackend_role_rate_limit_fake(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	writeRole := func(data map[string]interface{}) {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roles/dashboards",
+			Storage:   s,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("failed to write role: resp: %#v err: %v", resp, err)
+		}
+	}
+	credsWith := func(data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/stack-apikey/dashboards",
+			Storage:   s,
+			Data:      data,
+		})
+	}
+	creds := func() error {
+		_, err := credsWith(nil)
+		return err
+	}
+	role := map[string]interface{}{
+		"credential_type": credentialTypeStackAPIKey,
+		"stack_slug":      "mystack",
+		"grafana_role":    "Viewer",
+		"rate_limit":      1,
+		"min_ttl":         "1h",
+	}
+
+	writeRole(role)
+
+	// Invalid requests do not count against the limit
+	resp, err := credsWith(map[string]interface{}{"ttl": "1m"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Equal(t, "ttl of 1m0s is shorter than the min_ttl of 1h0m0s", resp.Error().Error())
+	}
+
+	assert.NoError(t, creds())
+	resp, err = credsWith(nil)
+	assert.ErrorIs(t, err, logical.ErrRateLimitQuotaExceeded)
+	assert.Nil(t, resp)
+
+	// Updating the role starts it over with a fresh limit
+	writeRole(role)
+	assert.NoError(t, creds())
+	assert.ErrorIs(t, creds(), logical.ErrRateLimitQuotaExceeded)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/dashboards",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete role: resp: %#v err: %v", resp, err)
+	}
+	assert.Empty(t, b.credsLimiters)
+}
+
+func TestApplyTTLJitter(t *testing.T) {
+	ttl := time.Hour
+	for i := 0; i < 100; i++ {
+		// Jitter written before it was capped is still applied at the cap
+		jittered := applyTTLJitter(ttl, 100)
+		assert.LessOrEqual(t, jittered, ttl)
+		assert.Greater(t, jittered, ttl/2)
+	}
+	assert.Equal(t, ttl, applyTTLJitter(ttl, 0))
+}
+
+func TestBackend_role_ttl_jitter_fake(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/dashboards",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"credential_type": credentialTypeStackAPIKey,
+			"stack_slug":      "mystack",
+			"grafana_role":    "Viewer",
+			"ttl_jitter":      60,
+		},
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+		assert.Equal(t, "ttl_jitter must be between 0 and 50, got 60", resp.Error().Error())
+	}
+}
+
+func TestBackend_role_products_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/telemetry",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"stack_slug": "mystack",
+			"products":   "metrics,logs",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	policy, err := b.accessPoliciesRead(context.Background(), s, "role-telemetry")
+	if err != nil || policy == nil {
+		t.Fatalf("generated access policy not stored: %#v err: %v", policy, err)
+	}
+	assert.Equal(t, "telemetry", policy.Role)
+	assert.Equal(t, []string{"metrics:write", "logs:write"}, fake.policies[policy.Policy.ID].Scopes)
+	assert.Equal(t, "1", fake.policies[policy.Policy.ID].Realms[0].Identifier)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/telemetry",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+	assert.Equal(t, "https://mystack.grafana.net", resp.Data["grafana_url"])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/telemetry",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"product_access": "read",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to update role: resp: %#v err: %v", resp, err)
+	}
+	assert.Equal(t, []string{"metrics:read", "logs:read"}, fake.policies[policy.Policy.ID].Scopes)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/telemetry",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete role: resp: %#v err: %v", resp, err)
+	}
+	assert.NotContains(t, fake.policies, policy.Policy.ID)
+	policy, err = b.accessPoliciesRead(context.Background(), s, "role-telemetry")
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+}
+
+func TestBackend_role_products_generated_policy_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/telemetry",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"stack_slug": "mystack",
+			"products":   "metrics",
+			"max_tokens": 1,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create role: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/telemetry",
+		Storage:   s,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to read creds: resp: %#v err: %v", resp, err)
+	}
+
+	// The generated policy can only be used and changed through its role, so
+	// max_tokens can not be bypassed
+	for _, testCase := range []struct {
+		operation logical.Operation
+		path      string
+		data      map[string]interface{}
+		error     string
+	}{
+		{logical.ReadOperation, "creds/role-telemetry", nil, "access policy is generated for a role, read creds/telemetry instead of creds/role-telemetry"},
+		{logical.UpdateOperation, "access_policies/role-telemetry", map[string]interface{}{"scopes": "metrics:write"}, "access policy 'role-telemetry' is generated for role 'telemetry' and can only be changed through the role"},
+		{logical.PatchOperation, "access_policies/role-telemetry", map[string]interface{}{"scopes": "metrics:write"}, "access policy 'role-telemetry' is generated for role 'telemetry' and can only be changed through the role"},
+		{logical.DeleteOperation, "access_policies/role-telemetry", nil, "access policy 'role-telemetry' is generated for role 'telemetry' and is deleted with it"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: testCase.operation,
+			Path:      testCase.path,
+			Storage:   s,
+			Data:      testCase.data,
+		})
+		assert.NoError(t, err)
+		if assert.NotNil(t, resp) && assert.True(t, resp.IsError()) {
+			assert.Equal(t, testCase.error, resp.Error().Error())
+		}
+	}
+	assert.Len(t, fake.tokens, 1)
+	assert.Len(t, fake.policies, 1)
+}
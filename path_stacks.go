@@ -0,0 +1,268 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathListStacks(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "stacks/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ whose organization is listed. Uses config/token when empty",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathStacksList,
+		},
+
+		HelpSynopsis:    pathListStacksHelpSyn,
+		HelpDescription: pathListStacksHelpDesc,
+	}
+}
+
+func pathStacks(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "stacks/" + framework.GenericNameRegex("slug"),
+		Fields: map[string]*framework.FieldSchema{
+			"slug": {
+				Type:        framework.TypeString,
+				Description: "Slug of the stack",
+			},
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ the stack is managed with. Uses config/token when empty",
+			},
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the stack to create. Defaults to the slug",
+			},
+			"region": {
+				Type:        framework.TypeString,
+				Description: "Region to create the stack in, like prod-us-central-0. Defaults to the region of the organization",
+			},
+			"description": {
+				Type:        framework.TypeString,
+				Description: "Description of the stack to create",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathStacksRead,
+			logical.UpdateOperation: b.pathStacksWrite,
+			logical.DeleteOperation: b.pathStacksDelete,
+		},
+
+		HelpSynopsis:    pathStacksHelpSyn,
+		HelpDescription: pathStacksHelpDesc,
+	}
+}
+
+func (b *backend) pathStacksList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	c, err := b.configClient(ctx, req.Storage, d.Get("config").(string))
+	if err != nil {
+		return nil, err
+	}
+	if c.OrgSlug() == "" {
+		return logical.ErrorResponse("the organization of the configured token is unknown, set its org_slug"), nil
+	}
+
+	stacks, err := c.ListStacks(ctx, c.OrgSlug())
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to list stacks in grafana cloud: %s", err)), nil
+	}
+
+	keys := make([]string, 0, len(stacks))
+	keyInfo := make(map[string]interface{}, len(stacks))
+	for _, stack := range stacks {
+		keys = append(keys, stack.Slug)
+		keyInfo[stack.Slug] = map[string]interface{}{
+			"id":     stack.ID,
+			"name":   stack.Name,
+			"region": stack.RegionSlug,
+			"status": stack.Status,
+			"url":    stack.URL,
+		}
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *backend) pathStacksRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	slug := d.Get("slug").(string)
+	c, err := b.configClient(ctx, req.Storage, d.Get("config").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	stack, err := c.GetStack(ctx, slug)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to read stack '%s' from grafana cloud: %s", slug, err)), nil
+	}
+	if stack == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: stackResponseData(stack),
+	}, nil
+}
+
+func (b *backend) pathStacksWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if errResp, err := b.requireStackManagement(ctx, req.Storage); errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	slug := d.Get("slug").(string)
+	c, err := b.configClient(ctx, req.Storage, d.Get("config").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := c.GetStack(ctx, slug)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to read stack '%s' from grafana cloud: %s", slug, err)), nil
+	}
+	if existing != nil {
+		return logical.ErrorResponse("stack '%s' already exists", slug), nil
+	}
+
+	name := d.Get("name").(string)
+	if name == "" {
+		name = slug
+	}
+
+	b.Logger().Info(fmt.Sprintf("creating grafana cloud stack (slug: %s)...", slug))
+	stack, err := c.CreateStack(ctx, gcom.CreateStackRequest{
+		Name:        name,
+		Slug:        slug,
+		Region:      d.Get("region").(string),
+		Description: d.Get("description").(string),
+	})
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to create stack '%s' in grafana cloud: %s", slug, err)), nil
+	}
+
+	return &logical.Response{
+		Data: stackResponseData(stack),
+	}, nil
+}
+
+func (b *backend) pathStacksDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if errResp, err := b.requireStackManagement(ctx, req.Storage); errResp != nil || err != nil {
+		return errResp, err
+	}
+
+	slug := d.Get("slug").(string)
+	c, err := b.configClient(ctx, req.Storage, d.Get("config").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info(fmt.Sprintf("deleting grafana cloud stack (slug: %s)...", slug))
+	deleted, err := c.DeleteStack(ctx, slug)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to delete stack '%s' in grafana cloud: %s", slug, err)), nil
+	}
+	if !deleted {
+		b.Logger().Info(fmt.Sprintf("stack '%s' did not exist in grafana cloud", slug))
+	}
+
+	return nil, nil
+}
+
+// requireStackManagement returns an error response unless the
+// stack_management feature is enabled
+func (b *backend) requireStackManagement(ctx context.Context, s logical.Storage) (*logical.Response, error) {
+	features, err := b.FeaturesConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if !features.StackManagement {
+		return logical.ErrorResponse("creating and deleting stacks requires the 'stack_management' feature in config/features"), nil
+	}
+
+	return nil, nil
+}
+
+// stackResponseData returns the stack along with the endpoints telemetry is
+// sent to, so clients can be configured from a single read
+func stackResponseData(stack *gcom.Stack) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                          stack.ID,
+		"slug":                        stack.Slug,
+		"name":                        stack.Name,
+		"region":                      stack.RegionSlug,
+		"status":                      stack.Status,
+		"url":                         stack.URL,
+		"prometheus_instance_id":      stack.HmInstancePromID,
+		"prometheus_url":              stack.HmInstancePromURL,
+		"prometheus_remote_write_url": joinEndpoint(stack.HmInstancePromURL, "/api/prom/push"),
+		"loki_instance_id":            stack.HlInstanceID,
+		"loki_url":                    stack.HlInstanceURL,
+		"loki_push_url":               joinEndpoint(stack.HlInstanceURL, "/loki/api/v1/push"),
+		"tempo_instance_id":           stack.HtInstanceID,
+		"tempo_url":                   stack.HtInstanceURL,
+		"tempo_endpoint":              grpcEndpoint(stack.HtInstanceURL),
+		"pyroscope_instance_id":       stack.HpInstanceID,
+		"pyroscope_url":               stack.HpInstanceURL,
+		"alertmanager_instance_id":    stack.AmInstanceID,
+		"alertmanager_url":            stack.AmInstanceURL,
+	}
+}
+
+// joinEndpoint appends path to the url of an instance, or returns an empty
+// string when the stack has no such instance
+func joinEndpoint(instanceURL string, path string) string {
+	if instanceURL == "" {
+		return ""
+	}
+
+	return strings.TrimSuffix(instanceURL, "/") + path
+}
+
+// grpcEndpoint returns the host:port otlp/grpc exporters of traces connect to
+func grpcEndpoint(instanceURL string) string {
+	u, err := url.Parse(instanceURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return u.Hostname() + ":443"
+}
+
+const pathListStacksHelpSyn = `List the stacks of the organization`
+
+const pathListStacksHelpDesc = `
+Lists the stacks of the organization of the configured token by slug, along
+with their ID, name, region, status and URL, so stack identifiers can be
+looked up without leaving Vault, e.g. for the {{stack_id:"<slug>"}} variable
+of access policies.
+`
+
+const pathStacksHelpSyn = `Read, create or delete a stack`
+
+const pathStacksHelpDesc = `
+Returns the stack along with the IDs and URLs of its hosted metrics, logs,
+traces, profiles and alertmanager instances, including the Prometheus remote_write URL, the
+Loki push URL and the Tempo gRPC endpoint, so telemetry clients can be
+configured from a single read. Combine them with a token from creds/ to
+authenticate.
+
+When the 'stack_management' feature is enabled in config/features, writing
+the path creates the stack and deleting it deletes the stack along with all
+of its data, e.g. to provision ephemeral environments.
+`
@@ -0,0 +1,57 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_stacks_fake(t *testing.T) {
+	b, s, _ := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "stacks/missing",
+		Storage:   s,
+	})
+	if err != nil || !resp.IsError() {
+		t.Fatalf("expected the stack_management feature to be required: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/features",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"stack_management": true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to enable stack_management: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "stacks/mystack",
+		Storage:   s,
+	})
+	if err != nil || !resp.IsError() {
+		t.Fatalf("expected creating an existing stack to fail: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "stacks/missing",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"region": "prod-eu-west-0",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create stack: resp: %#v err: %v", resp, err)
+	}
+	assert.Equal(t, "missing", resp.Data["name"])
+	assert.Equal(t, "prod-eu-west-0", resp.Data["region"])
+}
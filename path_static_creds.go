@@ -0,0 +1,75 @@
+package grafanacloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathStaticCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-creds/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the static role",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathStaticCredsRead,
+		},
+
+		HelpSynopsis:    pathStaticCredsHelpSyn,
+		HelpDescription: pathStaticCredsHelpDesc,
+	}
+}
+
+func (b *backend) pathStaticCredsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	role, err := b.staticRoleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("static role '%s' does not exist", name), nil
+	}
+
+	ttl := time.Until(role.nextRotation())
+	if ttl < 0 {
+		ttl = 0
+	}
+	respData := map[string]interface{}{
+		"credential_type": role.CredentialType,
+		"token":           role.Token,
+		"name":            role.TokenName,
+		"last_rotated_at": role.LastRotatedAt,
+		"expires_at":      role.ExpiresAt,
+		"rotation_period": int64(role.RotationPeriod.Seconds()),
+		"ttl":             int64(ttl.Seconds()),
+	}
+	switch role.CredentialType {
+	case credentialTypeAccessPolicyToken:
+		respData["id"] = role.TokenID
+	case credentialTypeStackServiceAccount:
+		respData["id"] = role.ServiceAccountTokenID
+		respData["service_account_id"] = role.ServiceAccountID
+		respData["service_account_name"] = role.CredentialName
+		respData["stack_slug"] = role.StackSlug
+		respData["grafana_url"] = stackGrafanaURL(role.StackSlug)
+	}
+
+	return &logical.Response{
+		Data: respData,
+	}, nil
+}
+
+const pathStaticCredsHelpSyn = `Read the current credential of a static role`
+
+const pathStaticCredsHelpDesc = `
+Returns the current credential of the static role without creating a lease.
+'ttl' is the number of seconds until the credential is rotated, after which it
+should be read again.
+`
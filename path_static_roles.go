@@ -0,0 +1,584 @@
+package grafanacloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const staticRolesPrefix = "static_roles/"
+
+// minStaticRotationPeriod keeps rotations apart by at least one run of the
+// periodic function
+const minStaticRotationPeriod = time.Minute
+
+// staticCredentialTypes are the credential types static roles can own
+var staticCredentialTypes = []string{
+	credentialTypeAccessPolicyToken,
+	credentialTypeStackServiceAccount,
+}
+
+func pathListStaticRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-roles/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathStaticRoleList,
+		},
+
+		HelpSynopsis:    pathListStaticRolesHelpSyn,
+		HelpDescription: pathListStaticRolesHelpDesc,
+	}
+}
+
+func pathStaticRoles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-roles/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the static role",
+			},
+			"credential_type": {
+				Type:        framework.TypeString,
+				Description: "Kind of credential owned by the static role. Can not be changed",
+				Default:     credentialTypeAccessPolicyToken,
+				AllowedValues: []interface{}{
+					credentialTypeAccessPolicyToken,
+					credentialTypeStackServiceAccount,
+				},
+			},
+			"credential_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the token or service account in Grafana Cloud. Defaults to vault-static-<role name>. Can not be changed",
+			},
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ the credential is managed with. Roles referencing an access_policy use the configuration of the policy. Uses config/token when empty",
+			},
+			"access_policy": {
+				Type:        framework.TypeString,
+				Description: "Name of an access policy managed under access_policies/ the token is issued for",
+			},
+			"access_policy_id": {
+				Type:        framework.TypeString,
+				Description: "Grafana Cloud ID of the access policy the token is issued for. Mutually exclusive with access_policy",
+			},
+			"stack_slug": {
+				Type:        framework.TypeString,
+				Description: "Slug of the stack of the service account. Required for the stack_service_account credential_type. Can not be changed",
+			},
+			"grafana_role": {
+				Type:        framework.TypeString,
+				Description: "Role of the service account (Viewer, Editor or Admin). Defaults to Viewer. Can not be changed",
+			},
+			"rotation_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Time between rotations of the credential. At least one minute",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathStaticRoleRead,
+			logical.UpdateOperation: b.pathStaticRoleWrite,
+			logical.DeleteOperation: b.pathStaticRoleDelete,
+		},
+
+		HelpSynopsis:    pathStaticRolesHelpSyn,
+		HelpDescription: pathStaticRolesHelpDesc,
+	}
+}
+
+func pathStaticRoleRotate(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "static-roles/" + framework.GenericNameRegex("name") + "/rotate",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the static role",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathStaticRoleRotate,
+		},
+
+		HelpSynopsis:    pathStaticRoleRotateHelpSyn,
+		HelpDescription: pathStaticRoleRotateHelpDesc,
+	}
+}
+
+func (b *backend) pathStaticRoleList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, staticRolesPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathStaticRoleRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	role, err := b.staticRoleRead(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: role.toResponseData(),
+	}, nil
+}
+
+func (b *backend) pathStaticRoleWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	lock := locksutil.LockForKey(b.staticRoleLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.staticRoleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	created := role == nil
+	if created {
+		role = &staticRoleEntry{
+			CredentialType: d.Get("credential_type").(string),
+			CredentialName: "vault-static-" + name,
+		}
+	}
+
+	for _, immutable := range []string{"credential_type", "credential_name", "stack_slug", "grafana_role"} {
+		if _, ok := d.GetOk(immutable); ok && !created {
+			return logical.ErrorResponse("%s can not be changed, delete and recreate the static role instead", immutable), nil
+		}
+	}
+	if credentialName, ok := d.GetOk("credential_name"); ok {
+		role.CredentialName = credentialName.(string)
+	}
+	if stackSlug, ok := d.GetOk("stack_slug"); ok {
+		role.StackSlug = stackSlug.(string)
+	}
+	if grafanaRole, ok := d.GetOk("grafana_role"); ok {
+		role.GrafanaRole = grafanaRole.(string)
+	}
+	if config, ok := d.GetOk("config"); ok {
+		role.Config = config.(string)
+	}
+	if accessPolicy, ok := d.GetOk("access_policy"); ok {
+		role.AccessPolicy = accessPolicy.(string)
+	}
+	if accessPolicyID, ok := d.GetOk("access_policy_id"); ok {
+		role.AccessPolicyID = accessPolicyID.(string)
+	}
+	if rotationPeriod, ok := d.GetOk("rotation_period"); ok {
+		role.RotationPeriod = time.Duration(rotationPeriod.(int)) * time.Second
+	}
+
+	if !slices.Contains(staticCredentialTypes, role.CredentialType) {
+		return logical.ErrorResponse("unsupported credential_type '%s', must be one of: %s", role.CredentialType, strings.Join(staticCredentialTypes, ", ")), nil
+	}
+	if role.CredentialName == "" {
+		return logical.ErrorResponse("credential_name can not be empty"), nil
+	}
+	if role.RotationPeriod < minStaticRotationPeriod {
+		return logical.ErrorResponse("rotation_period must be at least %s", minStaticRotationPeriod), nil
+	}
+	switch role.CredentialType {
+	case credentialTypeAccessPolicyToken:
+		if (role.AccessPolicy == "") == (role.AccessPolicyID == "") {
+			return logical.ErrorResponse("exactly one of access_policy or access_policy_id is required"), nil
+		}
+		if role.StackSlug != "" || role.GrafanaRole != "" {
+			return logical.ErrorResponse("stack_slug and grafana_role can not be used with credential_type %s", role.CredentialType), nil
+		}
+	case credentialTypeStackServiceAccount:
+		if role.AccessPolicy != "" || role.AccessPolicyID != "" {
+			return logical.ErrorResponse("access_policy and access_policy_id can not be used with credential_type %s", role.CredentialType), nil
+		}
+		if role.StackSlug == "" {
+			return logical.ErrorResponse("stack_slug is required for credential_type %s", role.CredentialType), nil
+		}
+		if role.GrafanaRole == "" {
+			role.GrafanaRole = grafanaRoles[0]
+		}
+		if !slices.Contains(grafanaRoles, role.GrafanaRole) {
+			return logical.ErrorResponse("grafana_role must be one of: %s", strings.Join(grafanaRoles, ", ")), nil
+		}
+	}
+	if role.AccessPolicy != "" {
+		policy, err := b.accessPoliciesRead(ctx, req.Storage, role.AccessPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			return logical.ErrorResponse("access policy '%s' does not exist", role.AccessPolicy), nil
+		}
+	}
+
+	// The credential is created right away so it can be read as soon as the
+	// static role exists
+	if created {
+		if err := b.rotateStaticRole(ctx, req.Storage, name, role); err != nil {
+			return logical.ErrorResponse("failed to create the credential of static role '%s': %s", name, err), nil
+		}
+		return nil, nil
+	}
+
+	if err := b.staticRoleWrite(ctx, req.Storage, name, role); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathStaticRoleDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	lock := locksutil.LockForKey(b.staticRoleLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.staticRoleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	configName, err := b.staticRoleConfig(ctx, req.Storage, role)
+	if err != nil {
+		return nil, err
+	}
+	c, err := b.configClient(ctx, req.Storage, configName)
+	if err != nil {
+		return nil, err
+	}
+	switch role.CredentialType {
+	case credentialTypeAccessPolicyToken:
+		if role.TokenID != "" {
+			if err := c.DeleteToken(ctx, role.TokenID); err != nil && !errors.Is(err, gcom.ErrNotFound) {
+				return logical.ErrorResponse("failed to delete the token of static role '%s': %s", name, err), nil
+			}
+		}
+	case credentialTypeStackServiceAccount:
+		if role.ServiceAccountID != 0 {
+			if err := c.DeleteStackServiceAccount(ctx, role.StackSlug, role.ServiceAccountID); err != nil {
+				return logical.ErrorResponse("failed to delete the service account of static role '%s': %s", name, err), nil
+			}
+		}
+	}
+
+	if err := req.Storage.Delete(ctx, staticRolesPrefix+name); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathStaticRoleRotate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	lock := locksutil.LockForKey(b.staticRoleLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.staticRoleRead(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse("static role '%s' does not exist", name), nil
+	}
+
+	if err := b.rotateStaticRole(ctx, req.Storage, name, role); err != nil {
+		return logical.ErrorResponse("failed to rotate static role '%s': %s", name, err), nil
+	}
+
+	return nil, nil
+}
+
+// rotateStaticRoles rotates the credential of every static role whose
+// rotation_period has passed since it was last rotated
+func (b *backend) rotateStaticRoles(ctx context.Context, s logical.Storage) error {
+	names, err := s.List(ctx, staticRolesPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := b.rotateStaticRoleIfDue(ctx, s, name); err != nil {
+			b.Logger().Error("failed to rotate static role", "role", name, "error", err)
+			continue
+		}
+	}
+
+	return nil
+}
+
+func (b *backend) rotateStaticRoleIfDue(ctx context.Context, s logical.Storage, name string) error {
+	lock := locksutil.LockForKey(b.staticRoleLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	role, err := b.staticRoleRead(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	if role == nil || time.Since(role.LastRotatedAt) < role.RotationPeriod {
+		return nil
+	}
+
+	return b.rotateStaticRole(ctx, s, name, role)
+}
+
+// rotateStaticRole replaces the credential of the static role with a new one
+// and stores the role. The previous credential is deleted once the new one is
+// stored. Callers must hold the lock of the role.
+func (b *backend) rotateStaticRole(ctx context.Context, s logical.Storage, name string, role *staticRoleEntry) error {
+	configName, err := b.staticRoleConfig(ctx, s, role)
+	if err != nil {
+		return err
+	}
+	c, err := b.configClient(ctx, s, configName)
+	if err != nil {
+		return err
+	}
+
+	rotated := *role
+	now := time.Now().UTC()
+	// The credential outlives a missed rotation but still expires if vault
+	// stops rotating it
+	rotated.ExpiresAt = now.Add(2 * role.RotationPeriod)
+	rotated.LastRotatedAt = now
+	credentialName := fmt.Sprintf("%s-%d", role.CredentialName, now.UnixNano())
+
+	switch role.CredentialType {
+	case credentialTypeAccessPolicyToken:
+		policyID, err := b.staticRolePolicyID(ctx, s, role)
+		if err != nil {
+			return err
+		}
+
+		walID, err := framework.PutWAL(ctx, s, walStaticTokenKind, &walStaticToken{
+			Role:      name,
+			Config:    configName,
+			TokenName: credentialName,
+		})
+		if err != nil {
+			return fmt.Errorf("error writing WAL entry: %w", err)
+		}
+		token, err := c.CreateToken(ctx, gcom.CreateTokenRequest{
+			AccessPolicyID: policyID,
+			Name:           credentialName,
+			DisplayName:    credentialName,
+			ExpiresAt:      rotated.ExpiresAt,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create token: %w", err)
+		}
+		rotated.TokenID = token.ID
+		rotated.TokenName = token.Name
+		rotated.Token = token.Token
+
+		if err := b.staticRoleWrite(ctx, s, name, &rotated); err != nil {
+			return err
+		}
+		if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+			return fmt.Errorf("error deleting WAL entry: %w", err)
+		}
+		if role.TokenID != "" {
+			if err := c.DeleteToken(ctx, role.TokenID); err != nil && !errors.Is(err, gcom.ErrNotFound) {
+				b.Logger().Error("failed to delete rotated static role token", "role", name, "id", role.TokenID, "error", err)
+			}
+		}
+	case credentialTypeStackServiceAccount:
+		if rotated.ServiceAccountID == 0 {
+			account, err := c.CreateStackServiceAccount(ctx, role.StackSlug, gcom.CreateStackServiceAccountRequest{
+				Name: role.CredentialName,
+				Role: role.GrafanaRole,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create service account: %w", err)
+			}
+			rotated.ServiceAccountID = account.ID
+		}
+
+		token, err := c.CreateStackServiceAccountToken(ctx, role.StackSlug, rotated.ServiceAccountID, gcom.CreateStackServiceAccountTokenRequest{
+			Name:          credentialName,
+			SecondsToLive: int64((2 * role.RotationPeriod).Seconds()),
+		})
+		if err != nil {
+			if role.ServiceAccountID == 0 {
+				if deleteErr := c.DeleteStackServiceAccount(ctx, role.StackSlug, rotated.ServiceAccountID); deleteErr != nil {
+					b.Logger().Error("failed to delete service account after token creation failed", "stack", role.StackSlug, "id", rotated.ServiceAccountID, "error", deleteErr)
+				}
+			}
+			return fmt.Errorf("failed to create service account token: %w", err)
+		}
+		rotated.ServiceAccountTokenID = token.ID
+		rotated.TokenName = token.Name
+		rotated.Token = token.Key
+
+		if err := b.staticRoleWrite(ctx, s, name, &rotated); err != nil {
+			return err
+		}
+		if role.ServiceAccountTokenID != 0 {
+			if err := c.DeleteStackServiceAccountToken(ctx, role.StackSlug, role.ServiceAccountID, role.ServiceAccountTokenID); err != nil {
+				b.Logger().Error("failed to delete rotated static role token", "role", name, "id", role.ServiceAccountTokenID, "error", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported credential_type '%s'", role.CredentialType)
+	}
+	*role = rotated
+	b.Logger().Info("rotated static role", "role", name)
+
+	return nil
+}
+
+// staticRolePolicyID returns the grafana cloud ID of the access policy the
+// token of the static role is issued for
+func (b *backend) staticRolePolicyID(ctx context.Context, s logical.Storage, role *staticRoleEntry) (string, error) {
+	if role.AccessPolicy == "" {
+		return role.AccessPolicyID, nil
+	}
+
+	policy, err := b.accessPoliciesRead(ctx, s, role.AccessPolicy)
+	if err != nil {
+		return "", err
+	}
+	if policy == nil {
+		return "", fmt.Errorf("access policy '%s' referenced by the static role does not exist", role.AccessPolicy)
+	}
+
+	return policy.Policy.ID, nil
+}
+
+// staticRoleConfig returns the name of the configuration the credential of
+// the static role is managed with, which is the one of its access policy when
+// it references one
+func (b *backend) staticRoleConfig(ctx context.Context, s logical.Storage, role *staticRoleEntry) (string, error) {
+	if role.AccessPolicy == "" {
+		return role.Config, nil
+	}
+
+	policy, err := b.accessPoliciesRead(ctx, s, role.AccessPolicy)
+	if err != nil {
+		return "", err
+	}
+	if policy == nil {
+		return "", fmt.Errorf("access policy '%s' referenced by the static role does not exist", role.AccessPolicy)
+	}
+
+	return policy.Config, nil
+}
+
+func (b *backend) staticRoleRead(ctx context.Context, s logical.Storage, name string) (*staticRoleEntry, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing static role name")
+	}
+
+	entry, err := s.Get(ctx, staticRolesPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var role staticRoleEntry
+	if err := entry.DecodeJSON(&role); err != nil {
+		return nil, fmt.Errorf("error reading static role '%s': %w", name, err)
+	}
+
+	return &role, nil
+}
+
+func (b *backend) staticRoleWrite(ctx context.Context, s logical.Storage, name string, role *staticRoleEntry) error {
+	entry, err := logical.StorageEntryJSON(staticRolesPrefix+name, role)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// staticRoleEntry is a single Grafana Cloud credential owned by the plugin
+// and rotated every RotationPeriod, along with its current value
+type staticRoleEntry struct {
+	CredentialType string        `json:"credential_type"`
+	CredentialName string        `json:"credential_name"`
+	Config         string        `json:"config"`
+	AccessPolicy   string        `json:"access_policy"`
+	AccessPolicyID string        `json:"access_policy_id"`
+	StackSlug      string        `json:"stack_slug"`
+	GrafanaRole    string        `json:"grafana_role"`
+	RotationPeriod time.Duration `json:"rotation_period"`
+
+	LastRotatedAt         time.Time `json:"last_rotated_at"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	TokenID               string    `json:"token_id"`
+	TokenName             string    `json:"token_name"`
+	Token                 string    `json:"token"`
+	ServiceAccountID      int       `json:"service_account_id"`
+	ServiceAccountTokenID int       `json:"service_account_token_id"`
+}
+
+// nextRotation returns when the credential is rotated next
+func (r *staticRoleEntry) nextRotation() time.Time {
+	return r.LastRotatedAt.Add(r.RotationPeriod)
+}
+
+func (r *staticRoleEntry) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"credential_type":  r.CredentialType,
+		"credential_name":  r.CredentialName,
+		"config":           r.Config,
+		"access_policy":    r.AccessPolicy,
+		"access_policy_id": r.AccessPolicyID,
+		"stack_slug":       r.StackSlug,
+		"grafana_role":     r.GrafanaRole,
+		"rotation_period":  int64(r.RotationPeriod.Seconds()),
+		"last_rotated_at":  r.LastRotatedAt,
+		"next_rotation":    r.nextRotation(),
+	}
+}
+
+const pathListStaticRolesHelpSyn = `List the static roles`
+
+const pathListStaticRolesHelpDesc = `
+Lists the static roles, each owning a single Grafana Cloud credential that is
+rotated on a schedule.
+`
+
+const pathStaticRolesHelpSyn = `Manage a credential owned by Vault and rotated on a schedule`
+
+const pathStaticRolesHelpDesc = `
+A static role owns a single Grafana Cloud credential that Vault rotates every
+rotation_period, for applications that can not handle dynamic leases. The
+credential is either an access policy token for the access policy of the role,
+or a token of the service account 'credential_name' in the stack 'stack_slug'.
+The credential is created when the static role is created, is read from
+static-creds/<role name> and is deleted along with the static role.
+
+Each rotation creates a new token before deleting the previous one. Tokens
+expire after twice the rotation_period so they do not outlive Vault if it
+stops rotating them.
+`
+
+const pathStaticRoleRotateHelpSyn = `Rotate the credential of a static role now`
+
+const pathStaticRoleRotateHelpDesc = `
+Replaces the credential of the static role with a new one immediately instead
+of waiting for its rotation_period to pass. The next scheduled rotation is
+counted from now.
+`
@@ -0,0 +1,74 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_static_role_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/readers",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"policy": `{"displayName": "Readers", "scopes": ["metrics:read"], "realms": [{"type": "org", "identifier": "1"}]}`,
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create access policy: resp: %#v err: %v", resp, err)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "static-roles/legacy",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"access_policy":   "readers",
+			"rotation_period": "1h",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to create static role: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.tokens, 1)
+
+	readCreds := func() *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "static-creds/legacy",
+			Storage:   s,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("failed to read static creds: resp: %#v err: %v", resp, err)
+		}
+		return resp
+	}
+	before := readCreds()
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "static-roles/legacy/rotate",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to rotate static role: resp: %#v err: %v", resp, err)
+	}
+	after := readCreds()
+	assert.NotEqual(t, before.Data["token"], after.Data["token"])
+	assert.Len(t, fake.tokens, 1)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "static-roles/legacy",
+		Storage:   s,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("failed to delete static role: resp: %#v err: %v", resp, err)
+	}
+	assert.Len(t, fake.tokens, 0)
+}
@@ -0,0 +1,96 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathListTokens(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"access_policy_id": {
+				Type:        framework.TypeString,
+				Description: "Only list the tokens of the access policy with this Grafana Cloud ID",
+			},
+			"name_prefix": {
+				Type:        framework.TypeString,
+				Description: "Only list the tokens whose name starts with this prefix",
+			},
+			"page_size": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of tokens to fetch from Grafana Cloud",
+			},
+			"page_cursor": {
+				Type:        framework.TypeString,
+				Description: "Cursor returned by a previous list to continue from",
+			},
+			"config": {
+				Type:        framework.TypeString,
+				Description: "Name of the configuration under config/tokens/ whose organization is listed. Uses config/token when empty",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathTokensList,
+		},
+
+		HelpSynopsis:    pathListTokensHelpSyn,
+		HelpDescription: pathListTokensHelpDesc,
+	}
+}
+
+func (b *backend) pathTokensList(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	c, err := b.configClient(ctx, req.Storage, d.Get("config").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := c.ListTokens(ctx, d.Get("access_policy_id").(string), d.Get("page_size").(int), d.Get("page_cursor").(string))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to list tokens in grafana cloud: %s", err)), nil
+	}
+	nextCursor, err := tokens.Metadata.NextPageCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	namePrefix := d.Get("name_prefix").(string)
+	keys := make([]string, 0, len(tokens.Items))
+	keyInfo := make(map[string]interface{}, len(tokens.Items))
+	for _, token := range tokens.Items {
+		if !strings.HasPrefix(token.Name, namePrefix) {
+			continue
+		}
+		keys = append(keys, token.ID)
+		keyInfo[token.ID] = map[string]interface{}{
+			"name":             token.Name,
+			"display_name":     token.DisplayName,
+			"access_policy_id": token.AccessPolicyID,
+			"expires_at":       token.ExpiresAt,
+			"last_used_at":     token.LastUsedAt,
+			"created_at":       token.CreatedAt,
+		}
+	}
+
+	resp := logical.ListResponseWithInfo(keys, keyInfo)
+	if nextCursor != "" {
+		resp.Data["next_page_cursor"] = nextCursor
+	}
+
+	return resp, nil
+}
+
+const pathListTokensHelpSyn = `List the tokens that exist in Grafana Cloud`
+
+const pathListTokensHelpDesc = `
+Lists the tokens of the organization directly from the Grafana Cloud API,
+including the ones not issued by this mount, by their Grafana Cloud ID.
+Filter them with 'access_policy_id' and 'name_prefix', and use 'page_size'
+and the returned 'next_page_cursor' to page through results. The name prefix
+is applied to each page, so pages may hold fewer tokens than 'page_size'.
+`
@@ -0,0 +1,138 @@
+package grafanacloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// policyBodyKeys are the top level keys of a Grafana Cloud access policy
+// request body
+var policyBodyKeys = map[string]bool{
+	"name":        true,
+	"displayName": true,
+	"scopes":      true,
+	"realms":      true,
+	"conditions":  true,
+	"status":      true,
+}
+
+// validatePolicyBody checks a raw access policy body supplied by the user
+// against the shape the Grafana Cloud API expects, so mistakes are reported
+// with the offending key instead of an opaque error from the API. The name is
+// set from the path, so a body may only repeat it.
+func validatePolicyBody(name string, policy map[string]interface{}) error {
+	for key := range policy {
+		if !policyBodyKeys[key] {
+			known := make([]string, 0, len(policyBodyKeys))
+			for knownKey := range policyBodyKeys {
+				known = append(known, knownKey)
+			}
+			sort.Strings(known)
+
+			return fmt.Errorf("unknown key '%s', must be one of %s", key, strings.Join(known, ", "))
+		}
+	}
+
+	if rawName, ok := policy["name"]; ok && rawName != name {
+		return fmt.Errorf("name must not be set, the access policy is named '%s' after its path", name)
+	}
+	if rawDisplayName, ok := policy["displayName"]; ok {
+		if _, ok := rawDisplayName.(string); !ok {
+			return fmt.Errorf("displayName must be a string")
+		}
+	}
+	if rawStatus, ok := policy["status"]; ok {
+		if rawStatus != accessPolicyStatusActive && rawStatus != accessPolicyStatusInactive {
+			return fmt.Errorf("status must be '%s' or '%s'", accessPolicyStatusActive, accessPolicyStatusInactive)
+		}
+	}
+	if rawScopes, ok := policy["scopes"]; ok {
+		if err := validateStringList("scopes", rawScopes); err != nil {
+			return err
+		}
+	}
+
+	if rawRealms, ok := policy["realms"]; ok {
+		realms, ok := rawRealms.([]interface{})
+		if !ok {
+			return fmt.Errorf("realms must be a list")
+		}
+		for i, rawRealm := range realms {
+			realm, ok := rawRealm.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("realms[%d] must be an object", i)
+			}
+			for key := range realm {
+				if key != "type" && key != "identifier" && key != "labelPolicies" {
+					return fmt.Errorf("realms[%d] has unknown key '%s', must be one of identifier, labelPolicies, type", i, key)
+				}
+			}
+			if realm["type"] != "org" && realm["type"] != "stack" {
+				return fmt.Errorf("realms[%d].type must be org or stack", i)
+			}
+			if identifier, _ := realm["identifier"].(string); identifier == "" {
+				return fmt.Errorf("realms[%d].identifier must be a non-empty string", i)
+			}
+
+			rawLabelPolicies, ok := realm["labelPolicies"]
+			if !ok {
+				continue
+			}
+			labelPolicies, ok := rawLabelPolicies.([]interface{})
+			if !ok {
+				return fmt.Errorf("realms[%d].labelPolicies must be a list", i)
+			}
+			for j, rawLabelPolicy := range labelPolicies {
+				labelPolicy, ok := rawLabelPolicy.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("realms[%d].labelPolicies[%d] must be an object", i, j)
+				}
+				selector, _ := labelPolicy["selector"].(string)
+				if err := validateLabelSelector(selector); err != nil {
+					return fmt.Errorf("realms[%d].labelPolicies[%d].selector is invalid: %w", i, j, err)
+				}
+			}
+		}
+	}
+
+	if rawConditions, ok := policy["conditions"]; ok {
+		if err := validatePolicyConditions(rawConditions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validatePolicyConditions checks the conditions of an access policy body
+func validatePolicyConditions(rawConditions interface{}) error {
+	conditions, ok := rawConditions.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("conditions must be an object")
+	}
+	for key, value := range conditions {
+		if key != "allowedSubnets" {
+			return fmt.Errorf("conditions has unknown key '%s', must be allowedSubnets", key)
+		}
+		if err := validateStringList("conditions.allowedSubnets", value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateStringList(key string, raw interface{}) error {
+	values, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be a list of strings", key)
+	}
+	for i, value := range values {
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s[%d] must be a string", key, i)
+		}
+	}
+
+	return nil
+}
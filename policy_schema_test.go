@@ -0,0 +1,45 @@
+package grafanacloud
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePolicyBody(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy string
+		valid  bool
+	}{
+		{"minimal", `{"scopes": ["metrics:read"]}`, true},
+		{"full", `{"name": "test", "displayName": "Test", "scopes": ["metrics:read"], "realms": [{"type": "stack", "identifier": "1", "labelPolicies": [{"selector": "{env=\"prod\"}"}]}], "conditions": {"allowedSubnets": ["10.0.0.0/8"]}, "status": "active"}`, true},
+		{"other name", `{"name": "other", "scopes": ["metrics:read"]}`, false},
+		{"unknown key", `{"scope": ["metrics:read"]}`, false},
+		{"scopes not a list", `{"scopes": "metrics:read"}`, false},
+		{"scope not a string", `{"scopes": [1]}`, false},
+		{"realm type", `{"realms": [{"type": "instance", "identifier": "1"}]}`, false},
+		{"realm identifier", `{"realms": [{"type": "org"}]}`, false},
+		{"realm unknown key", `{"realms": [{"type": "org", "identifier": "1", "labels": []}]}`, false},
+		{"label policy selector", `{"realms": [{"type": "org", "identifier": "1", "labelPolicies": [{"selector": "env=prod"}]}]}`, false},
+		{"conditions unknown key", `{"conditions": {"allowedIPs": []}}`, false},
+		{"status", `{"status": "disabled"}`, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var policy map[string]interface{}
+			if err := json.Unmarshal([]byte(testCase.policy), &policy); err != nil {
+				t.Fatal(err)
+			}
+
+			err := validatePolicyBody("test", policy)
+			if testCase.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
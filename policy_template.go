@@ -0,0 +1,97 @@
+package grafanacloud
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/helper/identitytpl"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// validateAccessPolicyTemplate ensures the template is a JSON object with
+// well formed identity template directives
+func validateAccessPolicyTemplate(template string) error {
+	var policy map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &policy); err != nil {
+		return fmt.Errorf("template must be a JSON object: %w", err)
+	}
+
+	_, err := populateTemplateStrings(policy, identitytpl.PopulateStringInput{
+		Mode:              identitytpl.ACLTemplating,
+		ValidityCheckOnly: true,
+	})
+	return err
+}
+
+// renderAccessPolicyTemplate expands the identity template directives of the
+// template using the entity and groups of the requester. The template is
+// parsed before it is expanded and only its string values are expanded, so
+// identity data can not change the structure of the policy.
+func (b *backend) renderAccessPolicyTemplate(req *logical.Request, template string) (map[string]interface{}, error) {
+	var policy map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &policy); err != nil {
+		return nil, fmt.Errorf("template must be a JSON object: %w", err)
+	}
+
+	var entity *logical.Entity
+	var groups []*logical.Group
+	if req.EntityID != "" {
+		var err error
+		entity, err = b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up entity '%s': %w", req.EntityID, err)
+		}
+		groups, err = b.System().GroupsForEntity(req.EntityID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up groups of entity '%s': %w", req.EntityID, err)
+		}
+	}
+
+	rendered, err := populateTemplateStrings(policy, identitytpl.PopulateStringInput{
+		Mode:   identitytpl.ACLTemplating,
+		Entity: entity,
+		Groups: groups,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rendered.(map[string]interface{}), nil
+}
+
+// populateTemplateStrings returns a copy of the decoded JSON value with the
+// identity template directives of each string value expanded. Object keys are
+// left as they are.
+func populateTemplateStrings(value interface{}, input identitytpl.PopulateStringInput) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		input.String = v
+		_, populated, err := identitytpl.PopulateString(input)
+		if err != nil {
+			return nil, err
+		}
+		return populated, nil
+	case map[string]interface{}:
+		populated := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			p, err := populateTemplateStrings(item, input)
+			if err != nil {
+				return nil, err
+			}
+			populated[key] = p
+		}
+		return populated, nil
+	case []interface{}:
+		populated := make([]interface{}, len(v))
+		for i, item := range v {
+			p, err := populateTemplateStrings(item, input)
+			if err != nil {
+				return nil, err
+			}
+			populated[i] = p
+		}
+		return populated, nil
+	default:
+		return value, nil
+	}
+}
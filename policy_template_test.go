@@ -0,0 +1,45 @@
+package grafanacloud
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/helper/identitytpl"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPopulateTemplateStrings(t *testing.T) {
+	template := `{"name": "team-{{identity.entity.metadata.team}}", "scopes": ["metrics:read"], "realms": [{"type": "stack", "identifier": "{{identity.entity.metadata.stack}}"}]}`
+	assert.NoError(t, validateAccessPolicyTemplate(template))
+	assert.Error(t, validateAccessPolicyTemplate(`["not", "an", "object"]`))
+
+	entity := &logical.Entity{
+		Metadata: map[string]string{
+			// Quotes in identity data must not be able to add scopes
+			"team":  `a", "scopes": ["accesspolicies:delete"], "x": "`,
+			"stack": "123",
+		},
+	}
+
+	var policy map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(template), &policy))
+	rendered, err := populateTemplateStrings(policy, identitytpl.PopulateStringInput{
+		Mode:   identitytpl.ACLTemplating,
+		Entity: entity,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":   `team-a", "scopes": ["accesspolicies:delete"], "x": "`,
+		"scopes": []interface{}{"metrics:read"},
+		"realms": []interface{}{
+			map[string]interface{}{"type": "stack", "identifier": "123"},
+		},
+	}, rendered)
+
+	_, err = populateTemplateStrings(policy, identitytpl.PopulateStringInput{
+		Mode:   identitytpl.ACLTemplating,
+		Entity: &logical.Entity{},
+	})
+	assert.Error(t, err)
+}
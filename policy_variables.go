@@ -0,0 +1,82 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// policyVariableRegex matches the placeholders resolved in access policy
+// definitions, like {{org_id}} or {{stack_id:"myslug"}}. The quotes around the
+// slug may be escaped since placeholders usually sit inside JSON strings.
+var policyVariableRegex = regexp.MustCompile(`\{\{\s*(\w+)\s*(?::\s*\\?"([^"\\]*)\\?")?\s*\}\}`)
+
+// resolvePolicyVariables replaces the placeholders in s with the ids they
+// refer to, looking them up through c:
+//
+//	{{org_id}}               id of the organization of the configured token
+//	{{stack_id:"<slug>"}}    id of the stack with the given slug
+func resolvePolicyVariables(ctx context.Context, c GrafanaClient, s string) (string, error) {
+	resolved := map[string]string{}
+	var resolveErr error
+	out := policyVariableRegex.ReplaceAllStringFunc(s, func(match string) string {
+		if resolveErr != nil {
+			return match
+		}
+
+		groups := policyVariableRegex.FindStringSubmatch(match)
+		name, arg := groups[1], groups[2]
+		key := name + ":" + arg
+		if value, ok := resolved[key]; ok {
+			return value
+		}
+
+		value, err := resolvePolicyVariable(ctx, c, name, arg)
+		if err != nil {
+			resolveErr = fmt.Errorf("failed to resolve '%s': %w", match, err)
+			return match
+		}
+		resolved[key] = value
+
+		return value
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+
+	return out, nil
+}
+
+func resolvePolicyVariable(ctx context.Context, c GrafanaClient, name string, arg string) (string, error) {
+	switch name {
+	case "org_id":
+		if arg != "" {
+			return "", fmt.Errorf("org_id does not take an argument")
+		}
+		if c.OrgSlug() == "" {
+			return "", fmt.Errorf("the organization of the configured token is unknown")
+		}
+		org, err := c.GetOrg(ctx, c.OrgSlug())
+		if err != nil {
+			return "", err
+		}
+		if org == nil {
+			return "", fmt.Errorf("organization '%s' does not exist", c.OrgSlug())
+		}
+
+		return strconv.Itoa(org.ID), nil
+	case "stack_id":
+		if arg == "" {
+			return "", fmt.Errorf("stack_id requires a stack slug, like {{stack_id:\"myslug\"}}")
+		}
+		id, err := c.StackID(ctx, arg)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(id), nil
+	default:
+		return "", fmt.Errorf("unknown variable '%s'", name)
+	}
+}
@@ -0,0 +1,87 @@
+package grafanacloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const retiredRootTokensPrefix = "retired_root_tokens/"
+
+// retiredRootToken is a root token replaced by a rotation that is kept alive
+// until DeleteAfter so operations still holding it do not fail
+type retiredRootToken struct {
+	ID          string    `json:"id"`
+	Config      string    `json:"config"`
+	DeleteAfter time.Time `json:"delete_after"`
+	// AccessPolicyID is the access policy created by a scoped rotation that
+	// is deleted along with the token, if any
+	AccessPolicyID string `json:"access_policy_id,omitempty"`
+}
+
+func (b *backend) retireRootToken(ctx context.Context, s logical.Storage, configName string, id string, accessPolicyID string, gracePeriod time.Duration) error {
+	entry, err := logical.StorageEntryJSON(retiredRootTokensPrefix+id, retiredRootToken{
+		ID:             id,
+		Config:         configName,
+		DeleteAfter:    time.Now().UTC().Add(gracePeriod),
+		AccessPolicyID: accessPolicyID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// deleteRetiredRootTokens deletes the retired root tokens whose grace period
+// has passed
+func (b *backend) deleteRetiredRootTokens(ctx context.Context, s logical.Storage) error {
+	ids, err := s.List(ctx, retiredRootTokensPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		raw, err := s.Get(ctx, retiredRootTokensPrefix+id)
+		if err != nil {
+			return err
+		}
+		if raw == nil {
+			continue
+		}
+
+		var token retiredRootToken
+		if err := raw.DecodeJSON(&token); err != nil {
+			return fmt.Errorf("error reading retired root token '%s': %w", id, err)
+		}
+		if time.Now().Before(token.DeleteAfter) {
+			continue
+		}
+
+		client, err := b.configClient(ctx, s, token.Config)
+		if err != nil {
+			b.Logger().Error("failed to delete retired root token", "id", token.ID, "error", err)
+			continue
+		}
+		// Already deleted when deleting its access policy failed last time
+		if err := client.DeleteToken(ctx, token.ID); err != nil && !errors.Is(err, gcom.ErrNotFound) {
+			b.Logger().Error("failed to delete retired root token", "id", token.ID, "error", err)
+			continue
+		}
+		if token.AccessPolicyID != "" {
+			if _, err := client.DeleteAccessPolicy(ctx, token.AccessPolicyID); err != nil {
+				b.Logger().Error("failed to delete access policy of retired root token", "id", token.ID, "access_policy_id", token.AccessPolicyID, "error", err)
+				continue
+			}
+		}
+		if err := s.Delete(ctx, retiredRootTokensPrefix+id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// supportedProducts are the products roles can generate stack scoped access
+// policies for. Each grants the <product>:<access> scopes.
+var supportedProducts = []string{"metrics", "logs", "traces", "profiles"}
+
+// supportedProductAccess are the kinds of access a generated policy grants to
+// its products
+var supportedProductAccess = []string{"read", "write"}
+
+// generatedAccessPolicyName returns the name of the access policy generated
+// from the products of the role
+func generatedAccessPolicyName(roleName string) string {
+	return "role-" + roleName
+}
+
+// productScopes returns the scopes granting access to products
+func productScopes(products []string, access []string) ([]string, error) {
+	var scopes []string
+	for _, product := range products {
+		if !slices.Contains(supportedProducts, product) {
+			return nil, fmt.Errorf("unsupported product '%s', must be one of: %s", product, strings.Join(supportedProducts, ", "))
+		}
+		for _, a := range access {
+			if !slices.Contains(supportedProductAccess, a) {
+				return nil, fmt.Errorf("unsupported product_access '%s', must be one of: %s", a, strings.Join(supportedProductAccess, ", "))
+			}
+			scopes = append(scopes, product+":"+a)
+		}
+	}
+
+	return scopes, nil
+}
+
+// writeRoleAccessPolicy creates or updates role.AccessPolicy, granting scopes
+// on the stack of the role. It is stored under access_policies/ like the
+// policies managed by hand so tokens are issued for it the same way.
+func (b *backend) writeRoleAccessPolicy(ctx context.Context, s logical.Storage, roleName string, role *roleEntry, scopes []string) error {
+	name := role.AccessPolicy
+	lock := locksutil.LockForKey(b.policyLocks, name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := b.accessPoliciesRead(ctx, s, name)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		entry = &accessPolicyEntry{
+			Config: role.Config,
+			Status: accessPolicyStatusActive,
+			Role:   roleName,
+		}
+	}
+	if entry.Role != roleName {
+		return fmt.Errorf("access policy '%s' already exists and was not generated for the role", name)
+	}
+	if entry.Config != role.Config {
+		return fmt.Errorf("cannot change the config of a role with products, it is used by its access policy '%s'", name)
+	}
+
+	c, err := b.configClient(ctx, s, entry.Config)
+	if err != nil {
+		return err
+	}
+
+	stackID, err := c.StackID(ctx, role.StackSlug)
+	if err != nil {
+		return fmt.Errorf("failed to resolve stack '%s': %w", role.StackSlug, err)
+	}
+
+	body := map[string]interface{}{
+		"displayName": fmt.Sprintf("%s (%s)", name, role.StackSlug),
+		"scopes":      scopes,
+		"realms": []map[string]interface{}{
+			{
+				"type":       "stack",
+				"identifier": strconv.Itoa(stackID),
+			},
+		},
+	}
+
+	if entry.Policy.ID != "" {
+		updated, err := c.UpdateAccessPolicy(ctx, entry.Policy.ID, body)
+		if err != nil {
+			return fmt.Errorf("failed to update policy '%s' in grafana cloud: %w", name, err)
+		}
+		if updated != nil {
+			entry.Policy = *updated
+		} else {
+			entry.Policy.ID = ""
+		}
+	}
+	var walID string
+	if entry.Policy.ID == "" {
+		body["name"] = name
+		var created *gcom.AccessPolicy
+		created, walID, err = createAccessPolicyWithWAL(ctx, s, c, entry.Config, name, body)
+		if err != nil {
+			return fmt.Errorf("failed to create policy '%s' in grafana cloud: %w", name, err)
+		}
+		entry.Policy = *created
+	}
+	if err := entry.markSynced(); err != nil {
+		return err
+	}
+
+	storageEntry, err := logical.StorageEntryJSON("access_policies/"+name, entry)
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, storageEntry); err != nil {
+		return err
+	}
+	if walID != "" {
+		return framework.DeleteWAL(ctx, s, walID)
+	}
+
+	return nil
+}
+
+// deleteRoleAccessPolicy deletes the access policy generated for the role,
+// along with its pooled tokens. Policies not generated for it are left alone.
+func (b *backend) deleteRoleAccessPolicy(ctx context.Context, s logical.Storage, roleName string, policyName string) error {
+	lock := locksutil.LockForKey(b.policyLocks, policyName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := b.accessPoliciesRead(ctx, s, policyName)
+	if err != nil {
+		return err
+	}
+	if entry == nil || entry.Role != roleName {
+		return nil
+	}
+
+	c, err := b.configClient(ctx, s, entry.Config)
+	if err != nil {
+		return err
+	}
+	if err := b.drainTokenPool(ctx, s, c, policyName); err != nil {
+		return fmt.Errorf("failed to delete pooled tokens of access policy '%s': %w", policyName, err)
+	}
+	if _, err := c.DeleteAccessPolicy(ctx, entry.Policy.ID); err != nil {
+		return fmt.Errorf("failed to delete access policy with id '%s': %w", entry.Policy.ID, err)
+	}
+
+	return s.Delete(ctx, "access_policies/"+policyName)
+}
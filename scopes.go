@@ -0,0 +1,65 @@
+package grafanacloud
+
+import (
+	"slices"
+)
+
+// knownScopes is the catalog of scopes Grafana Cloud access policies accept.
+// Scopes missing from it are most likely typos, but may also be new so they
+// are only warned about.
+var knownScopes = []string{
+	"accesspolicies:read",
+	"accesspolicies:write",
+	"accesspolicies:delete",
+	"api-keys:read",
+	"api-keys:write",
+	"api-keys:delete",
+	"alerts:read",
+	"alerts:write",
+	"billing-metrics:read",
+	"fleet-management:read",
+	"fleet-management:write",
+	"logs:read",
+	"logs:write",
+	"logs:delete",
+	"metrics:read",
+	"metrics:write",
+	"metrics:import",
+	"metrics:delete",
+	"orgs:read",
+	"orgs:write",
+	"profiles:read",
+	"profiles:write",
+	"rules:read",
+	"rules:write",
+	"stack-api-keys:read",
+	"stack-api-keys:write",
+	"stack-api-keys:delete",
+	"stack-dashboards:read",
+	"stack-dashboards:write",
+	"stack-dashboards:delete",
+	"stack-datasources:read",
+	"stack-datasources:write",
+	"stack-datasources:delete",
+	"stack-plugins:read",
+	"stack-plugins:write",
+	"stack-plugins:delete",
+	"stack-service-accounts:write",
+	"stacks:read",
+	"stacks:write",
+	"stacks:delete",
+	"traces:read",
+	"traces:write",
+}
+
+// unknownScopes returns the scopes that are not in the catalog
+func unknownScopes(scopes []string) []string {
+	var unknown []string
+	for _, scope := range scopes {
+		if !slices.Contains(knownScopes, scope) {
+			unknown = append(unknown, scope)
+		}
+	}
+
+	return unknown
+}
@@ -0,0 +1,84 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	SecretK6TokenType = "k6_token"
+)
+
+func secretK6Token(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretK6TokenType,
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: "k6 API token",
+			},
+			"id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the token",
+			},
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the token",
+			},
+			"organization_id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the k6 organization the token belongs to",
+			},
+			"project_id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the k6 project the token is restricted to, or 0",
+			},
+		},
+
+		Revoke: b.secretK6TokenRevoke,
+	}
+}
+
+func (b *backend) secretK6TokenRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	k6Conf, err := b.readK6Config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if k6Conf == nil {
+		return nil, fmt.Errorf("can not revoke k6 token, %s does not exist", k6ConfigKey)
+	}
+
+	configName, _ := req.Secret.InternalData["config"].(string)
+	c, err := b.configClient(ctx, req.Storage, configName)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := leaseInt(req.Secret.InternalData, "id")
+	if err != nil {
+		return nil, err
+	}
+	orgID, err := leaseInt(req.Secret.InternalData, "organization_id")
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info(fmt.Sprintf("Revoking k6 token (organization: %d, name: %v, id: %d)...", orgID, req.Secret.InternalData["name"], id))
+	if err := c.DeleteK6Token(ctx, k6Conf.apiURL(), k6Conf.Token, orgID, id); err != nil {
+		return nil, err
+	}
+	role, _ := req.Secret.InternalData["role"].(string)
+	recordTokens("revoked", role, 1)
+
+	if role != "" {
+		if err := b.forgetIssuedToken(ctx, req.Storage, role, strconv.Itoa(id)); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
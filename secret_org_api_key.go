@@ -0,0 +1,75 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	SecretOrgAPIKeyType = "org_api_key"
+)
+
+func secretOrgAPIKey(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretOrgAPIKeyType,
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: "Grafana Cloud API key",
+			},
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the API key",
+			},
+			"id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the API key",
+			},
+			"org_slug": {
+				Type:        framework.TypeString,
+				Description: "Slug of the organization the API key belongs to",
+			},
+			"grafana_role": {
+				Type:        framework.TypeString,
+				Description: "Grafana Cloud role of the API key",
+			},
+		},
+
+		Revoke: b.secretOrgAPIKeyRevoke,
+	}
+}
+
+func (b *backend) secretOrgAPIKeyRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	configName, _ := req.Secret.InternalData["config"].(string)
+	c, err := b.configClient(ctx, req.Storage, configName)
+	if err != nil {
+		return nil, err
+	}
+
+	orgSlug, ok := req.Secret.InternalData["org_slug"].(string)
+	if !ok {
+		return nil, fmt.Errorf("org_slug is missing on the lease")
+	}
+	name, ok := req.Secret.InternalData["name"].(string)
+	if !ok {
+		return nil, fmt.Errorf("name is missing on the lease")
+	}
+
+	b.Logger().Info(fmt.Sprintf("Revoking grafana-cloud org API key (org: %s, name: %s)...", orgSlug, name))
+	if err := c.DeleteOrgAPIKey(ctx, orgSlug, name); err != nil {
+		return nil, err
+	}
+	role, _ := req.Secret.InternalData["role"].(string)
+	recordTokens("revoked", role, 1)
+
+	if role != "" {
+		if err := b.forgetIssuedToken(ctx, req.Storage, role, name); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
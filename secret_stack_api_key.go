@@ -0,0 +1,89 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	SecretStackAPIKeyType = "stack_api_key"
+)
+
+func secretStackAPIKey(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretStackAPIKeyType,
+		Fields: map[string]*framework.FieldSchema{
+			"key": {
+				Type:        framework.TypeString,
+				Description: "Grafana API key",
+			},
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the API key",
+			},
+			"id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the API key in the stack",
+			},
+			"stack_slug": {
+				Type:        framework.TypeString,
+				Description: "Slug of the stack the API key belongs to",
+			},
+			"grafana_role": {
+				Type:        framework.TypeString,
+				Description: "Grafana role of the API key",
+			},
+		},
+
+		Revoke: b.secretStackAPIKeyRevoke,
+	}
+}
+
+func (b *backend) secretStackAPIKeyRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	configName, _ := req.Secret.InternalData["config"].(string)
+	c, err := b.configClient(ctx, req.Storage, configName)
+	if err != nil {
+		return nil, err
+	}
+
+	stackSlug, ok := req.Secret.InternalData["stack_slug"].(string)
+	if !ok {
+		return nil, fmt.Errorf("stack_slug is missing on the lease")
+	}
+	id, err := leaseInt(req.Secret.InternalData, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info(fmt.Sprintf("Revoking grafana stack API key (stack: %s, name: %v, id: %d)...", stackSlug, req.Secret.InternalData["name"], id))
+	if err := c.DeleteStackAPIKey(ctx, stackSlug, id); err != nil {
+		return nil, err
+	}
+	role, _ := req.Secret.InternalData["role"].(string)
+	recordTokens("revoked", role, 1)
+
+	if role != "" {
+		if err := b.forgetIssuedToken(ctx, req.Storage, role, strconv.Itoa(id)); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// leaseInt returns the integer stored under key in the internal data of a
+// lease, which is a float64 once the lease was read back from storage
+func leaseInt(internal map[string]interface{}, key string) (int, error) {
+	switch value := internal[key].(type) {
+	case int:
+		return value, nil
+	case float64:
+		return int(value), nil
+	default:
+		return 0, fmt.Errorf("%s is missing on the lease", key)
+	}
+}
@@ -0,0 +1,88 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	SecretStackServiceAccountType = "stack_service_account"
+)
+
+func secretStackServiceAccount(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretStackServiceAccountType,
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: "Token of the service account",
+			},
+			"token_id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the token of the service account",
+			},
+			"service_account_id": {
+				Type:        framework.TypeInt,
+				Description: "ID of the service account in the stack",
+			},
+			"service_account_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the service account",
+			},
+			"service_account_login": {
+				Type:        framework.TypeString,
+				Description: "Login of the service account",
+			},
+			"stack_slug": {
+				Type:        framework.TypeString,
+				Description: "Slug of the stack the service account belongs to",
+			},
+			"grafana_role": {
+				Type:        framework.TypeString,
+				Description: "Grafana role of the service account",
+			},
+			"grafana_rbac_roles": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "UIDs of the Grafana RBAC roles granted to the service account",
+			},
+		},
+
+		Revoke: b.secretStackServiceAccountRevoke,
+	}
+}
+
+func (b *backend) secretStackServiceAccountRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	configName, _ := req.Secret.InternalData["config"].(string)
+	c, err := b.configClient(ctx, req.Storage, configName)
+	if err != nil {
+		return nil, err
+	}
+
+	stackSlug, ok := req.Secret.InternalData["stack_slug"].(string)
+	if !ok {
+		return nil, fmt.Errorf("stack_slug is missing on the lease")
+	}
+	id, err := leaseInt(req.Secret.InternalData, "service_account_id")
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Info(fmt.Sprintf("Revoking grafana stack service account (stack: %s, name: %v, id: %d)...", stackSlug, req.Secret.InternalData["name"], id))
+	if err := c.DeleteStackServiceAccount(ctx, stackSlug, id); err != nil {
+		return nil, err
+	}
+	role, _ := req.Secret.InternalData["role"].(string)
+	recordTokens("revoked", role, 1)
+
+	if role != "" {
+		if err := b.forgetIssuedToken(ctx, req.Storage, role, strconv.Itoa(id)); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
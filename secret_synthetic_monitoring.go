@@ -0,0 +1,70 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	SecretSyntheticMonitoringType = "synthetic_monitoring"
+)
+
+func secretSyntheticMonitoring(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretSyntheticMonitoringType,
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: "Synthetic Monitoring API token",
+			},
+			"api_url": {
+				Type:        framework.TypeString,
+				Description: "URL of the Synthetic Monitoring API the token is valid for",
+			},
+			"stack_slug": {
+				Type:        framework.TypeString,
+				Description: "Slug of the stack the token belongs to",
+			},
+		},
+
+		Revoke: b.secretSyntheticMonitoringRevoke,
+	}
+}
+
+func (b *backend) secretSyntheticMonitoringRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	configName, _ := req.Secret.InternalData["config"].(string)
+	c, err := b.configClient(ctx, req.Storage, configName)
+	if err != nil {
+		return nil, err
+	}
+
+	stackSlug, ok := req.Secret.InternalData["stack_slug"].(string)
+	if !ok {
+		return nil, fmt.Errorf("stack_slug is missing on the lease")
+	}
+	token, ok := req.Secret.InternalData["token"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token is missing on the lease")
+	}
+	smURL, _ := req.Secret.InternalData["api_url"].(string)
+	// Leases issued before the access token was kept on them have none
+	accessToken, _ := req.Secret.InternalData["access_token"].(string)
+
+	b.Logger().Info(fmt.Sprintf("Revoking synthetic monitoring token (stack: %s)...", stackSlug))
+	if err := deleteSyntheticMonitoringToken(ctx, c, stackSlug, smURL, accessToken, token); err != nil {
+		return nil, err
+	}
+	role, _ := req.Secret.InternalData["role"].(string)
+	recordTokens("revoked", role, 1)
+
+	if role != "" {
+		if err := b.forgetIssuedToken(ctx, req.Storage, role, syntheticMonitoringTokenID(token)); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
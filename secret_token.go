@@ -2,10 +2,13 @@ package grafanacloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -33,6 +36,18 @@ func secretToken(b *backend) *framework.Secret {
 				Type:        framework.TypeString,
 				Description: "ID of the Access Policy the token belongs to",
 			},
+			"access_policy": {
+				Type:        framework.TypeString,
+				Description: "Name of the access policy in Vault the token was issued for",
+			},
+			"expires_at": {
+				Type:        framework.TypeTime,
+				Description: "Time the token expires at in Grafana Cloud",
+			},
+			"region": {
+				Type:        framework.TypeString,
+				Description: "Region of the Grafana Cloud API the token was issued in",
+			},
 		},
 
 		Renew:  b.secretTokenRenew,
@@ -49,7 +64,9 @@ func (b *backend) secretTokenRenew(ctx context.Context, req *logical.Request, d
 		lease = &configLease{}
 	}
 
-	c, err := b.client(ctx, req.Storage)
+	configName, _ := req.Secret.InternalData["config"].(string)
+	region, _ := req.Secret.InternalData["region"].(string)
+	c, err := b.regionClient(ctx, req.Storage, configName, region)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +81,7 @@ func (b *backend) secretTokenRenew(ctx context.Context, req *logical.Request, d
 		return nil, fmt.Errorf("id is missing on the lease")
 	}
 
-	err = c.UpdateToken(id.(string), time.Now().UTC().Add(ttl))
+	err = c.UpdateToken(ctx, id.(string), time.Now().UTC().Add(ttl))
 	if err != nil {
 		return nil, fmt.Errorf("failed to update token %s: %w", id.(string), err)
 	}
@@ -77,7 +94,9 @@ func (b *backend) secretTokenRenew(ctx context.Context, req *logical.Request, d
 }
 
 func (b *backend) secretTokenRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	c, err := b.client(ctx, req.Storage)
+	configName, _ := req.Secret.InternalData["config"].(string)
+	region, _ := req.Secret.InternalData["region"].(string)
+	c, err := b.regionClient(ctx, req.Storage, configName, region)
 	if err != nil {
 		return nil, err
 	}
@@ -86,21 +105,80 @@ func (b *backend) secretTokenRevoke(ctx context.Context, req *logical.Request, d
 		return nil, fmt.Errorf("error getting Nomad client")
 	}
 
-	id, ok := req.Secret.InternalData["id"]
+	// Leases of batches issued with count hold every token under "tokens"
+	if tokens, ok := req.Secret.InternalData["tokens"].([]interface{}); ok {
+		for _, raw := range tokens {
+			internal, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid token on the lease")
+			}
+			if err := b.revokeToken(ctx, req.Storage, c, internal); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	}
+
+	if err := b.revokeToken(ctx, req.Storage, c, req.Secret.InternalData); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// revokeToken deletes the token described by the internal data of its lease
+// along with the access policy it owns
+func (b *backend) revokeToken(ctx context.Context, s logical.Storage, c GrafanaClient, internal map[string]interface{}) error {
+	id, ok := internal["id"]
 	if !ok {
-		return nil, fmt.Errorf("id is missing on the lease")
+		return fmt.Errorf("id is missing on the lease")
 	}
 
-	name, ok := req.Secret.InternalData["name"]
+	name, ok := internal["name"]
 	if !ok {
-		return nil, fmt.Errorf("name is missing on the lease")
+		return fmt.Errorf("name is missing on the lease")
 	}
 
+	// Reused tokens are shared by several leases and only deleted with the
+	// last one
+	if reused, _ := internal["reused"].(bool); reused {
+		role, _ := internal["role"].(string)
+		lock := locksutil.LockForKey(b.issueLocks, role)
+		lock.Lock()
+		defer lock.Unlock()
+
+		last, err := b.releaseReusedToken(ctx, s, role, id.(string))
+		if err != nil {
+			return err
+		}
+		if !last {
+			return nil
+		}
+	}
+
+	// The token is gone already when its access policy was deleted in
+	// grafana cloud
 	b.Logger().Info(fmt.Sprintf("Revoking grafana-cloud token (name: %s, id: %s)...", name, id))
-	err = c.DeleteToken(id.(string))
-	if err != nil {
-		return nil, err
+	if err := c.DeleteToken(ctx, id.(string)); err != nil && !errors.Is(err, gcom.ErrNotFound) {
+		return err
 	}
+	role, _ := internal["role"].(string)
+	recordTokens("revoked", role, 1)
 
-	return nil, nil
+	if role != "" {
+		if err := b.forgetIssuedToken(ctx, s, role, id.(string)); err != nil {
+			return err
+		}
+	}
+
+	// Tokens issued from an access_policy_template own their access policy
+	if policyID, ok := internal["ephemeral_access_policy_id"].(string); ok && policyID != "" {
+		b.Logger().Info(fmt.Sprintf("Deleting grafana-cloud access policy (id: %s)...", policyID))
+		if _, err := c.DeleteAccessPolicy(ctx, policyID); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
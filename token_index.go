@@ -0,0 +1,62 @@
+package grafanacloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const issuedTokensPrefix = "issued/"
+
+// issuedToken records a token that has an outstanding lease so the number of
+// active tokens per role can be tracked
+type issuedToken struct {
+	ID       string    `json:"id"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+func issuedTokensPath(roleName string) string {
+	return issuedTokensPrefix + roleName + "/"
+}
+
+// countIssuedTokens returns the number of tokens of the role with an
+// outstanding lease
+func (b *backend) countIssuedTokens(ctx context.Context, s logical.Storage, roleName string) (int, error) {
+	ids, err := s.List(ctx, issuedTokensPath(roleName))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+func (b *backend) recordIssuedToken(ctx context.Context, s logical.Storage, roleName string, id string) error {
+	entry, err := logical.StorageEntryJSON(issuedTokensPath(roleName)+id, issuedToken{
+		ID:       id,
+		IssuedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+func (b *backend) forgetIssuedToken(ctx context.Context, s logical.Storage, roleName string, id string) error {
+	return s.Delete(ctx, issuedTokensPath(roleName)+id)
+}
+
+// recordIssuedCredential records a credential of the role that has an
+// outstanding lease. The lease of a credential that can not be recorded is
+// never returned, so revoke is called to not leave it behind.
+func (b *backend) recordIssuedCredential(ctx context.Context, s logical.Storage, roleName string, id string, revoke func() error) error {
+	if err := b.recordIssuedToken(ctx, s, roleName, id); err != nil {
+		if revokeErr := revoke(); revokeErr != nil {
+			b.Logger().Error("failed to revoke credential that could not be recorded", "role", roleName, "id", id, "error", revokeErr)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package grafanacloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/template"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// tokenNameRegex matches the names Grafana Cloud accepts for access policy
+// tokens
+var tokenNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// validateTokenName ensures Grafana Cloud accepts the name for an access
+// policy token
+func validateTokenName(name string) error {
+	if !tokenNameRegex.MatchString(name) {
+		return fmt.Errorf("token name '%s' must only contain lowercase alphanumeric characters and dashes, and start and end with an alphanumeric character", name)
+	}
+
+	return nil
+}
+
+// tokenNameTemplateData is the data available to token name and display name
+// templates of roles
+type tokenNameTemplateData struct {
+	RoleName      string
+	MountAccessor string
+	EntityID      string
+	EntityName    string
+	DisplayName   string
+	Metadata      map[string]string
+}
+
+func newTokenNameTemplate(rawTemplate string) (template.StringTemplate, error) {
+	return template.NewTemplate(
+		template.Template(rawTemplate),
+		template.Function("unix_time_nano", func() string {
+			return strconv.FormatInt(time.Now().UnixNano(), 10)
+		}),
+	)
+}
+
+// validateTokenNameTemplate ensures the template parses and renders
+func validateTokenNameTemplate(rawTemplate string) error {
+	tmpl, err := newTokenNameTemplate(rawTemplate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpl.Generate(tokenNameTemplateData{
+		RoleName:      "role",
+		MountAccessor: "accessor",
+		EntityID:      "entity-id",
+		EntityName:    "entity",
+		DisplayName:   "display-name",
+		Metadata:      map[string]string{},
+	})
+	return err
+}
+
+// formatTokenMetadata renders metadata as comma separated key=value pairs
+// sorted by key
+func formatTokenMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+metadata[key])
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// tokenNames returns the name and display name of a token issued for the
+// role. The name defaults to createTokenName and the display name to the name
+// when the role does not template them.
+func (b *backend) tokenNames(req *logical.Request, roleName string, role *roleEntry) (string, string, error) {
+	return b.renderTokenNames(req, roleName, role, createTokenName(roleName))
+}
+
+// renderTokenNames returns the tokenNames of the role with tokenName as the
+// default name
+func (b *backend) renderTokenNames(req *logical.Request, roleName string, role *roleEntry, tokenName string) (string, string, error) {
+	data := tokenNameTemplateData{
+		RoleName:      roleName,
+		MountAccessor: req.MountAccessor,
+		EntityID:      req.EntityID,
+		DisplayName:   req.DisplayName,
+		Metadata:      role.Metadata,
+	}
+	if req.EntityID != "" && (role.TokenNameTemplate != "" || role.DisplayNameTemplate != "") {
+		entity, err := b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to look up entity '%s': %w", req.EntityID, err)
+		}
+		if entity != nil {
+			data.EntityName = entity.Name
+		}
+	}
+
+	if role.TokenNameTemplate != "" {
+		tmpl, err := newTokenNameTemplate(role.TokenNameTemplate)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid token_name_template: %w", err)
+		}
+		tokenName, err = tmpl.Generate(data)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to render token_name_template: %w", err)
+		}
+	}
+	if len(tokenName) > maxTokenNameLength {
+		return "", "", fmt.Errorf("token name '%s' is longer than %d characters", tokenName, maxTokenNameLength)
+	}
+
+	displayName := tokenName
+	if role.DisplayName != "" {
+		displayName = role.DisplayName
+	}
+	if len(role.Metadata) > 0 {
+		displayName = fmt.Sprintf("%s [%s]", displayName, formatTokenMetadata(role.Metadata))
+	}
+	if role.DisplayNameTemplate != "" {
+		tmpl, err := newTokenNameTemplate(role.DisplayNameTemplate)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid display_name_template: %w", err)
+		}
+		displayName, err = tmpl.Generate(data)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to render display_name_template: %w", err)
+		}
+	}
+
+	return tokenName, displayName, nil
+}
+
+// idempotencyKeyHashLength is the number of hex characters of the hash of the
+// idempotency key in the names of issued tokens
+const idempotencyKeyHashLength = 12
+
+// idempotentTokenName returns the name of the seq-th token issued for the role
+// by requests with the given idempotency key, so a token left behind by an
+// earlier attempt of the request can be recognized by its name
+func idempotentTokenName(roleName string, key string, seq int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", key, seq)))
+	return fmt.Sprintf("vault-%s-%s", strings.ToLower(roleName), hex.EncodeToString(sum[:])[:idempotencyKeyHashLength])
+}
+
+// idempotentTokenNames returns the tokenNames of the role with the name
+// replaced by its idempotentTokenName
+func (b *backend) idempotentTokenNames(req *logical.Request, roleName string, role *roleEntry, key string, seq int) (string, string, error) {
+	if role.TokenNameTemplate != "" {
+		return "", "", fmt.Errorf("idempotency_key can not be used with a token_name_template")
+	}
+
+	return b.renderTokenNames(req, roleName, role, idempotentTokenName(roleName, key, seq))
+}
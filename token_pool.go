@@ -0,0 +1,306 @@
+package grafanacloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const tokenPoolPrefix = "pool/"
+
+// pooledToken is a token created ahead of time so creds reads can be served
+// from storage instead of waiting on the grafana cloud api
+type pooledToken struct {
+	ID             string    `json:"id"`
+	AccessPolicyID string    `json:"access_policy_id"`
+	Name           string    `json:"name"`
+	Token          string    `json:"token"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+func tokenPoolPath(policyName string) string {
+	return tokenPoolPrefix + policyName + "/"
+}
+
+// popPooledToken removes and returns a token of the access policy with the
+// given id from its pool that is valid for at least minTTL. Returns nil when
+// the pool is empty.
+func (b *backend) popPooledToken(ctx context.Context, s logical.Storage, policyName string, accessPolicyID string, minTTL time.Duration) (*pooledToken, error) {
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+
+	ids, err := s.List(ctx, tokenPoolPath(policyName))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		entry, err := s.Get(ctx, tokenPoolPath(policyName)+id)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		var token pooledToken
+		if err := entry.DecodeJSON(&token); err != nil {
+			return nil, fmt.Errorf("error reading pooled token '%s': %w", id, err)
+		}
+		// Leave tokens of a replaced access policy and expiring ones for the
+		// periodic refill to clean up
+		if token.AccessPolicyID != accessPolicyID || time.Until(token.ExpiresAt) < minTTL {
+			continue
+		}
+
+		if err := s.Delete(ctx, tokenPoolPath(policyName)+id); err != nil {
+			return nil, err
+		}
+
+		return &token, nil
+	}
+
+	return nil, nil
+}
+
+// refillTokenPool deletes pooled tokens that are about to expire or belong to
+// a replaced access policy and creates new ones until the pool of the access
+// policy holds entry.PoolSize tokens
+func (b *backend) refillTokenPool(ctx context.Context, s logical.Storage, c GrafanaClient, policyName string, entry *accessPolicyEntry, ttl time.Duration) error {
+	lock := locksutil.LockForKey(b.poolRefillLocks, policyName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	stale, available, err := b.takeStalePooledTokens(ctx, s, policyName, entry, ttl)
+	if err != nil {
+		return err
+	}
+
+	// The stale tokens are already out of the pool, so they are deleted
+	// without holding poolLock. They expire on their own if this fails.
+	for _, token := range stale {
+		if err := c.DeleteToken(ctx, token.ID); err != nil && !errors.Is(err, gcom.ErrNotFound) {
+			return fmt.Errorf("failed to delete pooled token '%s': %w", token.ID, err)
+		}
+	}
+
+	for ; available < entry.PoolSize; available++ {
+		tokenName := createTokenName(policyName)
+		token, err := c.CreateToken(ctx, gcom.CreateTokenRequest{
+			AccessPolicyID: entry.Policy.ID,
+			Name:           tokenName,
+			DisplayName:    tokenName,
+			ExpiresAt:      time.Now().UTC().Add(ttl),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create pooled token for '%s': %w", policyName, err)
+		}
+
+		if err := b.putPooledToken(ctx, s, policyName, pooledToken{
+			ID:             token.ID,
+			AccessPolicyID: token.AccessPolicyID,
+			Name:           token.Name,
+			Token:          token.Token,
+			ExpiresAt:      token.ExpiresAt,
+		}); err != nil {
+			if deleteErr := c.DeleteToken(ctx, token.ID); deleteErr != nil {
+				b.Logger().Error("failed to delete pooled token that could not be stored", "policy", policyName, "id", token.ID, "error", deleteErr)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// takeStalePooledTokens removes the pooled tokens of the access policy that
+// should be replaced from its pool and returns them, along with the number of
+// tokens left in the pool
+func (b *backend) takeStalePooledTokens(ctx context.Context, s logical.Storage, policyName string, entry *accessPolicyEntry, ttl time.Duration) ([]pooledToken, int, error) {
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+
+	ids, err := s.List(ctx, tokenPoolPath(policyName))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var stale []pooledToken
+	available := 0
+	for _, id := range ids {
+		raw, err := s.Get(ctx, tokenPoolPath(policyName)+id)
+		if err != nil {
+			return nil, 0, err
+		}
+		if raw == nil {
+			continue
+		}
+
+		var token pooledToken
+		if err := raw.DecodeJSON(&token); err != nil {
+			return nil, 0, fmt.Errorf("error reading pooled token '%s': %w", id, err)
+		}
+
+		// Tokens past half of their lifetime are replaced so handed out tokens
+		// are not much shorter lived than freshly issued ones
+		if entry.PoolSize > 0 && available < entry.PoolSize && token.AccessPolicyID == entry.Policy.ID && time.Until(token.ExpiresAt) > ttl/2 {
+			available++
+			continue
+		}
+
+		if err := s.Delete(ctx, tokenPoolPath(policyName)+id); err != nil {
+			return nil, 0, err
+		}
+		stale = append(stale, token)
+	}
+
+	return stale, available, nil
+}
+
+func (b *backend) putPooledToken(ctx context.Context, s logical.Storage, policyName string, token pooledToken) error {
+	entry, err := logical.StorageEntryJSON(tokenPoolPath(policyName)+token.ID, token)
+	if err != nil {
+		return err
+	}
+
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+
+	return s.Put(ctx, entry)
+}
+
+// refillTokenPools tops up the pool of every access policy with a pool_size
+func (b *backend) refillTokenPools(ctx context.Context, s logical.Storage) error {
+	names, err := s.List(ctx, "access_policies/")
+	if err != nil {
+		return err
+	}
+
+	lease, err := b.LeaseConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	if lease == nil {
+		lease = &configLease{}
+	}
+	ttl, _, err := framework.CalculateTTL(b.System(), 0, lease.TTL, 0, lease.MaxTTL, 0, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		entry, err := b.accessPoliciesRead(ctx, s, name)
+		if err != nil {
+			return err
+		}
+		if entry == nil || entry.PoolSize <= 0 || entry.inactive() {
+			continue
+		}
+
+		c, err := b.configClient(ctx, s, entry.Config)
+		if err != nil {
+			b.Logger().Error("failed to refill token pool", "policy", name, "error", err)
+			continue
+		}
+
+		if err := b.refillTokenPool(ctx, s, c, name, entry, ttl); err != nil {
+			b.Logger().Error("failed to refill token pool", "policy", name, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// drainTokenPool deletes every pooled token of the access policy
+func (b *backend) drainTokenPool(ctx context.Context, s logical.Storage, c GrafanaClient, policyName string) error {
+	lock := locksutil.LockForKey(b.poolRefillLocks, policyName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	drained, err := b.takePooledTokens(ctx, s, policyName)
+	if err != nil {
+		return err
+	}
+
+	// The tokens are already out of the pool, so they are deleted without
+	// holding poolLock. They expire on their own if this fails.
+	var errs []error
+	for _, token := range drained {
+		if err := c.DeleteToken(ctx, token.ID); err != nil && !errors.Is(err, gcom.ErrNotFound) {
+			errs = append(errs, fmt.Errorf("failed to delete pooled token '%s': %w", token.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// takePooledTokens removes every token of the access policy from its pool and
+// returns them
+func (b *backend) takePooledTokens(ctx context.Context, s logical.Storage, policyName string) ([]pooledToken, error) {
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+
+	ids, err := s.List(ctx, tokenPoolPath(policyName))
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []pooledToken
+	for _, id := range ids {
+		raw, err := s.Get(ctx, tokenPoolPath(policyName)+id)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			continue
+		}
+
+		var token pooledToken
+		if err := raw.DecodeJSON(&token); err != nil {
+			return nil, fmt.Errorf("error reading pooled token '%s': %w", id, err)
+		}
+		if err := s.Delete(ctx, tokenPoolPath(policyName)+id); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// moveTokenPool moves the pooled tokens of an access policy when it is renamed
+func (b *backend) moveTokenPool(ctx context.Context, s logical.Storage, from string, to string) error {
+	b.poolLock.Lock()
+	defer b.poolLock.Unlock()
+
+	ids, err := s.List(ctx, tokenPoolPath(from))
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		entry, err := s.Get(ctx, tokenPoolPath(from)+id)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		entry.Key = tokenPoolPath(to) + id
+		if err := s.Put(ctx, entry); err != nil {
+			return err
+		}
+		if err := s.Delete(ctx, tokenPoolPath(from)+id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package grafanacloud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_token_pool_policy_id_change_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	var ids []string
+	for _, name := range []string{"readers-old", "readers-new"} {
+		policy, err := fake.CreateAccessPolicy(context.Background(), map[string]interface{}{
+			"name":   name,
+			"scopes": []string{"metrics:read"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, policy.ID)
+	}
+	oldID, newID := ids[0], ids[1]
+
+	entry := &accessPolicyEntry{PoolSize: 1}
+	entry.Policy.ID = oldID
+	if err := b.refillTokenPool(context.Background(), s, fake, "readers", entry, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, fake.tokens, 1)
+
+	// Tokens of the replaced policy are neither handed out nor kept
+	pooled, err := b.popPooledToken(context.Background(), s, "readers", newID, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, pooled)
+
+	entry.Policy.ID = newID
+	if err := b.refillTokenPool(context.Background(), s, fake, "readers", entry, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, fake.tokens, 1) {
+		for _, token := range fake.tokens {
+			assert.Equal(t, newID, token.AccessPolicyID)
+		}
+	}
+
+	pooled, err = b.popPooledToken(context.Background(), s, "readers", newID, 0)
+	if assert.NoError(t, err) && assert.NotNil(t, pooled) {
+		assert.Equal(t, newID, pooled.AccessPolicyID)
+	}
+
+	// Draining deletes the pooled tokens but not the handed out one
+	entry.PoolSize = 2
+	if err := b.refillTokenPool(context.Background(), s, fake, "readers", entry, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, fake.tokens, 3)
+	assert.NoError(t, b.drainTokenPool(context.Background(), s, fake, "readers"))
+	assert.Len(t, fake.tokens, 1)
+	assert.Contains(t, fake.tokens, pooled.ID)
+	ids, err = s.List(context.Background(), tokenPoolPath("readers"))
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
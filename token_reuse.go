@@ -0,0 +1,139 @@
+package grafanacloud
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const reusedTokensPrefix = "reused/"
+
+// reusedToken is a token handed out to every creds read of a role with a
+// reuse_window until the window passes. The token is deleted once all of its
+// leases are revoked.
+type reusedToken struct {
+	Data         map[string]interface{} `json:"data"`
+	InternalData map[string]interface{} `json:"internal_data"`
+	ExpiresAt    time.Time              `json:"expires_at"`
+	IssuedAt     time.Time              `json:"issued_at"`
+	Leases       int                    `json:"leases"`
+}
+
+func reusedTokensPath(roleName string) string {
+	return reusedTokensPrefix + roleName + "/"
+}
+
+func (b *backend) readReusedToken(ctx context.Context, s logical.Storage, roleName string, id string) (*reusedToken, error) {
+	raw, err := s.Get(ctx, reusedTokensPath(roleName)+id)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+
+	var token reusedToken
+	if err := raw.DecodeJSON(&token); err != nil {
+		return nil, fmt.Errorf("error reading reused token '%s': %w", id, err)
+	}
+
+	return &token, nil
+}
+
+func (b *backend) writeReusedToken(ctx context.Context, s logical.Storage, roleName string, id string, token *reusedToken) error {
+	entry, err := logical.StorageEntryJSON(reusedTokensPath(roleName)+id, token)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// reuseToken returns a new lease on the token issued for the role within its
+// reuse_window, or nil when there is none. Must be called with the issue lock
+// of the role held.
+func (b *backend) reuseToken(ctx context.Context, s logical.Storage, issue *credsIssue) (*logical.Response, error) {
+	ids, err := s.List(ctx, reusedTokensPath(issue.name))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		token, err := b.readReusedToken(ctx, s, issue.name, id)
+		if err != nil {
+			return nil, err
+		}
+		if token == nil || time.Since(token.IssuedAt) >= issue.role.ReuseWindow {
+			continue
+		}
+		// Tokens of an access policy that was since recreated were deleted
+		// along with it. Their entry is kept until their last lease is
+		// revoked.
+		if issue.policy != nil && token.InternalData["access_policy_id"] != issue.policy.Policy.ID {
+			continue
+		}
+
+		ttl := issue.ttl
+		if !token.ExpiresAt.IsZero() && time.Until(token.ExpiresAt) < ttl {
+			ttl = time.Until(token.ExpiresAt)
+		}
+		if ttl <= 0 {
+			continue
+		}
+
+		token.Leases++
+		if err := b.writeReusedToken(ctx, s, issue.name, id, token); err != nil {
+			return nil, err
+		}
+
+		resp := b.Secret(SecretTokenType).Response(token.Data, token.InternalData)
+		resp.Secret.TTL = ttl
+		resp.Secret.MaxTTL = issue.maxTTL
+		resp.Secret.Renewable = false
+
+		return resp, nil
+	}
+
+	return nil, nil
+}
+
+// rememberReusedToken stores the token of resp so it is reused by creds reads
+// of the role within its reuse_window
+func (b *backend) rememberReusedToken(ctx context.Context, s logical.Storage, roleName string, resp *logical.Response) error {
+	id, ok := resp.Secret.InternalData["id"].(string)
+	if !ok {
+		return fmt.Errorf("id is missing on the lease")
+	}
+	resp.Secret.InternalData["reused"] = true
+
+	expiresAt, _ := resp.Data["expires_at"].(time.Time)
+	return b.writeReusedToken(ctx, s, roleName, id, &reusedToken{
+		Data:         resp.Data,
+		InternalData: resp.Secret.InternalData,
+		ExpiresAt:    expiresAt,
+		IssuedAt:     time.Now().UTC(),
+		Leases:       1,
+	})
+}
+
+// releaseReusedToken drops a lease of a reused token and reports whether it
+// was the last one, in which case the token should be revoked
+func (b *backend) releaseReusedToken(ctx context.Context, s logical.Storage, roleName string, id string) (bool, error) {
+	token, err := b.readReusedToken(ctx, s, roleName, id)
+	if err != nil {
+		return false, err
+	}
+	// Entries are only deleted by the last lease
+	if token == nil {
+		return true, nil
+	}
+
+	token.Leases--
+	if token.Leases > 0 {
+		return false, b.writeReusedToken(ctx, s, roleName, id, token)
+	}
+
+	return true, s.Delete(ctx, reusedTokensPath(roleName)+id)
+}
@@ -0,0 +1,400 @@
+package grafanacloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	walRootTokenKind           = "root_token"
+	walIssuedTokenKind         = "issued_token"
+	walStaticTokenKind         = "static_token"
+	walPolicyKind              = "access_policy"
+	walStackCredKind           = "stack_credential"
+	walSyntheticMonitoringKind = "synthetic_monitoring_token"
+)
+
+// walRootToken records a root token being created by a rotation so it can be
+// deleted if the rotation does not finish
+type walRootToken struct {
+	Config    string `json:"config" mapstructure:"config"`
+	TokenName string `json:"token_name" mapstructure:"token_name"`
+}
+
+// walIssuedToken records a token being issued by a creds request so it can be
+// deleted if vault stops before the lease is returned
+type walIssuedToken struct {
+	Role      string `json:"role" mapstructure:"role"`
+	Config    string `json:"config" mapstructure:"config"`
+	Region    string `json:"region" mapstructure:"region"`
+	TokenName string `json:"token_name" mapstructure:"token_name"`
+	// EphemeralPolicyName is the name of the access policy created for the
+	// token from the access_policy_template of the role, if any
+	EphemeralPolicyName string `json:"ephemeral_policy_name" mapstructure:"ephemeral_policy_name"`
+}
+
+// walStaticToken records a token being created by the rotation of a static
+// role so it can be deleted if the rotation does not finish
+type walStaticToken struct {
+	Role      string `json:"role" mapstructure:"role"`
+	Config    string `json:"config" mapstructure:"config"`
+	TokenName string `json:"token_name" mapstructure:"token_name"`
+}
+
+// walPolicy records an access policy being created by a write of
+// access_policies/:name so it can be deleted if it never gets stored. ID is
+// set once grafana cloud returned the created policy.
+type walPolicy struct {
+	Config string `json:"config" mapstructure:"config"`
+	Name   string `json:"name" mapstructure:"name"`
+	ID     string `json:"id" mapstructure:"id"`
+}
+
+// createAccessPolicyWithWAL creates the access policy name under a WAL
+// recording its id, so it is deleted if vault stops before it is stored. The
+// WAL is deleted when the policy is not created, and its id returned
+// otherwise for the caller to delete once the policy is stored.
+func createAccessPolicyWithWAL(ctx context.Context, s logical.Storage, c GrafanaClient, config string, name string, body map[string]interface{}) (*gcom.AccessPolicy, string, error) {
+	walEntry := &walPolicy{
+		Config: config,
+		Name:   name,
+	}
+	walID, err := framework.PutWAL(ctx, s, walPolicyKind, walEntry)
+	if err != nil {
+		return nil, "", fmt.Errorf("error writing WAL entry: %w", err)
+	}
+
+	created, err := c.CreateAccessPolicy(ctx, body)
+	if err != nil {
+		// Nothing is known to have been created, and rolling back by name
+		// could delete a policy another write created
+		if walErr := framework.DeleteWAL(ctx, s, walID); walErr != nil {
+			return nil, "", errors.Join(err, fmt.Errorf("error deleting WAL entry: %w", walErr))
+		}
+		return nil, "", err
+	}
+
+	walEntry.ID = created.ID
+	newWALID, err := framework.PutWAL(ctx, s, walPolicyKind, walEntry)
+	if err != nil {
+		// The WAL without the id cannot roll the policy back, so delete it
+		// while its id is still known
+		err = fmt.Errorf("error writing WAL entry: %w", err)
+		if _, delErr := c.DeleteAccessPolicy(ctx, created.ID); delErr != nil {
+			return nil, "", errors.Join(err, fmt.Errorf("error deleting access policy '%s': %w", created.ID, delErr))
+		}
+		if walErr := framework.DeleteWAL(ctx, s, walID); walErr != nil {
+			return nil, "", errors.Join(err, fmt.Errorf("error deleting WAL entry: %w", walErr))
+		}
+		return nil, "", err
+	}
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		return nil, newWALID, fmt.Errorf("error deleting WAL entry: %w", err)
+	}
+
+	return created, newWALID, nil
+}
+
+// walStackCred records a stack API key or service account being created by a
+// creds request so it can be deleted if vault stops before the lease is
+// returned
+type walStackCred struct {
+	Role           string `json:"role" mapstructure:"role"`
+	Config         string `json:"config" mapstructure:"config"`
+	CredentialType string `json:"credential_type" mapstructure:"credential_type"`
+	StackSlug      string `json:"stack_slug" mapstructure:"stack_slug"`
+	Name           string `json:"name" mapstructure:"name"`
+}
+
+// walSyntheticMonitoringToken records a Synthetic Monitoring token issued by a
+// creds request so it can be deleted if vault stops before the lease is
+// returned
+type walSyntheticMonitoringToken struct {
+	Role      string `json:"role" mapstructure:"role"`
+	Config    string `json:"config" mapstructure:"config"`
+	StackSlug string `json:"stack_slug" mapstructure:"stack_slug"`
+	APIURL    string `json:"api_url" mapstructure:"api_url"`
+	Token     string `json:"token" mapstructure:"token"`
+}
+
+func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	switch kind {
+	case walRootTokenKind:
+		return b.rootTokenRollback(ctx, req, data)
+	case walIssuedTokenKind:
+		return b.issuedTokenRollback(ctx, req, data)
+	case walStaticTokenKind:
+		return b.staticTokenRollback(ctx, req, data)
+	case walPolicyKind:
+		return b.policyRollback(ctx, req, data)
+	case walStackCredKind:
+		return b.stackCredRollback(ctx, req, data)
+	case walSyntheticMonitoringKind:
+		return b.syntheticMonitoringTokenRollback(ctx, req, data)
+	default:
+		return fmt.Errorf("unknown rollback type %q", kind)
+	}
+}
+
+// rootTokenRollback deletes the token created by an interrupted rotation
+// unless it ended up stored as the root token
+func (b *backend) rootTokenRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walRootToken
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	conf, err := b.readConfigToken(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		// Without a configuration there is no token to delete it with
+		b.Logger().Warn("dropping root token rollback of deleted configuration", "config", configTokenStorageKey(entry.Config), "token_name", entry.TokenName)
+		return nil
+	}
+	if conf.TokenName == entry.TokenName {
+		return nil
+	}
+
+	client, err := b.configClient(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+
+	return deleteTokenByName(ctx, client, entry.TokenName)
+}
+
+// issuedTokenRollback deletes the token created by an interrupted creds
+// request, which no lease will ever revoke, along with its ephemeral access
+// policy
+func (b *backend) issuedTokenRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walIssuedToken
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	conf, err := b.readConfigToken(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		b.Logger().Warn("dropping issued token rollback of deleted configuration", "config", configTokenStorageKey(entry.Config), "token_name", entry.TokenName)
+		return nil
+	}
+
+	client, err := b.regionClient(ctx, req.Storage, entry.Config, entry.Region)
+	if err != nil {
+		return err
+	}
+
+	token, err := client.GetTokenByName(ctx, entry.TokenName)
+	if err != nil && !errors.Is(err, gcom.ErrNotFound) {
+		return err
+	}
+	if token != nil {
+		// A retry of the request with the same idempotency_key reuses the
+		// name, and the lease of the token it issued revokes it
+		issued, err := req.Storage.Get(ctx, issuedTokensPath(entry.Role)+token.ID)
+		if err != nil {
+			return err
+		}
+		if issued != nil {
+			return nil
+		}
+		if err := client.DeleteToken(ctx, token.ID); err != nil {
+			return err
+		}
+	}
+	if entry.EphemeralPolicyName == "" {
+		return nil
+	}
+
+	policy, err := client.GetAccessPolicyByName(ctx, entry.EphemeralPolicyName)
+	if err != nil || policy == nil {
+		return err
+	}
+	_, err = client.DeleteAccessPolicy(ctx, policy.ID)
+
+	return err
+}
+
+// staticTokenRollback deletes the token created by an interrupted rotation of
+// a static role unless it ended up stored as the token of the role
+func (b *backend) staticTokenRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walStaticToken
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	role, err := b.staticRoleRead(ctx, req.Storage, entry.Role)
+	if err != nil {
+		return err
+	}
+	if role != nil && role.TokenName == entry.TokenName {
+		return nil
+	}
+
+	conf, err := b.readConfigToken(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		b.Logger().Warn("dropping static token rollback of deleted configuration", "config", configTokenStorageKey(entry.Config), "token_name", entry.TokenName)
+		return nil
+	}
+
+	client, err := b.configClient(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+
+	return deleteTokenByName(ctx, client, entry.TokenName)
+}
+
+// deleteTokenByName deletes the token with the given name, if it exists
+func deleteTokenByName(ctx context.Context, c GrafanaClient, name string) error {
+	token, err := c.GetTokenByName(ctx, name)
+	if errors.Is(err, gcom.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteToken(ctx, token.ID)
+}
+
+// stackCredRollback deletes the stack API key or service account created by an
+// interrupted creds request unless it was recorded as issued
+func (b *backend) stackCredRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walStackCred
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	conf, err := b.readConfigToken(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		b.Logger().Warn("dropping stack credential rollback of deleted configuration", "config", configTokenStorageKey(entry.Config), "name", entry.Name)
+		return nil
+	}
+
+	client, err := b.configClient(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+
+	var id int
+	switch entry.CredentialType {
+	case credentialTypeStackAPIKey:
+		key, err := client.GetStackAPIKeyByName(ctx, entry.StackSlug, entry.Name)
+		if err != nil || key == nil {
+			return err
+		}
+		id = key.ID
+	case credentialTypeStackServiceAccount:
+		account, err := client.GetStackServiceAccountByName(ctx, entry.StackSlug, entry.Name)
+		if err != nil || account == nil {
+			return err
+		}
+		id = account.ID
+	default:
+		return fmt.Errorf("unknown stack credential type %q", entry.CredentialType)
+	}
+
+	recorded, err := req.Storage.Get(ctx, issuedTokensPath(entry.Role)+strconv.Itoa(id))
+	if err != nil {
+		return err
+	}
+	if recorded != nil {
+		return nil
+	}
+
+	if entry.CredentialType == credentialTypeStackAPIKey {
+		return client.DeleteStackAPIKey(ctx, entry.StackSlug, id)
+	}
+
+	return client.DeleteStackServiceAccount(ctx, entry.StackSlug, id)
+}
+
+// syntheticMonitoringTokenRollback deletes the Synthetic Monitoring token of
+// an interrupted creds request unless it was recorded as issued
+func (b *backend) syntheticMonitoringTokenRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walSyntheticMonitoringToken
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+
+	recorded, err := req.Storage.Get(ctx, issuedTokensPath(entry.Role)+syntheticMonitoringTokenID(entry.Token))
+	if err != nil {
+		return err
+	}
+	if recorded != nil {
+		return nil
+	}
+
+	conf, err := b.readConfigToken(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		b.Logger().Warn("dropping synthetic monitoring token rollback of deleted configuration", "config", configTokenStorageKey(entry.Config), "stack", entry.StackSlug)
+		return nil
+	}
+
+	client, err := b.configClient(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+
+	return deleteSyntheticMonitoringToken(ctx, client, entry.StackSlug, entry.APIURL, "", entry.Token)
+}
+
+// policyRollback deletes the access policy created by an interrupted write
+// unless it ended up stored as the access policy of that name
+func (b *backend) policyRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walPolicy
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+	if entry.ID == "" {
+		b.Logger().Warn("dropping access policy rollback without the id of the created policy", "config", configTokenStorageKey(entry.Config), "name", entry.Name)
+		return nil
+	}
+
+	stored, err := b.accessPoliciesRead(ctx, req.Storage, entry.Name)
+	if err != nil {
+		return err
+	}
+	if stored != nil && stored.Policy.ID == entry.ID {
+		return nil
+	}
+
+	conf, err := b.readConfigToken(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+	if conf == nil {
+		b.Logger().Warn("dropping access policy rollback of deleted configuration", "config", configTokenStorageKey(entry.Config), "name", entry.Name)
+		return nil
+	}
+
+	client, err := b.configClient(ctx, req.Storage, entry.Config)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteAccessPolicy(ctx, entry.ID)
+
+	return err
+}
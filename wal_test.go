@@ -0,0 +1,193 @@
+package grafanacloud
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/bloominlabs/vault-plugin-secrets-grafana-cloud/gcom"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackend_root_token_rollback_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	policy, err := fake.CreateAccessPolicy(context.Background(), map[string]interface{}{
+		"name":   "vault-mount",
+		"scopes": []string{"accesspolicies:write"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"vault-mount-config-1", "vault-mount-config-2"} {
+		if _, err := fake.CreateToken(context.Background(), gcom.CreateTokenRequest{
+			AccessPolicyID: policy.ID,
+			Name:           name,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(configTokenKey, accessTokenConfig{Token: "fake", TokenName: "vault-mount-config-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token of an interrupted rotation is deleted, one that was never
+	// created is ignored, and the stored root token is kept
+	for _, name := range []string{"vault-mount-config-1", "vault-mount-config-3", "vault-mount-config-2"} {
+		err = b.walRollback(context.Background(), &logical.Request{Storage: s}, walRootTokenKind, map[string]interface{}{
+			"config":     "",
+			"token_name": name,
+		})
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, fake.tokens, 1) {
+		for _, token := range fake.tokens {
+			assert.Equal(t, "vault-mount-config-2", token.Name)
+		}
+	}
+}
+
+func TestBackend_stack_cred_rollback_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	for _, name := range []string{"vault-dashboards-1", "vault-dashboards-2"} {
+		if _, err := fake.CreateStackAPIKey(context.Background(), "mystack", gcom.CreateStackAPIKeyRequest{Name: name, Role: "Viewer"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorded, err := fake.GetStackAPIKeyByName(context.Background(), "mystack", "vault-dashboards-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.recordIssuedToken(context.Background(), s, "dashboards", strconv.Itoa(recorded.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key of an interrupted request is deleted, one that was never
+	// created is ignored, and the recorded key is kept
+	for _, name := range []string{"vault-dashboards-1", "vault-dashboards-3", "vault-dashboards-2"} {
+		err = b.walRollback(context.Background(), &logical.Request{Storage: s}, walStackCredKind, map[string]interface{}{
+			"role":            "dashboards",
+			"config":          "",
+			"credential_type": credentialTypeStackAPIKey,
+			"stack_slug":      "mystack",
+			"name":            name,
+		})
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, fake.stackKeys["mystack"], 1) {
+		assert.Contains(t, fake.stackKeys["mystack"], recorded.ID)
+	}
+}
+
+func TestBackend_synthetic_monitoring_token_rollback_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		token, err := fake.CreateSyntheticMonitoringToken(context.Background(), "https://sm.example.com", "sm-access-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := b.recordIssuedToken(context.Background(), s, "probes", syntheticMonitoringTokenID(tokens[1])); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token of an interrupted request is deleted and the recorded one
+	// is kept
+	for _, token := range tokens {
+		err := b.walRollback(context.Background(), &logical.Request{Storage: s}, walSyntheticMonitoringKind, map[string]interface{}{
+			"role":       "probes",
+			"config":     "",
+			"stack_slug": "mystack",
+			"api_url":    "https://sm.example.com",
+			"token":      token,
+		})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, map[string]bool{tokens[1]: true}, fake.smTokens["sm-access-1"])
+}
+
+func TestBackend_issued_token_rollback_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	policy, err := fake.CreateAccessPolicy(context.Background(), map[string]interface{}{
+		"name":   "vault-leaked",
+		"scopes": []string{"metrics:read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.CreateToken(context.Background(), gcom.CreateTokenRequest{
+		AccessPolicyID: policy.ID,
+		Name:           "vault-leaked",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.walRollback(context.Background(), &logical.Request{Storage: s}, walIssuedTokenKind, map[string]interface{}{
+		"config":                "",
+		"token_name":            "vault-leaked",
+		"ephemeral_policy_name": "vault-leaked",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, fake.tokens, 0)
+	assert.Len(t, fake.policies, 0)
+}
+
+func TestBackend_policy_rollback_fake(t *testing.T) {
+	b, s, fake := testFakeBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "access_policies/stored",
+		Storage:   s,
+		Data: map[string]interface{}{
+			"scopes": "metrics:read",
+		},
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("failed to create access policy: resp: %#v err: %v", resp, err)
+	}
+	walIDs, err := framework.ListWAL(context.Background(), s)
+	assert.NoError(t, err)
+	assert.Empty(t, walIDs)
+
+	stored, err := b.accessPoliciesRead(context.Background(), s, "stored")
+	assert.NoError(t, err)
+	orphaned, err := fake.CreateAccessPolicy(context.Background(), map[string]interface{}{
+		"name":   "stored",
+		"scopes": []string{"metrics:read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.CreateAccessPolicy(context.Background(), map[string]interface{}{
+		"name":   "unknown",
+		"scopes": []string{"metrics:read"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{stored.Policy.ID, orphaned.ID, ""} {
+		err := b.walRollback(context.Background(), &logical.Request{Storage: s}, walPolicyKind, map[string]interface{}{
+			"config": "",
+			"name":   "stored",
+			"id":     id,
+		})
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, fake.policies, 2)
+	assert.Contains(t, fake.policies, stored.Policy.ID)
+	assert.NotContains(t, fake.policies, orphaned.ID)
+}